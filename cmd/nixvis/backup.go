@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+func init() {
+	util.RegisterCommand(util.Command{
+		Name:  "backup",
+		Usage: "backup [-o nixvis-backup.tar.gz]  在服务运行时备份数据库和扫描状态",
+		Run:   runBackupCommand,
+	})
+	util.RegisterCommand(util.Command{
+		Name:  "restore",
+		Usage: "restore [-force] <备份文件>  校验备份后恢复数据库和扫描状态，须先停止服务",
+		Run:   runRestoreCommand,
+	})
+}
+
+// runBackupCommand 生成数据库和扫描状态的一致快照
+func runBackupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	output := flags.String("o", "", "备份文件路径，默认写入 nixvis_data/backups/")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filepath.Join(util.DataDir, "backups",
+			fmt.Sprintf("nixvis-%s.tar.gz", time.Now().Format("20060102150405")))
+	}
+
+	repository, err := storage.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	manifest, err := repository.Backup(*output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("备份完成: %s（数据库结构版本 %d）\n", *output, manifest.SchemaVersion)
+	return nil
+}
+
+// runRestoreCommand 用备份替换当前数据，原有数据移动到 backups 目录
+func runRestoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	force := flags.Bool("force", false, "不检查服务是否仍在运行")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("需要指定一个备份文件")
+	}
+
+	if driver := util.ReadConfig().Database.Driver; driver != "" && driver != "sqlite" {
+		return fmt.Errorf("%s 数据库请使用数据库自带的工具恢复", driver)
+	}
+	if !*force && serverRunning(util.ReadConfig().Server.Port) {
+		return errors.New("NixVis 服务仍在运行，请先停止服务，或使用 -force 跳过检查")
+	}
+
+	manifest, err := storage.RestoreBackup(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已恢复 %s 生成的备份（版本 %s，数据库结构版本 %d），原有数据已移动到 %s\n",
+		manifest.CreatedAt.Format("2006-01-02 15:04:05"), manifest.Version, manifest.SchemaVersion,
+		filepath.Join(util.DataDir, "backups"))
+	return nil
+}
+
+// serverRunning 判断本机的 NixVis 端口是否有服务在监听
+func serverRunning(port string) bool {
+	address := port
+	if strings.HasPrefix(address, ":") {
+		address = "127.0.0.1" + address
+	}
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
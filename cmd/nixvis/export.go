@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/beyondxinxin/nixvis/internal/export"
+	"github.com/beyondxinxin/nixvis/internal/stats"
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+func init() {
+	util.RegisterCommand(util.Command{
+		Name:  "export",
+		Usage: "export -site <网站名称或ID> [-type logs] [-format csv|ndjson|parquet] [-from 2024-01-01] [-to 2024-06-30] [-param timeRange=last7days]... [-o 文件]  导出原始日志或统计结果",
+		Run:   runExportCommand,
+	})
+}
+
+// exportParams 可重复的 -param key=value 参数，作为统计查询的请求参数
+type exportParams map[string]string
+
+func (p exportParams) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p exportParams) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("参数 %q 的格式应为 key=value", value)
+	}
+	p[key] = val
+	return nil
+}
+
+// runExportCommand 将原始日志或统计结果写入文件，未指定 -o 时写入标准输出
+func runExportCommand(args []string) error {
+	params := exportParams{}
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	site := flags.String("site", "", "网站名称或ID")
+	exportType := flags.String("type", export.LogsType, "logs 导出原始日志，其他值为统计类型，如 url、daily")
+	format := flags.String("format", export.FormatCSV, "导出格式: csv、ndjson 或 parquet")
+	from := flags.String("from", "", "导出原始日志的起始日期（含），格式 2006-01-02 或 RFC3339")
+	to := flags.String("to", "", "导出原始日志的结束日期（含）")
+	output := flags.String("o", "", "输出文件路径，默认写入标准输出")
+	flags.Var(params, "param", "统计查询参数，格式 key=value，可重复，与 /api/stats 的请求参数相同")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *site == "" {
+		flags.Usage()
+		return errors.New("缺少 -site 参数")
+	}
+
+	util.ReadConfig()
+	websiteID, ok := util.FindWebsiteID(*site)
+	if !ok {
+		return fmt.Errorf("配置中没有网站 %s", *site)
+	}
+	start, err := util.ParseDate(*from, false)
+	if err != nil {
+		return err
+	}
+	end, err := util.ParseDate(*to, true)
+	if err != nil {
+		return err
+	}
+
+	repository, err := storage.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+
+	if *exportType == export.LogsType {
+		count, err := export.Logs(repository, buffered, *format, websiteID, start, end)
+		if err != nil {
+			return err
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		if *output != "" {
+			fmt.Printf("导出完成: %d 条日志写入 %s\n", count, *output)
+		}
+		return nil
+	}
+
+	params["id"] = websiteID
+	factory := stats.NewStatsFactory(repository)
+	query, err := factory.BuildQueryFromRequest(*exportType, params)
+	if err != nil {
+		return err
+	}
+	result, err := factory.QueryStats(*exportType, query)
+	if err != nil {
+		return err
+	}
+	if err := export.Stats(result, buffered, *format); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Printf("导出完成: %s 统计写入 %s\n", *exportType, *output)
+	}
+	return nil
+}
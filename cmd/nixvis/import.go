@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/netparser"
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+func init() {
+	util.RegisterCommand(util.Command{
+		Name:  "import",
+		Usage: "import -site <网站名称或ID> [-from 2024-01-01] [-to 2024-06-30] <日志文件或目录>...  导入历史日志",
+		Run:   runImportCommand,
+	})
+}
+
+// runImportCommand 导入历史日志，不受增量扫描保留期限的限制
+func runImportCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	site := flags.String("site", "", "导入到的网站名称或ID")
+	from := flags.String("from", "", "起始日期（含），格式 2006-01-02 或 RFC3339")
+	to := flags.String("to", "", "结束日期（含），默认到首次导入时数据库中最早的记录为止")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *site == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("缺少 -site 参数或日志路径")
+	}
+
+	util.ReadConfig()
+	websiteID, ok := util.FindWebsiteID(*site)
+	if !ok {
+		return fmt.Errorf("配置中没有网站 %s", *site)
+	}
+
+	options := storage.ImportOptions{Progress: printImportProgress}
+	var err error
+	if options.From, err = util.ParseDate(*from, false); err != nil {
+		return err
+	}
+	if options.To, err = util.ParseDate(*to, true); err != nil {
+		return err
+	}
+
+	util.ConfigureLogging()
+	defer util.CloseLogFile()
+
+	if err := netparser.InitIPGeoLocation(); err != nil {
+		return err
+	}
+	defer netparser.CloseIPGeoLocation()
+
+	repository, err := initRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	parser := storage.NewLogParser(repository)
+	result, err := parser.ImportFiles(websiteID, flags.Args(), options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("导入完成: %d 个文件（%d 个此前已导入），写入 %d 条，跳过 %d 行\n",
+		result.Files, result.SkippedFiles, result.Entries, result.Skipped)
+	fmt.Printf("导入的时间范围: %s ~ %s\n", formatImportTime(result.From), formatImportTime(result.To))
+
+	retentionDays := util.RetentionDays(websiteID)
+	retention := time.Now().AddDate(0, 0, -retentionDays)
+	if result.Entries > 0 && result.From.Before(retention) {
+		fmt.Printf("注意: 原始日志只保留 %d 天，%s 之前的记录会在下次清理时汇总为每日统计后删除\n",
+			retentionDays, retention.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// printImportProgress 在同一行刷新当前文件的导入进度
+func printImportProgress(progress storage.ImportProgress) {
+	percent := 100.0
+	if progress.TotalBytes > 0 {
+		percent = float64(progress.ReadBytes) / float64(progress.TotalBytes) * 100
+	}
+	fmt.Fprintf(os.Stdout, "\r[%d/%d] %s %5.1f%% 写入 %d 条，跳过 %d 行",
+		progress.FileIndex, progress.FileCount, progress.Path, percent, progress.Entries, progress.Skipped)
+	if progress.Done {
+		fmt.Fprintln(os.Stdout)
+	}
+}
+
+// formatImportTime 格式化导入范围的边界，零值表示不限制
+func formatImportTime(t time.Time) string {
+	if t.IsZero() {
+		return "不限"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,6 +25,13 @@ func main() {
 	if util.ProcessCliCommands() {
 		os.Exit(0)
 	}
+	if *showMigrations {
+		if err := printPendingMigrations(); err != nil {
+			fmt.Fprintf(os.Stderr, "查询数据库迁移失败: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	// 初始化日志、配置
 	util.ConfigureLogging()
@@ -55,7 +63,7 @@ func main() {
 	startHTTPServer(statsFactory)
 
 	// 启动维护任务
-	startPeriodicTaskScheduler(logParser)
+	startPeriodicTaskScheduler(logParser, statsFactory)
 }
 
 // 初始化数据
@@ -137,7 +145,8 @@ func setupCORS(statsFactory *stats.StatsFactory) *gin.Engine {
 }
 
 // 启动维护任务
-func startPeriodicTaskScheduler(logParser *storage.LogParser) {
+func startPeriodicTaskScheduler(
+	logParser *storage.LogParser, statsFactory *stats.StatsFactory) {
 	logrus.Info("****** 4 启动维护任务 ******")
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -145,6 +154,10 @@ func startPeriodicTaskScheduler(logParser *storage.LogParser) {
 
 	go runPeriodicTaskScheduler(ctx, logParser)
 
+	if util.ReadConfig().System.Follow {
+		startLogFollower(ctx, logParser, statsFactory)
+	}
+
 	// 等待程序退出
 	shutdownSignal := make(chan os.Signal, 1)
 	signal.Notify(shutdownSignal, os.Interrupt, syscall.SIGTERM)
@@ -160,6 +173,19 @@ func startPeriodicTaskScheduler(logParser *storage.LogParser) {
 	<-shutdownCtx.Done()
 }
 
+// startLogFollower 启动日志实时跟踪
+func startLogFollower(ctx context.Context,
+	logParser *storage.LogParser, statsFactory *stats.StatsFactory) {
+	follower, err := storage.NewLogFollower(logParser, statsFactory.InvalidateWebsite)
+	if err != nil {
+		logrus.WithError(err).Warn("启动日志实时跟踪失败，仅使用定期扫描")
+		return
+	}
+
+	go follower.Run(ctx)
+	logrus.Info("日志实时跟踪已启动")
+}
+
 // runPeriodicTaskScheduler 运行周期性任务
 func runPeriodicTaskScheduler(
 	ctx context.Context, parser *storage.LogParser) {
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// showMigrations 与 util 中的参数一起由 flag.Parse 解析
+var showMigrations = flag.Bool("show-migrations", false, "显示待执行的数据库迁移并退出")
+
+// printPendingMigrations 显示数据库当前的结构版本和启动时将执行的迁移
+func printPendingMigrations() error {
+	repository, err := storage.NewRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	version, err := repository.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	pending, err := repository.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("当前数据库结构版本: %d\n", version)
+	if len(pending) == 0 {
+		fmt.Println("没有待执行的迁移")
+		return nil
+	}
+	fmt.Println("下次启动时将执行以下迁移（执行前会自动备份数据库）:")
+	for _, migration := range pending {
+		fmt.Printf("  %d  %s\n", migration.Version, migration.Description)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+func init() {
+	util.RegisterCommand(util.Command{
+		Name:  "rename-site",
+		Usage: "rename-site -from <原网站名称或ID> -to <新网站名称或ID>  修改配置中的网站名称或 id 后迁移已有数据",
+		Run:   runRenameSiteCommand,
+	})
+}
+
+// runRenameSiteCommand 将原网站 ID 下的数据表和扫描状态迁移到配置中的新网站，须先停止服务
+func runRenameSiteCommand(args []string) error {
+	flags := flag.NewFlagSet("rename-site", flag.ContinueOnError)
+	from := flags.String("from", "", "原网站名称或ID，原名称未配置 id 时按名称计算原 ID")
+	to := flags.String("to", "", "配置文件中的新网站名称或ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		flags.Usage()
+		return errors.New("缺少 -from 或 -to 参数")
+	}
+
+	util.ReadConfig()
+	newID, ok := util.FindWebsiteID(*to)
+	if !ok {
+		return fmt.Errorf("配置中没有网站 %s，请先修改配置文件", *to)
+	}
+
+	util.ConfigureLogging()
+	defer util.CloseLogFile()
+
+	repository, err := initRepository()
+	if err != nil {
+		return err
+	}
+	defer repository.Close()
+
+	// -from 可以是原 ID，也可以是未配置 id 时的原名称
+	oldID := *from
+	if exists, err := repository.HasWebsiteData(oldID); err != nil {
+		return err
+	} else if !exists || !util.ValidWebsiteID(oldID) {
+		oldID = util.LegacyWebsiteID(*from)
+	}
+	if oldID == newID {
+		return fmt.Errorf("原网站与新网站的 ID 相同（%s），无需迁移", newID)
+	}
+
+	if err := repository.RenameWebsite(oldID, newID); err != nil {
+		return err
+	}
+
+	fmt.Printf("已将网站 %s 的数据迁移到 %s（%s）\n", oldID, newID, *to)
+	return nil
+}
@@ -0,0 +1,117 @@
+// Package export 将原始日志和统计结果导出为 CSV、NDJSON 或 Parquet 文件，供离线分析
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/stats"
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// LogsType 导出原始日志时使用的类型名，其他类型名对应统计管理器
+const LogsType = "logs"
+
+// logColumns 原始日志的导出列，与日志视图的列一致
+var logColumns = []Column{
+	{"id", KindInt},
+	{"time", KindTime},
+	{"ip", KindString},
+	{"method", KindString},
+	{"url", KindString},
+	{"status_code", KindInt},
+	{"bytes_sent", KindInt},
+	{"referer", KindString},
+	{"user_browser", KindString},
+	{"user_os", KindString},
+	{"user_device", KindString},
+	{"domestic_location", KindString},
+	{"global_location", KindString},
+	{"pageview", KindBool},
+	{"request_time", KindFloat},
+	{"upstream_response_time", KindFloat},
+	{"extra", KindString}, // log_format 中未映射的变量，JSON 对象
+}
+
+// Logs 将网站 [start, end) 范围内的原始日志逐行写入 w，返回写入的行数
+func Logs(repo *storage.Repository, w io.Writer, format, websiteID string, start, end time.Time) (int, error) {
+	writer, err := NewWriter(format, w, logColumns)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	row := make([]any, len(logColumns))
+	err = repo.ScanLogs(websiteID, start, end, func(log *storage.NginxLogRecord) error {
+		row[0], row[1], row[2], row[3] = log.ID, log.Timestamp, log.IP, log.Method
+		row[4], row[5], row[6], row[7] = log.Url, log.Status, log.BytesSent, log.Referer
+		row[8], row[9], row[10] = log.UserBrowser, log.UserOs, log.UserDevice
+		row[11], row[12], row[13] = log.DomesticLocation, log.GlobalLocation, log.PageviewFlag == 1
+		row[14], row[15], row[16] = nil, nil, nil
+		if log.RequestTime != nil {
+			row[14] = *log.RequestTime
+		}
+		if log.UpstreamResponseTime != nil {
+			row[15] = *log.UpstreamResponseTime
+		}
+		if len(log.Extra) > 0 {
+			data, err := json.Marshal(log.Extra)
+			if err != nil {
+				return err
+			}
+			row[16] = string(data)
+		}
+
+		count++
+		return writer.Write(row)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, writer.Close()
+}
+
+// Stats 将统计结果写入 w，结果须实现 stats.Tabular 接口
+func Stats(result stats.StatsResult, w io.Writer, format string) error {
+	tabular, ok := result.(stats.Tabular)
+	if !ok {
+		return fmt.Errorf("统计类型 %s 不支持导出", result.GetType())
+	}
+	table := tabular.Table()
+
+	writer, err := NewWriter(format, w, tableColumns(table))
+	if err != nil {
+		return err
+	}
+	for _, row := range table.Rows {
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+// tableColumns 根据每列第一个非空的值推断列类型，全部为空的列按字符串处理
+func tableColumns(table stats.Table) []Column {
+	columns := make([]Column, len(table.Columns))
+	for i, name := range table.Columns {
+		columns[i] = Column{Name: name, Kind: KindString}
+		for _, row := range table.Rows {
+			if row[i] == nil {
+				continue
+			}
+			switch row[i].(type) {
+			case int, int64:
+				columns[i].Kind = KindInt
+			case float64:
+				columns[i].Kind = KindFloat
+			case bool:
+				columns[i].Kind = KindBool
+			}
+			break
+		}
+	}
+	return columns
+}
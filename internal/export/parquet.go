@@ -0,0 +1,138 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// parquetBatchSize 每次交给 Parquet 写入器的行数
+	parquetBatchSize = 1024
+	// parquetRowGroupSize 每个行组的最大行数，限制导出大量日志时的内存占用
+	parquetRowGroupSize = 100000
+)
+
+// parquetWriter 将行按列写入 Parquet 文件，所有列均为可选
+type parquetWriter struct {
+	writer  *parquet.Writer
+	columns []Column
+	indexes []int // 列在 Parquet 结构中的位置，Parquet 按列名排序
+	rows    []parquet.Row
+}
+
+func newParquetWriter(w io.Writer, columns []Column) *parquetWriter {
+	group := make(parquet.Group, len(columns))
+	for _, column := range columns {
+		var node parquet.Node
+		switch column.Kind {
+		case KindInt:
+			node = parquet.Int(64)
+		case KindFloat:
+			node = parquet.Leaf(parquet.DoubleType)
+		case KindBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		case KindTime:
+			node = parquet.Timestamp(parquet.Millisecond)
+		default:
+			node = parquet.String()
+		}
+		group[column.Name] = parquet.Optional(node)
+	}
+	schema := parquet.NewSchema("nixvis", group)
+
+	positions := make(map[string]int, len(columns))
+	for i, path := range schema.Columns() {
+		positions[path[0]] = i
+	}
+	indexes := make([]int, len(columns))
+	for i, column := range columns {
+		indexes[i] = positions[column.Name]
+	}
+
+	return &parquetWriter{
+		writer: parquet.NewWriter(w, schema,
+			parquet.Compression(&parquet.Zstd),
+			parquet.MaxRowsPerRowGroup(parquetRowGroupSize)),
+		columns: columns,
+		indexes: indexes,
+		rows:    make([]parquet.Row, 0, parquetBatchSize),
+	}
+}
+
+func (w *parquetWriter) Write(row []any) error {
+	values := make(parquet.Row, len(row))
+	for i, value := range row {
+		index := w.indexes[i]
+		if value == nil {
+			values[index] = parquet.NullValue().Level(0, 0, index)
+			continue
+		}
+		converted, err := parquetValue(w.columns[i].Kind, value)
+		if err != nil {
+			return fmt.Errorf("列 %s: %v", w.columns[i].Name, err)
+		}
+		values[index] = parquet.ValueOf(converted).Level(0, 1, index)
+	}
+
+	w.rows = append(w.rows, values)
+	if len(w.rows) == parquetBatchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *parquetWriter) flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	_, err := w.writer.WriteRows(w.rows)
+	clear(w.rows)
+	w.rows = w.rows[:0]
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.writer.Close()
+}
+
+// parquetValue 将单元格的值转换为列类型对应的 Go 类型
+func parquetValue(kind Kind, value any) (any, error) {
+	switch kind {
+	case KindInt:
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		}
+	case KindFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		}
+	case KindBool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case KindTime:
+		if v, ok := value.(time.Time); ok {
+			return v.UnixMilli(), nil
+		}
+	default:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+		return fmt.Sprint(value), nil
+	}
+	return nil, fmt.Errorf("值 %v 的类型 %T 与列类型不符", value, value)
+}
@@ -0,0 +1,153 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// 支持的导出格式
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// Formats 支持的导出格式，按文档中的顺序排列
+var Formats = []string{FormatCSV, FormatNDJSON, FormatParquet}
+
+// Kind 列的数据类型，Parquet 文件需要在写入前确定
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindInt
+	KindFloat
+	KindBool
+	KindTime
+)
+
+// Column 导出文件中的一列，所有列都允许为空值
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+// Writer 逐行写入导出文件，写完后须调用 Close
+//
+// 每行的值与列一一对应，类型为 string、int、int64、float64、bool、time.Time 或 nil。
+type Writer interface {
+	Write(row []any) error
+	Close() error
+}
+
+// NewWriter 创建指定格式的写入器
+func NewWriter(format string, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatNDJSON:
+		return newNDJSONWriter(w, columns), nil
+	case FormatParquet:
+		return newParquetWriter(w, columns), nil
+	default:
+		return nil, fmt.Errorf("不支持的导出格式 %q，可选: %v", format, Formats)
+	}
+}
+
+// ContentType 返回导出格式对应的 HTTP Content-Type
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// csvWriter 第一行为列名，空值写为空字符串
+type csvWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvWriter{writer: writer, record: make([]string, len(columns))}, nil
+}
+
+func (w *csvWriter) Write(row []any) error {
+	for i, value := range row {
+		switch v := value.(type) {
+		case nil:
+			w.record[i] = ""
+		case string:
+			w.record[i] = v
+		case time.Time:
+			w.record[i] = v.Format(time.RFC3339)
+		case float64:
+			w.record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			w.record[i] = fmt.Sprint(v)
+		}
+	}
+	return w.writer.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// ndjsonWriter 每行一个 JSON 对象，字段顺序与列一致
+type ndjsonWriter struct {
+	writer *bufio.Writer
+	keys   [][]byte
+}
+
+func newNDJSONWriter(w io.Writer, columns []Column) *ndjsonWriter {
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		keys[i], _ = json.Marshal(column.Name)
+	}
+	return &ndjsonWriter{writer: bufio.NewWriter(w), keys: keys}
+}
+
+func (w *ndjsonWriter) Write(row []any) error {
+	w.writer.WriteByte('{')
+	for i, value := range row {
+		if i > 0 {
+			w.writer.WriteByte(',')
+		}
+		w.writer.Write(w.keys[i])
+		w.writer.WriteByte(':')
+
+		if t, ok := value.(time.Time); ok {
+			value = t.Format(time.RFC3339)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		w.writer.Write(data)
+	}
+	w.writer.WriteByte('}')
+	return w.writer.WriteByte('\n')
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.writer.Flush()
+}
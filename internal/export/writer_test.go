@@ -0,0 +1,147 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var testColumns = []Column{
+	{Name: "url", Kind: KindString},
+	{Name: "status", Kind: KindInt},
+	{Name: "request_time", Kind: KindFloat},
+	{Name: "bot", Kind: KindBool},
+	{Name: "time", Kind: KindTime},
+}
+
+func testRows() [][]any {
+	timestamp := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	return [][]any{
+		{"/a,b", 200, 0.25, false, timestamp},
+		{"/", int64(404), nil, true, nil},
+	}
+}
+
+func writeTestRows(t *testing.T, format string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer, err := NewWriter(format, &buffer, testColumns)
+	if err != nil {
+		t.Fatalf("create %s writer: %v", format, err)
+	}
+	for _, row := range testRows() {
+		if err := writer.Write(row); err != nil {
+			t.Fatalf("write %s row: %v", format, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close %s writer: %v", format, err)
+	}
+	return buffer.Bytes()
+}
+
+func TestCSVWriter(t *testing.T) {
+	expected := "url,status,request_time,bot,time\n" +
+		"\"/a,b\",200,0.25,false,2024-05-01T08:30:00Z\n" +
+		"/,404,,true,\n"
+	if data := writeTestRows(t, FormatCSV); string(data) != expected {
+		t.Fatalf("unexpected csv:\n%s", data)
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	expected := `{"url":"/a,b","status":200,"request_time":0.25,"bot":false,"time":"2024-05-01T08:30:00Z"}` + "\n" +
+		`{"url":"/","status":404,"request_time":null,"bot":true,"time":null}` + "\n"
+	if data := writeTestRows(t, FormatNDJSON); string(data) != expected {
+		t.Fatalf("unexpected ndjson:\n%s", data)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	data := writeTestRows(t, FormatParquet)
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open parquet file: %v", err)
+	}
+	if file.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", file.NumRows())
+	}
+
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+	rows := make([]parquet.Row, 2)
+	if n, err := reader.ReadRows(rows); n != 2 {
+		t.Fatalf("read rows: %d (%v)", n, err)
+	}
+
+	// Parquet 按列名排序，按列名取值
+	value := func(row parquet.Row, name string) parquet.Value {
+		column, ok := file.Schema().Lookup(name)
+		if !ok {
+			t.Fatalf("missing column %s", name)
+		}
+		for _, v := range row {
+			if v.Column() == column.ColumnIndex {
+				return v
+			}
+		}
+		t.Fatalf("missing value for column %s", name)
+		return parquet.Value{}
+	}
+
+	first, second := rows[0], rows[1]
+	if v := value(first, "url"); v.String() != "/a,b" {
+		t.Fatalf("unexpected url: %v", v)
+	}
+	if v := value(first, "status"); v.Int64() != 200 {
+		t.Fatalf("unexpected status: %v", v)
+	}
+	if v := value(first, "request_time"); v.Double() != 0.25 {
+		t.Fatalf("unexpected request time: %v", v)
+	}
+	if v := value(first, "time"); v.Int64() != testRows()[0][4].(time.Time).UnixMilli() {
+		t.Fatalf("unexpected time: %v", v)
+	}
+	if v := value(second, "status"); v.Int64() != 404 {
+		t.Fatalf("unexpected status: %v", v)
+	}
+	if v := value(second, "bot"); !v.Boolean() {
+		t.Fatalf("unexpected bot flag: %v", v)
+	}
+	if !value(second, "request_time").IsNull() || !value(second, "time").IsNull() {
+		t.Fatalf("expected nil values to be written as null: %v", second)
+	}
+}
+
+func TestWriterRejectsMismatchedValue(t *testing.T) {
+	writer, err := NewWriter(FormatParquet, &bytes.Buffer{}, testColumns)
+	if err != nil {
+		t.Fatalf("create writer: %v", err)
+	}
+	if err := writer.Write([]any{"/", "200", nil, nil, nil}); err == nil {
+		t.Fatalf("expected a string in an int column to be rejected")
+	}
+	if _, err := NewWriter("xml", &bytes.Buffer{}, testColumns); err == nil {
+		t.Fatalf("expected an unsupported format to be rejected")
+	}
+}
+
+func TestParquetWriterWritesMultipleBatches(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := newParquetWriter(&buffer, testColumns)
+	for i := 0; i < parquetBatchSize*2+1; i++ {
+		if err := writer.Write([]any{"/", i, nil, nil, nil}); err != nil {
+			t.Fatalf("write row %d: %v", i, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	file, err := parquet.OpenFile(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil || file.NumRows() != parquetBatchSize*2+1 {
+		t.Fatalf("expected %d rows (%v)", parquetBatchSize*2+1, err)
+	}
+}
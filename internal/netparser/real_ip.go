@@ -0,0 +1,116 @@
+package netparser
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RealIPResolver 根据受信任代理和请求头还原客户端真实 IP，规则与 Nginx 的
+// real_ip_recursive on 一致：从右向左跳过受信任的代理地址
+type RealIPResolver struct {
+	field   string       // 记录真实 IP 的日志变量，如 http_x_forwarded_for
+	proxies []*net.IPNet // 受信任的代理网段
+}
+
+// NewRealIPResolver 创建真实 IP 解析器
+//
+// header 可以是请求头名（如 CF-Connecting-IP）或 Nginx 变量名（如 $http_x_forwarded_for），
+// trustedProxies 为 CIDR 或单个 IP。请求头可由客户端任意设置，只有来自受信任代理的请求才使用，
+// 因此 trustedProxies 不能为空。
+func NewRealIPResolver(header string, trustedProxies []string) (*RealIPResolver, error) {
+	field := RealIPField(header)
+	if field == "" {
+		return nil, fmt.Errorf("未配置记录真实 IP 的请求头")
+	}
+	if len(trustedProxies) == 0 {
+		return nil, fmt.Errorf("配置 realIPHeader 时须同时配置 trustedProxies")
+	}
+
+	resolver := &RealIPResolver{field: field}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("无效的受信任代理 %q: %w", proxy, err)
+		}
+		resolver.proxies = append(resolver.proxies, network)
+	}
+	return resolver, nil
+}
+
+// RealIPField 将请求头名或变量名转换为日志变量名，如 X-Forwarded-For -> http_x_forwarded_for
+func RealIPField(header string) string {
+	field := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(header), "$"))
+	field = strings.ReplaceAll(field, "-", "_")
+	if field == "" || strings.HasPrefix(field, "http_") {
+		return field
+	}
+	return "http_" + field
+}
+
+// Field 返回记录真实 IP 的日志变量名
+func (r *RealIPResolver) Field() string {
+	return r.field
+}
+
+// Resolve 返回客户端真实 IP，remoteAddr 不是受信任的代理或请求头无效时原样返回
+func (r *RealIPResolver) Resolve(remoteAddr string, header string) string {
+	if !r.trusted(remoteAddr) {
+		return remoteAddr
+	}
+
+	addresses := strings.Split(header, ",")
+	realIP := ""
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := parseAddress(addresses[i])
+		if address == "" {
+			break
+		}
+		realIP = address
+		if !r.trusted(address) {
+			break
+		}
+	}
+
+	if realIP == "" {
+		return remoteAddr
+	}
+	return realIP
+}
+
+// trusted 判断地址是否为受信任的代理
+func (r *RealIPResolver) trusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddress 解析请求头中的地址，去掉端口和 IPv6 的方括号，无效时返回空字符串
+func parseAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	address = strings.Trim(address, "[]")
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
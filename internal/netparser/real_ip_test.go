@@ -0,0 +1,69 @@
+package netparser
+
+import "testing"
+
+func TestNewRealIPResolverRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		proxies []string
+	}{
+		{"empty header", "", []string{"10.0.0.1"}},
+		{"empty proxy list", "X-Forwarded-For", nil},
+		{"invalid proxy", "X-Forwarded-For", []string{"not-an-ip"}},
+		{"invalid cidr", "X-Forwarded-For", []string{"10.0.0.0/33"}},
+	}
+	for _, tt := range tests {
+		if _, err := NewRealIPResolver(tt.header, tt.proxies); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestRealIPField(t *testing.T) {
+	tests := map[string]string{
+		"X-Forwarded-For":       "http_x_forwarded_for",
+		"CF-Connecting-IP":      "http_cf_connecting_ip",
+		"$http_x_forwarded_for": "http_x_forwarded_for",
+		" $HTTP_X_REAL_IP ":     "http_x_real_ip",
+		"":                      "",
+	}
+	for header, expected := range tests {
+		if field := RealIPField(header); field != expected {
+			t.Errorf("RealIPField(%q) = %q, expected %q", header, field, expected)
+		}
+	}
+}
+
+func TestRealIPResolverResolve(t *testing.T) {
+	resolver, err := NewRealIPResolver("X-Forwarded-For", []string{"127.0.0.0/8", "10.0.0.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("NewRealIPResolver returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		expected   string
+	}{
+		{"untrusted remote addr", "192.0.2.7", "203.0.113.9", "192.0.2.7"},
+		{"single hop", "127.0.0.1", "203.0.113.9", "203.0.113.9"},
+		{"chained trusted hops", "127.0.0.1", "198.51.100.1, 203.0.113.9, 10.0.0.1", "203.0.113.9"},
+		{"all hops trusted", "127.0.0.1", "10.0.0.1, 127.0.0.2", "10.0.0.1"},
+		{"ipv4 with port", "127.0.0.1", "203.0.113.9:51234", "203.0.113.9"},
+		{"ipv6 with brackets and port", "127.0.0.1", "[2001:db9::1]:443", "2001:db9::1"},
+		{"ipv6 with brackets", "127.0.0.1", "[2001:db9::1]", "2001:db9::1"},
+		{"trusted ipv6 proxy", "2001:db8::2", "203.0.113.9", "203.0.113.9"},
+		{"empty header", "127.0.0.1", "", "127.0.0.1"},
+		{"garbage header", "127.0.0.1", "unknown", "127.0.0.1"},
+		{"garbage before trusted hop", "127.0.0.1", "<script>, 10.0.0.1", "10.0.0.1"},
+		{"garbage hop stops the walk", "127.0.0.1", "198.51.100.1, garbage, 203.0.113.9", "203.0.113.9"},
+		{"garbage remote addr", "-", "203.0.113.9", "-"},
+	}
+	for _, tt := range tests {
+		if ip := resolver.Resolve(tt.remoteAddr, tt.header); ip != tt.expected {
+			t.Errorf("%s: Resolve(%q, %q) = %q, expected %q", tt.name, tt.remoteAddr, tt.header, ip, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package stats
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// noExtension URL 没有文件扩展名时使用的键
+const noExtension = "(无)"
+
+// BandwidthItem 某个 URL、扩展名、IP 或客户端消耗的流量
+type BandwidthItem struct {
+	Key       string  `json:"key"`
+	Requests  int     `json:"requests"`
+	BytesSent int64   `json:"bytesSent"`
+	Percent   float64 `json:"percent"` // 占全部流量的百分比
+}
+
+// BandwidthStats 流量统计结果，包括静态资源等不计入 PV 的请求
+type BandwidthStats struct {
+	Labels        []string        `json:"labels"`
+	BytesSent     []int64         `json:"bytesSent"`     // 各时段全部请求的流量（字节）
+	PageviewBytes []int64         `json:"pageviewBytes"` // 其中计入 PV 的请求的流量
+	Requests      []int           `json:"requests"`      // 各时段的请求数
+	Total         int64           `json:"total"`         // 时间范围内的总流量
+	URLs          []BandwidthItem `json:"urls"`
+	Extensions    []BandwidthItem `json:"extensions"`
+	IPs           []BandwidthItem `json:"ips"`
+	Clients       []BandwidthItem `json:"clients"` // 按原始 User-Agent
+}
+
+// GetType 实现 StatsResult 接口
+func (s BandwidthStats) GetType() string {
+	return "bandwidth"
+}
+
+// BandwidthStatsManager 统计全部请求的流量趋势和消耗流量最多的 URL、扩展名、IP 和客户端
+type BandwidthStatsManager struct {
+	repo *storage.Repository
+}
+
+// NewBandwidthStatsManager 创建流量统计管理器
+func NewBandwidthStatsManager(userRepoPtr *storage.Repository) *BandwidthStatsManager {
+	return &BandwidthStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 实现 StatsManager 接口
+func (m *BandwidthStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	limit, _ := query.ExtraParam["limit"].(int)
+	timeRange := query.ExtraParam["timeRange"].(string)
+	viewType := query.ExtraParam["viewType"].(string)
+	timePoints, labels := util.TimePointsAndLabels(timeRange, viewType)
+	result := BandwidthStats{
+		Labels:        labels,
+		BytesSent:     make([]int64, len(timePoints)),
+		PageviewBytes: make([]int64, len(timePoints)),
+		Requests:      make([]int, len(timePoints)),
+		URLs:          make([]BandwidthItem, 0),
+		Extensions:    make([]BandwidthItem, 0),
+		IPs:           make([]BandwidthItem, 0),
+		Clients:       make([]BandwidthItem, 0),
+	}
+
+	timeOffset := timePoints[1].Sub(timePoints[0])
+	startTime, endTime := timePoints[0], timePoints[len(timePoints)-1].Add(timeOffset)
+	hours, err := m.repo.QueryHourlyStats(query.WebsiteID, startTime, endTime)
+	if err != nil {
+		return result, err
+	}
+
+	result.addHours(hours, timePoints)
+
+	urls, err := m.repo.QueryBandwidth(query.WebsiteID, "url", startTime, endTime, limit)
+	if err != nil {
+		return result, err
+	}
+	result.URLs = bandwidthItems(urls, limit, result.Total)
+
+	// 扩展名由全部 URL 合并得到，逐行读取时合并
+	extensions := make(extensionUsage)
+	if err := m.repo.ScanURLBandwidth(query.WebsiteID, startTime, endTime, extensions.add); err != nil {
+		return result, err
+	}
+	result.Extensions = bandwidthItems(extensions.sorted(), limit, result.Total)
+
+	ips, err := m.repo.QueryBandwidth(query.WebsiteID, "ip", startTime, endTime, limit)
+	if err != nil {
+		return result, err
+	}
+	result.IPs = bandwidthItems(ips, limit, result.Total)
+
+	clients, err := m.repo.QueryBandwidth(query.WebsiteID, "client", startTime, endTime, limit)
+	if err != nil {
+		return result, err
+	}
+	result.Clients = bandwidthItems(clients, limit, result.Total)
+
+	return result, nil
+}
+
+// addHours 将按小时统计归入所在的时间段，时间点和小时均已排序
+func (s *BandwidthStats) addHours(hours []storage.HourlyStats, timePoints []time.Time) {
+	i := 0
+	for _, hour := range hours {
+		for i+1 < len(timePoints) && !hour.Hour.Before(timePoints[i+1]) {
+			i++
+		}
+		s.BytesSent[i] += hour.BytesSent
+		s.PageviewBytes[i] += hour.PVBytesSent
+		s.Requests[i] += hour.Requests
+		s.Total += hour.BytesSent
+	}
+}
+
+// extensionUsage 按扩展名合并各 URL 的流量，以扩展名为键
+type extensionUsage map[string]*storage.BandwidthUsage
+
+// add 将一个 URL 的流量计入其扩展名
+func (e extensionUsage) add(url storage.BandwidthUsage) {
+	extension := urlExtension(url.Key)
+	item, ok := e[extension]
+	if !ok {
+		item = &storage.BandwidthUsage{Key: extension}
+		e[extension] = item
+	}
+	item.Requests += url.Requests
+	item.BytesSent += url.BytesSent
+}
+
+// sorted 返回各扩展名的流量，按流量从高到低排序
+func (e extensionUsage) sorted() []storage.BandwidthUsage {
+	result := make([]storage.BandwidthUsage, 0, len(e))
+	for _, item := range e {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].BytesSent != result[j].BytesSent {
+			return result[i].BytesSent > result[j].BytesSent
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// urlExtension 返回 URL 路径的小写扩展名，忽略查询参数
+func urlExtension(url string) string {
+	if index := strings.IndexAny(url, "?#"); index >= 0 {
+		url = url[:index]
+	}
+	extension := strings.ToLower(path.Ext(url))
+	if extension == "" {
+		return noExtension
+	}
+	return extension
+}
+
+// bandwidthItems 取已排序的前 limit 项并计算占总流量的百分比
+func bandwidthItems(usages []storage.BandwidthUsage, limit int, total int64) []BandwidthItem {
+	items := make([]BandwidthItem, 0, min(limit, len(usages)))
+	for _, usage := range usages[:min(limit, len(usages))] {
+		item := BandwidthItem{Key: usage.Key, Requests: usage.Requests, BytesSent: usage.BytesSent}
+		if total > 0 {
+			item.Percent = float64(usage.BytesSent) / float64(total) * 100
+		}
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+func TestBandwidthHours(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	timePoints := []time.Time{start, start.Add(2 * time.Hour)}
+	result := BandwidthStats{
+		BytesSent:     make([]int64, len(timePoints)),
+		PageviewBytes: make([]int64, len(timePoints)),
+		Requests:      make([]int, len(timePoints)),
+	}
+	result.addHours([]storage.HourlyStats{
+		{Hour: start, BytesSent: 100, PVBytesSent: 40, Requests: 3},
+		{Hour: start.Add(time.Hour), BytesSent: 50, PVBytesSent: 0, Requests: 1},
+		{Hour: start.Add(3 * time.Hour), BytesSent: 1000, PVBytesSent: 10, Requests: 5},
+	}, timePoints)
+
+	if !reflect.DeepEqual(result.BytesSent, []int64{150, 1000}) ||
+		!reflect.DeepEqual(result.PageviewBytes, []int64{40, 10}) ||
+		!reflect.DeepEqual(result.Requests, []int{4, 5}) || result.Total != 1150 {
+		t.Fatalf("unexpected bandwidth series: %+v", result)
+	}
+}
+
+func TestURLExtension(t *testing.T) {
+	for url, expected := range map[string]string{
+		"/video.MP4":             ".mp4",
+		"/static/app.js?v=1.2.3": ".js",
+		"/docs/":                 noExtension,
+		"/":                      noExtension,
+		"/page#section.2":        noExtension,
+		"/archive.tar.gz":        ".gz",
+	} {
+		if extension := urlExtension(url); extension != expected {
+			t.Errorf("urlExtension(%q) = %q, expected %q", url, extension, expected)
+		}
+	}
+}
+
+func TestExtensionUsageAndItems(t *testing.T) {
+	urls := []storage.BandwidthUsage{
+		{Key: "/video.mp4", Requests: 2, BytesSent: 8000},
+		{Key: "/app.js?v=2", Requests: 3, BytesSent: 600},
+		{Key: "/", Requests: 5, BytesSent: 500},
+		{Key: "/lib.js", Requests: 1, BytesSent: 400},
+		{Key: "/about", Requests: 1, BytesSent: 500},
+	}
+
+	usage := make(extensionUsage)
+	for _, url := range urls {
+		usage.add(url)
+	}
+	extensions := usage.sorted()
+	expected := []storage.BandwidthUsage{
+		{Key: ".mp4", Requests: 2, BytesSent: 8000},
+		{Key: noExtension, Requests: 6, BytesSent: 1000},
+		{Key: ".js", Requests: 4, BytesSent: 1000},
+	}
+	if !reflect.DeepEqual(extensions, expected) {
+		t.Fatalf("unexpected extension usage: %+v", extensions)
+	}
+
+	// 百分比相对全部流量，只取前 limit 项
+	items := bandwidthItems(extensions, 2, 10000)
+	expectedItems := []BandwidthItem{
+		{Key: ".mp4", Requests: 2, BytesSent: 8000, Percent: 80},
+		{Key: noExtension, Requests: 6, BytesSent: 1000, Percent: 10},
+	}
+	if !reflect.DeepEqual(items, expectedItems) {
+		t.Fatalf("unexpected bandwidth items: %+v", items)
+	}
+	if items := bandwidthItems(extensions, 10, 0); len(items) != 3 || items[0].Percent != 0 {
+		t.Fatalf("expected zero percentages without traffic, got %+v", items)
+	}
+}
@@ -89,46 +89,33 @@ func (s *ClientStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	}
 
 	if s.statsType == "referer" {
-		return s.queryRefererStats(query, startTime.Unix(), endTime.Unix(), limit)
+		return s.queryRefererStats(query, startTime, endTime, limit)
 	}
 
-	// 构建、执行查询
-	dbQueryStr := fmt.Sprintf(`
-        SELECT 
-            %[1]s AS url, 
-            COUNT(*) AS pv,
-            COUNT(DISTINCT ip) AS uv
-        FROM "%[2]s_nginx_logs" INDEXED BY idx_%[2]s_pv_ts_ip
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
-        GROUP BY %[1]s
-        ORDER BY uv DESC
-        LIMIT ?`,
-		statsType, query.WebsiteID)
-
-	rows, err := s.repo.GetDB().Query(dbQueryStr, startTime.Unix(), endTime.Unix(), limit)
+	// 从按小时汇总的数据中合并各取值的 PV 和访客
+	dimensions, err := s.repo.QueryDimensionStats(query.WebsiteID, statsType, startTime, endTime)
 	if err != nil {
 		return result, fmt.Errorf("查询URL统计失败: %v", err)
 	}
-	defer rows.Close()
+
+	ranks := make([]dimensionRank, 0, len(dimensions))
+	for key, item := range dimensions {
+		ranks = append(ranks, dimensionRank{key: key, pv: item.PV, uv: item.Visitors.Count()})
+	}
+	sortDimensionRanks(ranks)
+	if limit > len(ranks) {
+		limit = len(ranks)
+	}
 
 	totalPV := 0
 	totalUV := 0
 
-	for rows.Next() {
-		var url string
-		var pv, uv int
-		if err := rows.Scan(&url, &pv, &uv); err != nil {
-			return result, fmt.Errorf("解析URL统计结果失败: %v", err)
-		}
-		result.Key = append(result.Key, url)
-		result.PV = append(result.PV, pv)
-		result.UV = append(result.UV, uv)
-		totalPV += pv
-		totalUV += uv
-	}
-
-	if err := rows.Err(); err != nil {
-		return result, fmt.Errorf("遍历URL统计结果失败: %v", err)
+	for _, rank := range ranks[:limit] {
+		result.Key = append(result.Key, rank.key)
+		result.PV = append(result.PV, rank.pv)
+		result.UV = append(result.UV, rank.uv)
+		totalPV += rank.pv
+		totalUV += rank.uv
 	}
 
 	if totalPV > 0 && totalUV > 0 {
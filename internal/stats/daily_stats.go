@@ -0,0 +1,78 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// DailyTrendStats 按天的访问趋势，附带去年同期数据用于同比
+type DailyTrendStats struct {
+	Labels     []string `json:"labels"`
+	Pageviews  []int    `json:"pageviews"`
+	Visitors   []int    `json:"visitors"`
+	Requests   []int    `json:"requests"`
+	BytesSent  []int64  `json:"bytesSent"`
+	LastYearPV []int    `json:"lastYearPageviews"` // 去年同一天的 PV
+	LastYearUV []int    `json:"lastYearVisitors"`  // 去年同一天的 UV
+}
+
+// GetType 实现 StatsResult 接口
+func (s DailyTrendStats) GetType() string {
+	return "daily"
+}
+
+// DailyStatsManager 基于按天汇总数据的长期趋势统计，不受原始日志保留天数限制
+type DailyStatsManager struct {
+	repo *storage.Repository
+}
+
+// NewDailyStatsManager 创建按天趋势统计管理器
+func NewDailyStatsManager(userRepoPtr *storage.Repository) *DailyStatsManager {
+	return &DailyStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 实现 StatsManager 接口，查询包含今天在内最近 days 天的数据
+func (m *DailyStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	days, _ := query.ExtraParam["days"].(int)
+	days = min(days, 3660)
+
+	end := time.Now().AddDate(0, 0, 1)
+	start := end.AddDate(0, 0, -days)
+
+	current, err := m.repo.QueryDailyStats(query.WebsiteID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("查询每日统计失败: %v", err)
+	}
+	lastYear, err := m.repo.QueryDailyStats(query.WebsiteID, start.AddDate(-1, 0, 0), end.AddDate(-1, 0, 0))
+	if err != nil {
+		return nil, fmt.Errorf("查询去年同期统计失败: %v", err)
+	}
+
+	result := DailyTrendStats{
+		Labels:     make([]string, len(current)),
+		Pageviews:  make([]int, len(current)),
+		Visitors:   make([]int, len(current)),
+		Requests:   make([]int, len(current)),
+		BytesSent:  make([]int64, len(current)),
+		LastYearPV: make([]int, len(current)),
+		LastYearUV: make([]int, len(current)),
+	}
+	for i, day := range current {
+		result.Labels[i] = day.Date
+		result.Pageviews[i] = day.PV
+		result.Visitors[i] = day.UV
+		result.Requests[i] = day.Requests
+		result.BytesSent[i] = day.BytesSent
+		// 闰年前后两段的天数可能相差一天
+		if i < len(lastYear) {
+			result.LastYearPV[i] = lastYear[i].PV
+			result.LastYearUV[i] = lastYear[i].UV
+		}
+	}
+
+	return result, nil
+}
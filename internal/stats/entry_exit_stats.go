@@ -0,0 +1,123 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// SessionPage 页面作为着陆页和退出页的统计
+type SessionPage struct {
+	URL        string  `json:"url"`
+	Entries    int     `json:"entries"`    // 以该页面开始的访问次数
+	Exits      int     `json:"exits"`      // 以该页面结束的访问次数
+	Bounces    int     `json:"bounces"`    // 只浏览了该页面的访问次数
+	Pageviews  int     `json:"pageviews"`  // 该页面的浏览量
+	BounceRate float64 `json:"bounceRate"` // 跳出率，跳出次数占着陆次数的百分比
+	ExitRate   float64 `json:"exitRate"`   // 退出率，退出次数占浏览量的百分比
+}
+
+// EntryExitStats 着陆页或退出页统计结果
+type EntryExitStats struct {
+	statsType string
+	Pages     []SessionPage `json:"pages"` // 按着陆次数或退出次数从高到低排序
+}
+
+// GetType 实现 StatsResult 接口，返回 entry 或 exit
+func (s EntryExitStats) GetType() string {
+	return s.statsType
+}
+
+// EntryExitStatsManager 基于访问的着陆页和退出页统计各页面的跳出率和退出率
+type EntryExitStatsManager struct {
+	repo      *storage.Repository
+	statsType string
+}
+
+// NewEntryStatsManager 创建着陆页统计管理器
+func NewEntryStatsManager(userRepoPtr *storage.Repository) *EntryExitStatsManager {
+	return &EntryExitStatsManager{
+		repo:      userRepoPtr,
+		statsType: "entry",
+	}
+}
+
+// NewExitStatsManager 创建退出页统计管理器
+func NewExitStatsManager(userRepoPtr *storage.Repository) *EntryExitStatsManager {
+	return &EntryExitStatsManager{
+		repo:      userRepoPtr,
+		statsType: "exit",
+	}
+}
+
+// Query 实现 StatsManager 接口，统计在时间范围内开始的访问
+func (m *EntryExitStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := EntryExitStats{
+		statsType: m.statsType,
+		Pages:     make([]SessionPage, 0),
+	}
+
+	limit, _ := query.ExtraParam["limit"].(int)
+	timeRange := query.ExtraParam["timeRange"].(string)
+	startTime, endTime, err := util.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+
+	pages, err := m.repo.QuerySessionPages(query.WebsiteID, startTime, endTime)
+	if err != nil {
+		return result, err
+	}
+	// 退出率的分母与 URL 统计的 PV 一致
+	urls, err := m.repo.QueryDimensionStats(query.WebsiteID, "url", startTime, endTime)
+	if err != nil {
+		return result, fmt.Errorf("查询URL统计失败: %v", err)
+	}
+
+	result.Pages = sessionPages(m.statsType, pages, urls, limit)
+	return result, nil
+}
+
+// sessionPages 计算各页面的跳出率和退出率，按着陆次数或退出次数排序后取前 limit 个
+//
+// urls 为时间范围内各 URL 的浏览量，作为退出率的分母。
+func sessionPages(statsType string, pages map[string]*storage.SessionPageStats,
+	urls map[string]*storage.DimensionStats, limit int) []SessionPage {
+	result := make([]SessionPage, 0, len(pages))
+	for url, item := range pages {
+		if statsType == "entry" && item.Entries == 0 || statsType == "exit" && item.Exits == 0 {
+			continue
+		}
+		page := SessionPage{URL: url, Entries: item.Entries, Exits: item.Exits, Bounces: item.Bounces}
+		if stats, ok := urls[url]; ok {
+			page.Pageviews = stats.PV
+		}
+		if page.Entries > 0 {
+			page.BounceRate = float64(page.Bounces) / float64(page.Entries) * 100
+		}
+		if page.Pageviews > 0 {
+			// 访问可能延续到时间范围之后，退出率最高按 100% 计
+			page.ExitRate = min(float64(page.Exits)/float64(page.Pageviews)*100, 100)
+		}
+		result = append(result, page)
+	}
+
+	count := func(page SessionPage) int {
+		if statsType == "exit" {
+			return page.Exits
+		}
+		return page.Entries
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if count(result[i]) != count(result[j]) {
+			return count(result[i]) > count(result[j])
+		}
+		return result[i].URL < result[j].URL
+	})
+	if limit < len(result) {
+		result = result[:limit]
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// testSessionPages 按各次访问依次浏览的页面生成着陆、退出统计和各页面的浏览量
+func testSessionPages(sessions [][]string) (map[string]*storage.SessionPageStats, map[string]*storage.DimensionStats) {
+	pages := make(map[string]*storage.SessionPageStats)
+	urls := make(map[string]*storage.DimensionStats)
+	page := func(url string) *storage.SessionPageStats {
+		if pages[url] == nil {
+			pages[url] = &storage.SessionPageStats{}
+		}
+		return pages[url]
+	}
+	for _, session := range sessions {
+		page(session[0]).Entries++
+		page(session[len(session)-1]).Exits++
+		if len(session) == 1 {
+			page(session[0]).Bounces++
+		}
+		for _, url := range session {
+			if urls[url] == nil {
+				urls[url] = &storage.DimensionStats{}
+			}
+			urls[url].PV++
+		}
+	}
+	return pages, urls
+}
+
+func TestEntryAndExitPages(t *testing.T) {
+	pages, urls := testSessionPages([][]string{
+		{"/home", "/pricing", "/signup"},
+		{"/home"},
+		{"/blog"},
+		{"/pricing", "/home"},
+	})
+
+	entries := sessionPages("entry", pages, urls, 10)
+	expectedEntries := []SessionPage{
+		{URL: "/home", Entries: 2, Exits: 2, Bounces: 1, Pageviews: 3, BounceRate: 50, ExitRate: 200.0 / 3},
+		{URL: "/blog", Entries: 1, Exits: 1, Bounces: 1, Pageviews: 1, BounceRate: 100, ExitRate: 100},
+		{URL: "/pricing", Entries: 1, Pageviews: 2},
+	}
+	checkSessionPages(t, entries, expectedEntries)
+
+	exits := sessionPages("exit", pages, urls, 2)
+	expectedExits := []SessionPage{
+		{URL: "/home", Entries: 2, Exits: 2, Bounces: 1, Pageviews: 3, BounceRate: 50, ExitRate: 200.0 / 3},
+		{URL: "/blog", Entries: 1, Exits: 1, Bounces: 1, Pageviews: 1, BounceRate: 100, ExitRate: 100},
+	}
+	checkSessionPages(t, exits, expectedExits)
+}
+
+func TestExitRateIsCapped(t *testing.T) {
+	// 访问从时间范围之前开始，时间范围内只有一次浏览却有两次退出
+	pages := map[string]*storage.SessionPageStats{"/late": {Exits: 2}}
+	urls := map[string]*storage.DimensionStats{"/late": {PV: 1}}
+	exits := sessionPages("exit", pages, urls, 10)
+	if len(exits) != 1 || exits[0].ExitRate != 100 || exits[0].BounceRate != 0 {
+		t.Fatalf("unexpected exit pages: %+v", exits)
+	}
+	if entries := sessionPages("entry", pages, urls, 10); len(entries) != 0 {
+		t.Fatalf("expected pages without entries to be omitted, got %+v", entries)
+	}
+}
+
+func checkSessionPages(t *testing.T, result, expected []SessionPage) {
+	t.Helper()
+	if len(result) != len(expected) {
+		t.Fatalf("expected %d pages, got %+v", len(expected), result)
+	}
+	for i := range expected {
+		got, want := result[i], expected[i]
+		if math.Abs(got.BounceRate-want.BounceRate) > 1e-9 || math.Abs(got.ExitRate-want.ExitRate) > 1e-9 {
+			t.Fatalf("unexpected rates for %s: %+v", want.URL, got)
+		}
+		got.BounceRate, got.ExitRate = want.BounceRate, want.ExitRate
+		if got != want {
+			t.Fatalf("unexpected page %d: %+v, expected %+v", i, got, want)
+		}
+	}
+}
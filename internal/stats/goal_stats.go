@@ -0,0 +1,272 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// GoalConversion 单个目标的转化情况
+type GoalConversion struct {
+	Name           string  `json:"name"`
+	Conversions    int     `json:"conversions"`    // 完成目标的访问次数
+	Visitors       int     `json:"visitors"`       // 完成目标的访客数
+	ConversionRate float64 `json:"conversionRate"` // 完成目标的访问占全部访问的百分比
+}
+
+// GoalStats 转化目标统计结果
+type GoalStats struct {
+	Visits int              `json:"visits"` // 时间范围内开始的访问次数，与访问统计一致
+	Goals  []GoalConversion `json:"goals"`  // 按配置中的顺序排列
+}
+
+// GetType 实现 StatsResult 接口
+func (s GoalStats) GetType() string {
+	return "goals"
+}
+
+// FunnelStep 漏斗的一个步骤
+type FunnelStep struct {
+	Goal        string  `json:"goal"`
+	Visits      int     `json:"visits"`      // 依次完成到该步骤的访问次数
+	DropOff     int     `json:"dropOff"`     // 完成上一步后未完成该步骤的访问次数
+	StepRate    float64 `json:"stepRate"`    // 相对上一步的转化率，百分比
+	OverallRate float64 `json:"overallRate"` // 相对第一步的转化率，百分比
+}
+
+// FunnelStats 漏斗统计结果
+type FunnelStats struct {
+	Name  string       `json:"name"`
+	Steps []FunnelStep `json:"steps"`
+}
+
+// GetType 实现 StatsResult 接口
+func (s FunnelStats) GetType() string {
+	return "funnel"
+}
+
+// goalMatcher 编译后的转化目标
+type goalMatcher struct {
+	url    *regexp.Regexp
+	method string
+	status int
+}
+
+// compileGoals 编译网站配置中的转化目标，以名称为键
+func compileGoals(website util.WebsiteConfig) (map[string]*goalMatcher, error) {
+	goals := make(map[string]*goalMatcher, len(website.Goals))
+	for _, goal := range website.Goals {
+		pattern, err := regexp.Compile(goal.URL)
+		if err != nil {
+			return nil, fmt.Errorf("目标 %s 的 url 不是有效的正则: %v", goal.Name, err)
+		}
+		goals[goal.Name] = &goalMatcher{url: pattern, method: goal.Method, status: goal.Status}
+	}
+	return goals, nil
+}
+
+// matches 判断请求是否完成目标
+func (g *goalMatcher) matches(request storage.VisitRequest) bool {
+	if g.method != "" && !strings.EqualFold(g.method, request.Method) {
+		return false
+	}
+	if g.status != 0 && g.status != request.Status {
+		return false
+	}
+	return g.url.MatchString(request.URL)
+}
+
+// percent 计算百分比，分母为零时为 0
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// GoalStatsManager 按访问统计配置中各转化目标的完成情况
+type GoalStatsManager struct {
+	repo *storage.Repository
+}
+
+// NewGoalStatsManager 创建转化目标统计管理器
+func NewGoalStatsManager(userRepoPtr *storage.Repository) *GoalStatsManager {
+	return &GoalStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 实现 StatsManager 接口
+func (m *GoalStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := GoalStats{
+		Goals: make([]GoalConversion, 0),
+	}
+
+	website, ok := util.GetWebsiteByID(query.WebsiteID)
+	if !ok {
+		return result, fmt.Errorf("未找到网站: %s", query.WebsiteID)
+	}
+	goals, err := compileGoals(website)
+	if err != nil {
+		return result, err
+	}
+
+	timeRange := query.ExtraParam["timeRange"].(string)
+	startTime, endTime, err := util.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+
+	summary, err := m.repo.QuerySessionSummary(query.WebsiteID, startTime, endTime)
+	if err != nil {
+		return result, err
+	}
+	counter := newGoalCounter(goals)
+	if err := m.repo.ScanVisitRequests(query.WebsiteID, startTime, endTime, counter.add); err != nil {
+		return result, err
+	}
+	return counter.stats(website.Goals, summary.Visits), nil
+}
+
+// goalCounter 按访问记录各目标的完成情况
+type goalCounter struct {
+	goals       map[string]*goalMatcher
+	conversions map[string]map[int64]bool  // 目标 -> 完成目标的访问
+	visitors    map[string]map[string]bool // 目标 -> 完成目标的访客
+}
+
+func newGoalCounter(goals map[string]*goalMatcher) *goalCounter {
+	counter := &goalCounter{
+		goals:       goals,
+		conversions: make(map[string]map[int64]bool, len(goals)),
+		visitors:    make(map[string]map[string]bool, len(goals)),
+	}
+	for name := range goals {
+		counter.conversions[name] = make(map[int64]bool)
+		counter.visitors[name] = make(map[string]bool)
+	}
+	return counter
+}
+
+// add 记录访问中的一次请求，同一访问多次完成目标只计一次
+func (c *goalCounter) add(request storage.VisitRequest) {
+	for name, goal := range c.goals {
+		if !c.conversions[name][request.SessionID] && goal.matches(request) {
+			c.conversions[name][request.SessionID] = true
+			c.visitors[name][request.Visitor] = true
+		}
+	}
+}
+
+// stats 按配置中的顺序返回各目标的转化情况，visits 为时间范围内开始的访问次数
+func (c *goalCounter) stats(goals []util.GoalConfig, visits int) GoalStats {
+	result := GoalStats{Visits: visits, Goals: make([]GoalConversion, 0, len(goals))}
+	for _, goal := range goals {
+		result.Goals = append(result.Goals, GoalConversion{
+			Name:           goal.Name,
+			Conversions:    len(c.conversions[goal.Name]),
+			Visitors:       len(c.visitors[goal.Name]),
+			ConversionRate: percent(len(c.conversions[goal.Name]), result.Visits),
+		})
+	}
+	return result
+}
+
+// FunnelStatsManager 按访问统计漏斗各步骤的转化和流失
+type FunnelStatsManager struct {
+	repo *storage.Repository
+}
+
+// NewFunnelStatsManager 创建漏斗统计管理器
+func NewFunnelStatsManager(userRepoPtr *storage.Repository) *FunnelStatsManager {
+	return &FunnelStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 实现 StatsManager 接口，访问需在时间顺序上依次完成各步骤，一次请求最多推进一步
+func (m *FunnelStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	name := query.ExtraParam["funnel"].(string)
+	result := FunnelStats{
+		Name:  name,
+		Steps: make([]FunnelStep, 0),
+	}
+
+	website, ok := util.GetWebsiteByID(query.WebsiteID)
+	if !ok {
+		return result, fmt.Errorf("未找到网站: %s", query.WebsiteID)
+	}
+	var funnel *util.FunnelConfig
+	for i := range website.Funnels {
+		if website.Funnels[i].Name == name {
+			funnel = &website.Funnels[i]
+			break
+		}
+	}
+	if funnel == nil {
+		return result, fmt.Errorf("网站未定义漏斗: %s", name)
+	}
+	goals, err := compileGoals(website)
+	if err != nil {
+		return result, err
+	}
+	steps := make([]*goalMatcher, len(funnel.Steps))
+	for i, step := range funnel.Steps {
+		if steps[i] = goals[step]; steps[i] == nil {
+			return result, fmt.Errorf("漏斗 %s 的步骤 %s 不是已定义的目标", name, step)
+		}
+	}
+
+	timeRange := query.ExtraParam["timeRange"].(string)
+	startTime, endTime, err := util.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+
+	counter := &funnelCounter{steps: steps, progress: make(map[int64]int)}
+	if err := m.repo.ScanVisitRequests(query.WebsiteID, startTime, endTime, counter.add); err != nil {
+		return result, err
+	}
+	result.Steps = counter.stats(funnel.Steps)
+	return result, nil
+}
+
+// funnelCounter 按访问记录漏斗的完成进度
+type funnelCounter struct {
+	steps    []*goalMatcher
+	progress map[int64]int // 每次访问已完成的步骤数
+}
+
+// add 请求完成访问的下一步时推进一步
+func (c *funnelCounter) add(request storage.VisitRequest) {
+	completed := c.progress[request.SessionID]
+	if completed < len(c.steps) && c.steps[completed].matches(request) {
+		c.progress[request.SessionID] = completed + 1
+	}
+}
+
+// stats 统计到达各步骤的访问次数及转化率，names 为各步骤的目标名称
+func (c *funnelCounter) stats(names []string) []FunnelStep {
+	reached := make([]int, len(c.steps))
+	for _, completed := range c.progress {
+		for i := 0; i < completed; i++ {
+			reached[i]++
+		}
+	}
+
+	steps := make([]FunnelStep, 0, len(names))
+	for i, name := range names {
+		item := FunnelStep{Goal: name, Visits: reached[i], OverallRate: percent(reached[i], reached[0])}
+		previous := reached[0]
+		if i > 0 {
+			previous = reached[i-1]
+			item.DropOff = previous - reached[i]
+		}
+		item.StepRate = percent(reached[i], previous)
+		steps = append(steps, item)
+	}
+	return steps
+}
@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+var testGoals = []util.GoalConfig{
+	{Name: "pricing", URL: "^/pricing$"},
+	{Name: "order", URL: "^/api/orders$", Method: "post", Status: 201},
+	{Name: "thanks", URL: "^/thanks$"},
+}
+
+// testVisitRequests 按时间顺序排列的 4 次访问中的请求
+func testVisitRequests() []storage.VisitRequest {
+	request := func(session int64, visitor, method, url string, status int) storage.VisitRequest {
+		return storage.VisitRequest{SessionID: session, Visitor: visitor, Method: method, URL: url, Status: status}
+	}
+	return []storage.VisitRequest{
+		request(1, "a", "GET", "/pricing", 200),
+		request(3, "b", "GET", "/home", 200),
+		request(1, "a", "POST", "/api/orders", 201),
+		request(3, "b", "GET", "/thanks", 200), // 跳过了前面的步骤
+		request(1, "a", "GET", "/thanks", 200),
+		request(2, "a", "GET", "/pricing", 200),
+		request(2, "a", "POST", "/api/orders", 500), // 下单失败
+		request(3, "b", "GET", "/pricing", 200),
+		request(4, "c", "POST", "/api/orders", 201),
+		request(4, "c", "POST", "/api/orders", 201),
+	}
+}
+
+func compileTestGoals(t *testing.T) map[string]*goalMatcher {
+	t.Helper()
+	goals, err := compileGoals(util.WebsiteConfig{Goals: testGoals})
+	if err != nil {
+		t.Fatalf("compile goals: %v", err)
+	}
+	return goals
+}
+
+func TestGoalConversions(t *testing.T) {
+	counter := newGoalCounter(compileTestGoals(t))
+	for _, request := range testVisitRequests() {
+		counter.add(request)
+	}
+
+	result := counter.stats(testGoals, 4)
+	expected := []GoalConversion{
+		{Name: "pricing", Conversions: 3, Visitors: 2, ConversionRate: 75},
+		{Name: "order", Conversions: 2, Visitors: 2, ConversionRate: 50},
+		{Name: "thanks", Conversions: 2, Visitors: 2, ConversionRate: 50},
+	}
+	if result.Visits != 4 || len(result.Goals) != len(expected) {
+		t.Fatalf("unexpected goal stats: %+v", result)
+	}
+	for i := range expected {
+		if result.Goals[i] != expected[i] {
+			t.Fatalf("unexpected conversion for %s: %+v", expected[i].Name, result.Goals[i])
+		}
+	}
+}
+
+func TestFunnelSteps(t *testing.T) {
+	goals := compileTestGoals(t)
+	funnel := func(names ...string) []FunnelStep {
+		counter := &funnelCounter{progress: make(map[int64]int)}
+		for _, name := range names {
+			counter.steps = append(counter.steps, goals[name])
+		}
+		for _, request := range testVisitRequests() {
+			counter.add(request)
+		}
+		return counter.stats(names)
+	}
+
+	steps := funnel("pricing", "order", "thanks")
+	expected := []FunnelStep{
+		{Goal: "pricing", Visits: 3, StepRate: 100, OverallRate: 100},
+		{Goal: "order", Visits: 1, DropOff: 2, StepRate: 100.0 / 3, OverallRate: 100.0 / 3},
+		{Goal: "thanks", Visits: 1, StepRate: 100, OverallRate: 100.0 / 3},
+	}
+	checkFunnelSteps(t, steps, expected)
+
+	// 一次请求最多推进一步
+	steps = funnel("pricing", "pricing")
+	expected = []FunnelStep{
+		{Goal: "pricing", Visits: 3, StepRate: 100, OverallRate: 100},
+		{Goal: "pricing", Visits: 0, DropOff: 3},
+	}
+	checkFunnelSteps(t, steps, expected)
+}
+
+func TestCompileGoalsRejectsInvalidPattern(t *testing.T) {
+	website := util.WebsiteConfig{Goals: []util.GoalConfig{{Name: "broken", URL: "("}}}
+	if _, err := compileGoals(website); err == nil {
+		t.Fatalf("expected an invalid pattern to be rejected")
+	}
+}
+
+func checkFunnelSteps(t *testing.T, steps, expected []FunnelStep) {
+	t.Helper()
+	if len(steps) != len(expected) {
+		t.Fatalf("expected %d steps, got %+v", len(expected), steps)
+	}
+	for i := range expected {
+		got, want := steps[i], expected[i]
+		if math.Abs(got.StepRate-want.StepRate) > 1e-9 || math.Abs(got.OverallRate-want.OverallRate) > 1e-9 {
+			t.Fatalf("unexpected rates for step %d: %+v", i, got)
+		}
+		got.StepRate, got.OverallRate = want.StepRate, want.OverallRate
+		if got != want {
+			t.Fatalf("unexpected step %d: %+v, expected %+v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// LatencyPercentiles 一组请求耗时的分位数，单位秒
+type LatencyPercentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// URLLatency 单个 URL 的请求耗时
+type URLLatency struct {
+	URL string `json:"url"`
+	LatencyPercentiles
+	Upstream *LatencyPercentiles `json:"upstream,omitempty"` // 上游响应耗时，日志未记录时为空
+}
+
+// LatencyStats 请求耗时统计结果
+type LatencyStats struct {
+	URLs   []URLLatency         `json:"urls"`   // 按 p90 从高到低排序的慢 URL
+	Labels []string             `json:"labels"` // 与 timeseries 相同的时间点
+	Series []LatencyPercentiles `json:"series"` // 与 Labels 对应的各时段耗时
+}
+
+// GetType 实现 StatsResult 接口
+func (s LatencyStats) GetType() string {
+	return "latency"
+}
+
+// LatencyStatsManager 基于 $request_time 和 $upstream_response_time 统计请求耗时
+type LatencyStatsManager struct {
+	repo *storage.Repository
+}
+
+// NewLatencyStatsManager 创建请求耗时统计管理器
+func NewLatencyStatsManager(userRepoPtr *storage.Repository) *LatencyStatsManager {
+	return &LatencyStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 实现 StatsManager 接口
+//
+// 耗时在数据库中按桶计数，分位数由直方图估算，内存占用与请求数无关。
+func (m *LatencyStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	limit, _ := query.ExtraParam["limit"].(int)
+	timeRange := query.ExtraParam["timeRange"].(string)
+	viewType := query.ExtraParam["viewType"].(string)
+	timePoints, labels := util.TimePointsAndLabels(timeRange, viewType)
+	result := LatencyStats{
+		URLs:   make([]URLLatency, 0),
+		Labels: labels,
+		Series: make([]LatencyPercentiles, len(timePoints)),
+	}
+
+	timeOffset := timePoints[1].Sub(timePoints[0])
+	startTime, endTime := timePoints[0], timePoints[len(timePoints)-1].Add(timeOffset)
+	urls, err := m.repo.QueryURLLatency(query.WebsiteID, startTime, endTime)
+	if err != nil {
+		return result, err
+	}
+	result.URLs = slowestURLs(urls, limit)
+
+	periods, err := m.repo.QueryLatencyPeriods(query.WebsiteID, startTime, endTime)
+	if err != nil {
+		return result, err
+	}
+	result.Series = latencySeries(periods, timePoints)
+
+	return result, nil
+}
+
+// slowestURLs 计算各 URL 的耗时分位数，按 p90 从高到低取前 limit 个
+func slowestURLs(urls map[string]*storage.URLLatencyHistograms, limit int) []URLLatency {
+	result := make([]URLLatency, 0, len(urls))
+	for url, histograms := range urls {
+		item := URLLatency{URL: url, LatencyPercentiles: latencyPercentiles(histograms.Request)}
+		if histograms.Upstream != nil {
+			percentiles := latencyPercentiles(histograms.Upstream)
+			item.Upstream = &percentiles
+		}
+		result = append(result, item)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].P90 != result[j].P90 {
+			return result[i].P90 > result[j].P90
+		}
+		return result[i].URL < result[j].URL
+	})
+	if limit < len(result) {
+		result = result[:limit]
+	}
+	return result
+}
+
+// latencySeries 将各时段的直方图合并到所在的时间点后计算分位数
+func latencySeries(periods []storage.LatencyPeriod, timePoints []time.Time) []LatencyPercentiles {
+	histograms := make([]storage.LatencyHistogram, len(timePoints))
+	for i := range histograms {
+		histograms[i] = make(storage.LatencyHistogram)
+	}
+
+	// 时段已排序，依次归入所在的时间点
+	i := 0
+	for _, period := range periods {
+		for i+1 < len(timePoints) && !period.Time.Before(timePoints[i+1]) {
+			i++
+		}
+		histograms[i].Merge(period.Histogram)
+	}
+
+	result := make([]LatencyPercentiles, len(timePoints))
+	for i, histogram := range histograms {
+		result[i] = latencyPercentiles(histogram)
+	}
+	return result
+}
+
+// latencyPercentiles 按最近秩法从直方图估算分位数，取所在桶的上限
+func latencyPercentiles(histogram storage.LatencyHistogram) LatencyPercentiles {
+	buckets := make([]int, 0, len(histogram))
+	count := 0
+	for bucket, requests := range histogram {
+		buckets = append(buckets, bucket)
+		count += requests
+	}
+	if count == 0 {
+		return LatencyPercentiles{}
+	}
+	sort.Ints(buckets)
+
+	percentile := func(p float64) float64 {
+		rank := max(int(math.Ceil(p/100*float64(count))), 1)
+		seen := 0
+		for _, bucket := range buckets {
+			if seen += histogram[bucket]; seen >= rank {
+				// 保留到微秒，避免浮点误差
+				return math.Round(storage.LatencyBucketUpperBound(bucket)*1e6) / 1e6
+			}
+		}
+		return storage.LatencyBucketUpperBound(buckets[len(buckets)-1])
+	}
+	return LatencyPercentiles{
+		Count: count,
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+	}
+}
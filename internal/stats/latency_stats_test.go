@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+// testLatencyHistogram 将耗时放入上限不小于耗时的第一个桶，与数据库中的分桶一致
+func testLatencyHistogram(times ...float64) storage.LatencyHistogram {
+	histogram := make(storage.LatencyHistogram)
+	for _, t := range times {
+		bucket := 0
+		for storage.LatencyBucketUpperBound(bucket) < t*(1-1e-9) {
+			bucket++
+		}
+		histogram[bucket]++
+	}
+	return histogram
+}
+
+func TestLatencyPercentilesFromHistogram(t *testing.T) {
+	times := make([]float64, 100)
+	for i := range times {
+		times[i] = float64(i+1) / 1000 // 1ms ~ 100ms
+	}
+	percentiles := latencyPercentiles(testLatencyHistogram(times...))
+	if percentiles.Count != 100 {
+		t.Fatalf("unexpected count: %+v", percentiles)
+	}
+	// 估算值不小于真实分位数，且相对误差不超过 10%
+	for _, check := range []struct {
+		name            string
+		estimate, exact float64
+	}{
+		{"p50", percentiles.P50, 0.050},
+		{"p90", percentiles.P90, 0.090},
+		{"p99", percentiles.P99, 0.099},
+	} {
+		if check.estimate < check.exact || check.estimate > check.exact*1.1 {
+			t.Errorf("%s = %v, expected within 10%% above %v", check.name, check.estimate, check.exact)
+		}
+	}
+
+	if empty := latencyPercentiles(storage.LatencyHistogram{}); empty != (LatencyPercentiles{}) {
+		t.Fatalf("expected zero percentiles without samples, got %+v", empty)
+	}
+}
+
+func TestLatencySeries(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	timePoints := []time.Time{start, start.Add(time.Hour), start.Add(2 * time.Hour)}
+	periods := []storage.LatencyPeriod{
+		{Time: start, Histogram: testLatencyHistogram(0.1)},
+		{Time: start.Add(45 * time.Minute), Histogram: testLatencyHistogram(0.1, 2)},
+		{Time: start.Add(2*time.Hour + 15*time.Minute), Histogram: testLatencyHistogram(0.01)},
+	}
+
+	series := latencySeries(periods, timePoints)
+	if len(series) != 3 || series[0].Count != 3 || series[1].Count != 0 || series[2].Count != 1 {
+		t.Fatalf("unexpected latency series: %+v", series)
+	}
+	if series[0].P50 < 0.1 || series[0].P50 > 0.11 || series[0].P99 < 2 || series[0].P99 > 2.2 {
+		t.Fatalf("unexpected percentiles of the first hour: %+v", series[0])
+	}
+}
+
+func TestSlowestURLs(t *testing.T) {
+	urls := map[string]*storage.URLLatencyHistograms{
+		"/fast":   {Request: testLatencyHistogram(0.01, 0.02)},
+		"/slow":   {Request: testLatencyHistogram(1, 3), Upstream: testLatencyHistogram(0.9, 2.8)},
+		"/medium": {Request: testLatencyHistogram(0.5)},
+	}
+	result := slowestURLs(urls, 2)
+	if len(result) != 2 || result[0].URL != "/slow" || result[1].URL != "/medium" {
+		t.Fatalf("unexpected slowest urls: %+v", result)
+	}
+	if result[0].Upstream == nil || result[0].Upstream.Count != 2 || result[1].Upstream != nil {
+		t.Fatalf("unexpected upstream latency: %+v", result)
+	}
+}
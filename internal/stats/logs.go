@@ -1,30 +1,29 @@
 package stats
 
 import (
-	"fmt"
-	"strings"
-	"time"
-
 	"github.com/beyondxinxin/nixvis/internal/storage"
 )
 
 // LogEntry 表示单条日志信息
 type LogEntry struct {
-	ID               int    `json:"id"`
-	IP               string `json:"ip"`
-	Timestamp        int64  `json:"timestamp"`
-	Time             string `json:"time"` // 格式化后的时间字符串
-	Method           string `json:"method"`
-	URL              string `json:"url"`
-	StatusCode       int    `json:"status_code"`
-	BytesSent        int    `json:"bytes_sent"`
-	Referer          string `json:"referer"`
-	UserBrowser      string `json:"user_browser"`
-	UserOS           string `json:"user_os"`
-	UserDevice       string `json:"user_device"`
-	DomesticLocation string `json:"domestic_location"`
-	GlobalLocation   string `json:"global_location"`
-	PageviewFlag     bool   `json:"pageview_flag"`
+	ID                   int               `json:"id"`
+	IP                   string            `json:"ip"`
+	Timestamp            int64             `json:"timestamp"`
+	Time                 string            `json:"time"` // 格式化后的时间字符串
+	Method               string            `json:"method"`
+	URL                  string            `json:"url"`
+	StatusCode           int               `json:"status_code"`
+	BytesSent            int               `json:"bytes_sent"`
+	Referer              string            `json:"referer"`
+	UserBrowser          string            `json:"user_browser"`
+	UserOS               string            `json:"user_os"`
+	UserDevice           string            `json:"user_device"`
+	DomesticLocation     string            `json:"domestic_location"`
+	GlobalLocation       string            `json:"global_location"`
+	PageviewFlag         bool              `json:"pageview_flag"`
+	RequestTime          *float64          `json:"request_time,omitempty"`           // 请求耗时（秒）
+	UpstreamResponseTime *float64          `json:"upstream_response_time,omitempty"` // 上游响应耗时（秒）
+	Extra                map[string]string `json:"extra,omitempty"`                  // log_format 中的其他变量
 }
 
 // LogsStats 日志查询结果
@@ -81,7 +80,7 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		// 验证字段名有效性，防止SQL注入
 		validFields := map[string]bool{
 			"timestamp": true, "ip": true, "url": true,
-			"status_code": true, "bytes_sent": true,
+			"status_code": true, "bytes_sent": true, "request_time": true,
 		}
 		if validFields[field] {
 			sortField = field
@@ -98,79 +97,39 @@ func (m *LogsStatsManager) Query(query StatsQuery) (StatsResult, error) {
 		filter = filterVal
 	}
 
-	// 计算分页
-	offset := (page - 1) * pageSize
-	tableName := fmt.Sprintf("%s_nginx_logs", query.WebsiteID)
-
-	// 构建查询语句
-	var queryBuilder strings.Builder
-	queryBuilder.WriteString(fmt.Sprintf(`
-        SELECT 
-            id, ip, timestamp, method, url, status_code, 
-            bytes_sent, referer, user_browser, user_os, user_device, 
-            domestic_location, global_location, pageview_flag
-        FROM "%s"`, tableName))
-
-	// 添加过滤条件
-	var args []interface{}
-	if filter != "" {
-		queryBuilder.WriteString(" WHERE url LIKE ? OR ip LIKE ? OR referer LIKE ? OR domestic_location LIKE ?")
-		filterArg := "%" + filter + "%"
-		args = append(args, filterArg, filterArg, filterArg, filterArg)
-	}
-
-	// 添加排序
-	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY %s %s", sortField, sortOrder))
-
-	// 添加分页
-	queryBuilder.WriteString(" LIMIT ? OFFSET ?")
-	args = append(args, pageSize, offset)
-
-	// 执行查询
-	rows, err := m.repo.GetDB().Query(queryBuilder.String(), args...)
+	records, total, err := m.repo.QueryLogs(query.WebsiteID, storage.LogQuery{
+		Page:      page,
+		PageSize:  pageSize,
+		SortField: sortField,
+		SortOrder: sortOrder,
+		Filter:    filter,
+	})
 	if err != nil {
-		return result, fmt.Errorf("查询日志失败: %v", err)
+		return result, err
 	}
-	defer rows.Close()
-
-	// 处理结果
-	logs := make([]LogEntry, 0)
-	for rows.Next() {
-		var log LogEntry
-		var pageviewFlag int
-
-		err := rows.Scan(&log.ID, &log.IP, &log.Timestamp, &log.Method, &log.URL, &log.StatusCode,
-			&log.BytesSent, &log.Referer, &log.UserBrowser, &log.UserOS, &log.UserDevice,
-			&log.DomesticLocation, &log.GlobalLocation, &pageviewFlag)
-
-		if err != nil {
-			return result, fmt.Errorf("解析日志行失败: %v", err)
-		}
 
-		// 处理时间
-		log.Time = time.Unix(log.Timestamp, 0).Format("2006-01-02 15:04:05")
-
-		// 处理pageview_flag (SQLite中存储为0/1)
-		log.PageviewFlag = pageviewFlag == 1
-
-		logs = append(logs, log)
-	}
-
-	// 查询总记录数
-	var countQuery strings.Builder
-	countQuery.WriteString(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, tableName))
-
-	var countArgs []interface{}
-	if filter != "" {
-		countQuery.WriteString(" WHERE url LIKE ? OR ip LIKE ? OR referer LIKE ? OR domestic_location LIKE ?")
-		filterArg := "%" + filter + "%"
-		countArgs = append(countArgs, filterArg, filterArg, filterArg, filterArg)
-	}
-
-	var total int
-	err = m.repo.GetDB().QueryRow(countQuery.String(), countArgs...).Scan(&total)
-	if err != nil {
-		return result, fmt.Errorf("获取日志总数失败: %v", err)
+	logs := make([]LogEntry, 0, len(records))
+	for _, record := range records {
+		logs = append(logs, LogEntry{
+			ID:                   int(record.ID),
+			IP:                   record.IP,
+			Timestamp:            record.Timestamp.Unix(),
+			Time:                 record.Timestamp.Format("2006-01-02 15:04:05"),
+			Method:               record.Method,
+			URL:                  record.Url,
+			StatusCode:           record.Status,
+			BytesSent:            record.BytesSent,
+			Referer:              record.Referer,
+			UserBrowser:          record.UserBrowser,
+			UserOS:               record.UserOs,
+			UserDevice:           record.UserDevice,
+			DomesticLocation:     record.DomesticLocation,
+			GlobalLocation:       record.GlobalLocation,
+			PageviewFlag:         record.PageviewFlag == 1,
+			RequestTime:          record.RequestTime,
+			UpstreamResponseTime: record.UpstreamResponseTime,
+			Extra:                record.Extra,
+		})
 	}
 
 	// 设置返回结果
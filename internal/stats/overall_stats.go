@@ -53,7 +53,7 @@ func (s *OverallStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	return result, nil
 }
 
-// StatsByTimePoints 直接使用 db.Query() 方法查询数据库获取指定时间点的统计数据
+// statsByTimeRangeForWebsite 合并时间范围内按小时汇总的数据得到总体统计
 func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 	websiteID string, startTime, endTime time.Time, overall *OverallStats) error {
 
@@ -62,24 +62,18 @@ func (s *OverallStatsManager) statsByTimeRangeForWebsite(
 	overall.UV = 0
 	overall.Traffic = 0
 
-	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
-
-	// 为更精确的统计，直接在数据库中进行全范围的唯一IP计数
-	countQuery := fmt.Sprintf(`
-        SELECT 
-            COUNT(*) as pv,
-            COUNT(DISTINCT ip) as uv,
-            COALESCE(SUM(bytes_sent), 0) as traffic
-        FROM "%s" INDEXED BY idx_%s_pv_ts_ip
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?`,
-		tableName, websiteID)
-
-	// 执行全范围查询
-	row := s.repo.GetDB().QueryRow(countQuery, startTime.Unix(), endTime.Unix())
-
-	if err := row.Scan(&overall.PV, &overall.UV, &overall.Traffic); err != nil {
+	hours, err := s.repo.QueryHourlyStats(websiteID, startTime, endTime)
+	if err != nil {
 		return fmt.Errorf("查询总体统计数据失败: %v", err)
 	}
 
+	visitors := storage.NewUVSketch()
+	for _, hour := range hours {
+		overall.PV += hour.PV
+		overall.Traffic += hour.PVBytesSent
+		visitors.Merge(hour.Visitors)
+	}
+	overall.UV = visitors.Count()
+
 	return nil
 }
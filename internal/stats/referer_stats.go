@@ -6,22 +6,33 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/beyondxinxin/nixvis/internal/storage"
 	"github.com/beyondxinxin/nixvis/internal/util"
 )
 
-type refererDomainStats struct {
+// dimensionRank 按 UV 排序的统计项
+type dimensionRank struct {
+	key string
 	pv  int
-	ips map[string]struct{}
+	uv  int
 }
 
-type refererDomainRank struct {
-	domain string
-	pv     int
-	uv     int
+// sortDimensionRanks 按 UV、PV 降序排序，相同时按键排序保证结果稳定
+func sortDimensionRanks(ranks []dimensionRank) {
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].uv != ranks[j].uv {
+			return ranks[i].uv > ranks[j].uv
+		}
+		if ranks[i].pv != ranks[j].pv {
+			return ranks[i].pv > ranks[j].pv
+		}
+		return ranks[i].key < ranks[j].key
+	})
 }
 
-func (s *ClientStatsManager) queryRefererStats(query StatsQuery, startUnix, endUnix int64, limit int) (StatsResult, error) {
+func (s *ClientStatsManager) queryRefererStats(query StatsQuery, startTime, endTime time.Time, limit int) (StatsResult, error) {
 	result := ClientStats{
 		Key:       make([]string, 0),
 		PV:        make([]int, 0),
@@ -30,74 +41,46 @@ func (s *ClientStatsManager) queryRefererStats(query StatsQuery, startUnix, endU
 		UVPercent: make([]int, 0),
 	}
 
-	dbQueryStr := fmt.Sprintf(`
-        SELECT referer, ip
-        FROM "%[1]s_nginx_logs" INDEXED BY idx_%[1]s_pv_ts_ip
-        WHERE pageview_flag = 1 AND timestamp >= ? AND timestamp < ?
-            AND length(referer) > 0
-            AND referer <> char(45)`,
-		query.WebsiteID)
-
-	rows, err := s.repo.GetDB().Query(dbQueryStr, startUnix, endUnix)
+	referers, err := s.repo.QueryDimensionStats(query.WebsiteID, "referer", startTime, endTime)
 	if err != nil {
 		return result, fmt.Errorf("查询来源域名统计失败: %v", err)
 	}
-	defer rows.Close()
 
+	// 同一域名下不同来源地址的访客合并后再计数
 	internalDomain := currentWebsiteDomain(query.WebsiteID)
-	domains := make(map[string]*refererDomainStats)
-
-	for rows.Next() {
-		var referer string
-		var ip string
-		if err := rows.Scan(&referer, &ip); err != nil {
-			return result, fmt.Errorf("解析来源域名统计失败: %v", err)
-		}
-
+	domains := make(map[string]*storage.DimensionStats)
+	for referer, item := range referers {
 		domain, ok := normalizeRefererDomain(referer)
 		if !ok || !looksLikeDomain(domain) || isInternalDomain(domain, internalDomain) {
 			continue
 		}
 
-		item, ok := domains[domain]
+		stats, ok := domains[domain]
 		if !ok {
-			item = &refererDomainStats{ips: make(map[string]struct{})}
-			domains[domain] = item
+			stats = &storage.DimensionStats{Visitors: storage.NewUVSketch()}
+			domains[domain] = stats
 		}
-		item.pv++
-		item.ips[ip] = struct{}{}
+		stats.PV += item.PV
+		stats.Visitors.Merge(item.Visitors)
 	}
 
-	if err := rows.Err(); err != nil {
-		return result, fmt.Errorf("遍历来源域名统计失败: %v", err)
-	}
-
-	ranks := make([]refererDomainRank, 0, len(domains))
+	ranks := make([]dimensionRank, 0, len(domains))
 	totalPV := 0
 	totalUV := 0
 	for domain, item := range domains {
-		uv := len(item.ips)
-		ranks = append(ranks, refererDomainRank{domain: domain, pv: item.pv, uv: uv})
-		totalPV += item.pv
+		uv := item.Visitors.Count()
+		ranks = append(ranks, dimensionRank{key: domain, pv: item.PV, uv: uv})
+		totalPV += item.PV
 		totalUV += uv
 	}
-
-	sort.Slice(ranks, func(i, j int) bool {
-		if ranks[i].uv != ranks[j].uv {
-			return ranks[i].uv > ranks[j].uv
-		}
-		if ranks[i].pv != ranks[j].pv {
-			return ranks[i].pv > ranks[j].pv
-		}
-		return ranks[i].domain < ranks[j].domain
-	})
+	sortDimensionRanks(ranks)
 
 	if limit > len(ranks) {
 		limit = len(ranks)
 	}
 
 	for i := 0; i < limit; i++ {
-		result.Key = append(result.Key, ranks[i].domain)
+		result.Key = append(result.Key, ranks[i].key)
 		result.PV = append(result.PV, ranks[i].pv)
 		result.UV = append(result.UV, ranks[i].uv)
 	}
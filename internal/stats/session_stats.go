@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// SessionStats 访问质量统计：访问次数、跳出率、平均访问时长和每次访问的页面数
+type SessionStats struct {
+	Visits        int     `json:"visits"`        // 时间范围内开始的访问次数
+	Bounces       int     `json:"bounces"`       // 只浏览了一个页面的访问次数
+	BounceRate    float64 `json:"bounceRate"`    // 跳出率，百分比
+	AvgDuration   float64 `json:"avgDuration"`   // 平均访问时长，单位秒
+	PagesPerVisit float64 `json:"pagesPerVisit"` // 每次访问的平均页面数
+}
+
+// GetType 实现 StatsResult 接口
+func (s SessionStats) GetType() string {
+	return "sessions"
+}
+
+// SessionStatsManager 基于写入日志时划分的访问统计访问质量
+type SessionStatsManager struct {
+	repo *storage.Repository
+}
+
+// NewSessionStatsManager 创建访问统计管理器
+func NewSessionStatsManager(userRepoPtr *storage.Repository) *SessionStatsManager {
+	return &SessionStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 实现 StatsManager 接口，统计在时间范围内开始的访问
+func (m *SessionStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	result := SessionStats{}
+
+	timeRange := query.ExtraParam["timeRange"].(string)
+	startTime, endTime, err := util.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+
+	summary, err := m.repo.QuerySessionSummary(query.WebsiteID, startTime, endTime)
+	if err != nil {
+		return result, err
+	}
+	return sessionStats(summary), nil
+}
+
+// sessionStats 由访问汇总计算跳出率、平均访问时长和每次访问的页面数
+func sessionStats(summary storage.SessionSummary) SessionStats {
+	result := SessionStats{Visits: summary.Visits, Bounces: summary.Bounces}
+	if summary.Visits > 0 {
+		result.BounceRate = float64(summary.Bounces) / float64(summary.Visits) * 100
+		result.AvgDuration = float64(summary.Duration) / float64(summary.Visits)
+		result.PagesPerVisit = float64(summary.Pageviews) / float64(summary.Visits)
+	}
+	return result
+}
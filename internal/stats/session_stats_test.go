@@ -0,0 +1,20 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+func TestSessionStats(t *testing.T) {
+	// 4 次访问，其中 1 次跳出，共浏览 10 个页面、停留 600 秒
+	result := sessionStats(storage.SessionSummary{Visits: 4, Bounces: 1, Pageviews: 10, Duration: 600})
+	expected := SessionStats{Visits: 4, Bounces: 1, BounceRate: 25, AvgDuration: 150, PagesPerVisit: 2.5}
+	if result != expected {
+		t.Fatalf("unexpected session stats: %+v", result)
+	}
+
+	if empty := sessionStats(storage.SessionSummary{}); empty != (SessionStats{}) {
+		t.Fatalf("expected zero rates without visits, got %+v", empty)
+	}
+}
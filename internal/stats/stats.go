@@ -71,6 +71,24 @@ func (f *StatsFactory) registerDefaultManagers() {
 	f.managers["location"] = NewLocationStatsManager(f.repo)
 
 	f.managers["logs"] = NewLogsStatsManager(f.repo)
+
+	f.managers["latency"] = NewLatencyStatsManager(f.repo)
+	f.managers["daily"] = NewDailyStatsManager(f.repo)
+
+	f.managers["sessions"] = NewSessionStatsManager(f.repo)
+	f.managers["entry"] = NewEntryStatsManager(f.repo)
+	f.managers["exit"] = NewExitStatsManager(f.repo)
+
+	f.managers["goals"] = NewGoalStatsManager(f.repo)
+	f.managers["funnel"] = NewFunnelStatsManager(f.repo)
+
+	f.managers["status"] = NewStatusStatsManager(f.repo)
+	f.managers["bandwidth"] = NewBandwidthStatsManager(f.repo)
+}
+
+// Repository 返回统计工厂使用的数据仓库
+func (f *StatsFactory) Repository() *storage.Repository {
+	return f.repo
 }
 
 // GetManager 获取指定类型的统计管理器
@@ -110,6 +128,14 @@ func (f *StatsFactory) QueryStats(managerType string, query StatsQuery) (StatsRe
 	return result, nil
 }
 
+// InvalidateWebsite 清除指定网站的缓存，新日志写入后调用
+func (f *StatsFactory) InvalidateWebsite(websiteID string) {
+	f.cache.DeleteFunc(func(key string) bool {
+		parts := strings.SplitN(key, "-", 3)
+		return len(parts) >= 2 && parts[1] == websiteID
+	})
+}
+
 // buildCacheKey 构建缓存键
 func (f *StatsFactory) buildCacheKey(managerType string, query StatsQuery) string {
 	// 基础键：统计类型-网站ID
@@ -157,6 +183,15 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		"os":         {"id": "string", "timeRange": "string", "limit": "int"},
 		"device":     {"id": "string", "timeRange": "string", "limit": "int"},
 		"location":   {"id": "string", "timeRange": "string", "limit": "int", "locationType": "string"},
+		"latency":    {"id": "string", "timeRange": "string", "viewType": "string", "limit": "int"},
+		"daily":      {"id": "string", "days": "int"},
+		"sessions":   {"id": "string", "timeRange": "string"},
+		"entry":      {"id": "string", "timeRange": "string", "limit": "int"},
+		"exit":       {"id": "string", "timeRange": "string", "limit": "int"},
+		"goals":      {"id": "string", "timeRange": "string"},
+		"funnel":     {"id": "string", "timeRange": "string", "funnel": "string"},
+		"status":     {"id": "string", "timeRange": "string", "viewType": "string", "limit": "int"},
+		"bandwidth":  {"id": "string", "timeRange": "string", "viewType": "string", "limit": "int"},
 		"logs":       {"id": "string", "page": "int", "pageSize": "int", "sortField": "string", "sortOrder": "enum:asc,desc"},
 	}
 
@@ -52,3 +52,15 @@ func (c *StatsCache) Set(key string, value interface{}) {
 		Timestamp: time.Now(),
 	}
 }
+
+// DeleteFunc 删除满足条件的缓存项
+func (c *StatsCache) DeleteFunc(match func(key string) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.items {
+		if match(key) {
+			delete(c.items, key)
+		}
+	}
+}
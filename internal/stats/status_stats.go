@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// maxErrorReferers 每个错误 URL 列出的来源数
+const maxErrorReferers = 5
+
+// StatusSeries 一个状态码或状态码类别的请求数
+type StatusSeries struct {
+	Key    string `json:"key"`    // 状态码，如 404；或类别，如 4xx
+	Total  int    `json:"total"`  // 时间范围内的请求数
+	Series []int  `json:"series"` // 与 Labels 对应的各时段请求数
+}
+
+// RefererCount 来源及其请求数
+type RefererCount struct {
+	Referer string `json:"referer"`
+	Count   int    `json:"count"`
+}
+
+// ErrorURL 返回错误状态码的 URL
+type ErrorURL struct {
+	Status   int            `json:"status"`
+	URL      string         `json:"url"`
+	Count    int            `json:"count"`
+	Referers []RefererCount `json:"referers"` // 请求最多的几个来源
+}
+
+// StatusStats HTTP 状态码统计结果，包括不计入 PV 的请求
+type StatusStats struct {
+	Labels  []string       `json:"labels"`
+	Classes []StatusSeries `json:"classes"` // 按类别排序：2xx、3xx ...
+	Codes   []StatusSeries `json:"codes"`   // 按状态码排序
+	Errors  []ErrorURL     `json:"errors"`  // 各错误状态码下请求最多的 URL，按状态码和请求数排序
+}
+
+// GetType 实现 StatsResult 接口
+func (s StatusStats) GetType() string {
+	return "status"
+}
+
+// StatusStatsManager 统计各状态码的请求趋势和返回错误最多的 URL
+type StatusStatsManager struct {
+	repo *storage.Repository
+}
+
+// NewStatusStatsManager 创建状态码统计管理器
+func NewStatusStatsManager(userRepoPtr *storage.Repository) *StatusStatsManager {
+	return &StatusStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+// Query 实现 StatsManager 接口
+func (m *StatusStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	limit, _ := query.ExtraParam["limit"].(int)
+	timeRange := query.ExtraParam["timeRange"].(string)
+	viewType := query.ExtraParam["viewType"].(string)
+	timePoints, labels := util.TimePointsAndLabels(timeRange, viewType)
+	result := StatusStats{
+		Labels:  labels,
+		Classes: make([]StatusSeries, 0),
+		Codes:   make([]StatusSeries, 0),
+		Errors:  make([]ErrorURL, 0),
+	}
+
+	timeOffset := timePoints[1].Sub(timePoints[0])
+	startTime, endTime := timePoints[0], timePoints[len(timePoints)-1].Add(timeOffset)
+	counts, err := m.repo.QueryStatusCounts(query.WebsiteID, startTime, endTime)
+	if err != nil {
+		return result, err
+	}
+
+	result.Classes, result.Codes = statusSeries(counts, timePoints)
+
+	errors, err := m.repo.QueryErrorURLs(query.WebsiteID, startTime, endTime, limit, maxErrorReferers)
+	if err != nil {
+		return result, err
+	}
+	result.Errors = errorURLs(errors)
+
+	return result, nil
+}
+
+// statusSeries 将各时段的请求数归入所在的时间点，分别按状态码类别和状态码汇总
+func statusSeries(counts []storage.StatusCount, timePoints []time.Time) (classes, codes []StatusSeries) {
+	classSeries := make(map[int]*StatusSeries)
+	codeSeries := make(map[int]*StatusSeries)
+	add := func(series map[int]*StatusSeries, key int, label string, i, count int) {
+		item, ok := series[key]
+		if !ok {
+			item = &StatusSeries{Key: label, Series: make([]int, len(timePoints))}
+			series[key] = item
+		}
+		item.Total += count
+		item.Series[i] += count
+	}
+
+	// 时段已排序，依次归入所在的时间点
+	i := 0
+	for _, count := range counts {
+		for i+1 < len(timePoints) && !count.Time.Before(timePoints[i+1]) {
+			i++
+		}
+		add(classSeries, count.Status/100, fmt.Sprintf("%dxx", count.Status/100), i, count.Count)
+		add(codeSeries, count.Status, strconv.Itoa(count.Status), i, count.Count)
+	}
+	return sortedStatusSeries(classSeries), sortedStatusSeries(codeSeries)
+}
+
+// sortedStatusSeries 按状态码或类别从小到大排列
+func sortedStatusSeries(series map[int]*StatusSeries) []StatusSeries {
+	keys := make([]int, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	result := make([]StatusSeries, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *series[key])
+	}
+	return result
+}
+
+// errorURLs 将数据库中排名后的错误 URL 转换为返回结果
+func errorURLs(counts []storage.ErrorURLCount) []ErrorURL {
+	result := make([]ErrorURL, 0, len(counts))
+	for _, count := range counts {
+		item := ErrorURL{Status: count.Status, URL: count.URL, Count: count.Count,
+			Referers: make([]RefererCount, 0, len(count.Referers))}
+		for _, referer := range count.Referers {
+			item.Referers = append(item.Referers, RefererCount{Referer: referer.Referer, Count: referer.Count})
+		}
+		result = append(result, item)
+	}
+	return result
+}
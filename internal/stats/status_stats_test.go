@@ -0,0 +1,57 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/storage"
+)
+
+func TestStatusSeries(t *testing.T) {
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	timePoints := []time.Time{start, start.Add(time.Hour), start.Add(2 * time.Hour)}
+	counts := []storage.StatusCount{
+		{Time: start, Status: 200, Count: 5},
+		{Time: start.Add(15 * time.Minute), Status: 404, Count: 1},
+		{Time: start.Add(75 * time.Minute), Status: 200, Count: 3},
+		{Time: start.Add(75 * time.Minute), Status: 301, Count: 2},
+		{Time: start.Add(135 * time.Minute), Status: 404, Count: 4},
+		{Time: start.Add(135 * time.Minute), Status: 410, Count: 1},
+	}
+
+	classes, codes := statusSeries(counts, timePoints)
+	expectedClasses := []StatusSeries{
+		{Key: "2xx", Total: 8, Series: []int{5, 3, 0}},
+		{Key: "3xx", Total: 2, Series: []int{0, 2, 0}},
+		{Key: "4xx", Total: 6, Series: []int{1, 0, 5}},
+	}
+	if !reflect.DeepEqual(classes, expectedClasses) {
+		t.Fatalf("unexpected status classes: %+v", classes)
+	}
+	expectedCodes := []StatusSeries{
+		{Key: "200", Total: 8, Series: []int{5, 3, 0}},
+		{Key: "301", Total: 2, Series: []int{0, 2, 0}},
+		{Key: "404", Total: 5, Series: []int{1, 0, 4}},
+		{Key: "410", Total: 1, Series: []int{0, 0, 1}},
+	}
+	if !reflect.DeepEqual(codes, expectedCodes) {
+		t.Fatalf("unexpected status codes: %+v", codes)
+	}
+}
+
+func TestErrorURLs(t *testing.T) {
+	counts := []storage.ErrorURLCount{
+		{Status: 404, URL: "/old.css", Count: 5, Referers: []storage.ErrorRefererCount{
+			{Referer: "https://other.example/", Count: 3}, {Referer: "https://example.com/", Count: 2}}},
+		{Status: 502, URL: "/api", Count: 1, Referers: []storage.ErrorRefererCount{{Referer: "-", Count: 1}}},
+	}
+	expected := []ErrorURL{
+		{Status: 404, URL: "/old.css", Count: 5, Referers: []RefererCount{
+			{Referer: "https://other.example/", Count: 3}, {Referer: "https://example.com/", Count: 2}}},
+		{Status: 502, URL: "/api", Count: 1, Referers: []RefererCount{{Referer: "-", Count: 1}}},
+	}
+	if result := errorURLs(counts); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("unexpected error urls: %+v", result)
+	}
+}
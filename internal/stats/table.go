@@ -0,0 +1,162 @@
+package stats
+
+// Table 以行和列表示的统计结果，用于导出为 CSV、Parquet 等格式
+//
+// 单元格的值为 string、int、int64、float64 或 nil。
+type Table struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// Tabular 可以导出为表格的统计结果，新增统计类型时实现该接口即可支持导出
+type Tabular interface {
+	Table() Table
+}
+
+// Table 实现 Tabular 接口
+func (s ClientStats) Table() Table {
+	table := Table{Columns: []string{"key", "pv", "uv", "pv_percent", "uv_percent"}}
+	for i, key := range s.Key {
+		row := []any{key, s.PV[i], s.UV[i], nil, nil}
+		// 没有访问时不计算百分比
+		if i < len(s.PVPercent) {
+			row[3] = s.PVPercent[i]
+		}
+		if i < len(s.UVPercent) {
+			row[4] = s.UVPercent[i]
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口
+func (s TimeSeriesStats) Table() Table {
+	table := Table{Columns: []string{"label", "pageviews", "visitors", "pv_minus_uv"}}
+	for i, label := range s.Labels {
+		table.Rows = append(table.Rows, []any{label, s.Pageviews[i], s.Visitors[i], s.PvMinusUv[i]})
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口
+func (s OverallStats) Table() Table {
+	return Table{
+		Columns: []string{"pv", "uv", "traffic"},
+		Rows:    [][]any{{s.PV, s.UV, s.Traffic}},
+	}
+}
+
+// Table 实现 Tabular 接口，慢 URL 和各时段的耗时以 scope 列区分
+func (s LatencyStats) Table() Table {
+	table := Table{Columns: []string{
+		"scope", "key", "count", "p50", "p90", "p99",
+		"upstream_count", "upstream_p50", "upstream_p90", "upstream_p99",
+	}}
+	for _, url := range s.URLs {
+		row := []any{"url", url.URL, url.Count, url.P50, url.P90, url.P99, nil, nil, nil, nil}
+		if url.Upstream != nil {
+			row[6], row[7], row[8], row[9] = url.Upstream.Count, url.Upstream.P50, url.Upstream.P90, url.Upstream.P99
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	for i, period := range s.Series {
+		table.Rows = append(table.Rows, []any{
+			"period", s.Labels[i], period.Count, period.P50, period.P90, period.P99, nil, nil, nil, nil,
+		})
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口
+func (s DailyTrendStats) Table() Table {
+	table := Table{Columns: []string{
+		"date", "pageviews", "visitors", "requests", "bytes_sent",
+		"last_year_pageviews", "last_year_visitors",
+	}}
+	for i, date := range s.Labels {
+		table.Rows = append(table.Rows, []any{
+			date, s.Pageviews[i], s.Visitors[i], s.Requests[i], s.BytesSent[i],
+			s.LastYearPV[i], s.LastYearUV[i],
+		})
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口
+func (s SessionStats) Table() Table {
+	return Table{
+		Columns: []string{"visits", "bounces", "bounce_rate", "avg_duration", "pages_per_visit"},
+		Rows:    [][]any{{s.Visits, s.Bounces, s.BounceRate, s.AvgDuration, s.PagesPerVisit}},
+	}
+}
+
+// Table 实现 Tabular 接口
+func (s EntryExitStats) Table() Table {
+	table := Table{Columns: []string{
+		"url", "entries", "exits", "bounces", "pageviews", "bounce_rate", "exit_rate",
+	}}
+	for _, page := range s.Pages {
+		table.Rows = append(table.Rows, []any{
+			page.URL, page.Entries, page.Exits, page.Bounces, page.Pageviews, page.BounceRate, page.ExitRate,
+		})
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口
+func (s GoalStats) Table() Table {
+	table := Table{Columns: []string{"goal", "visits", "conversions", "visitors", "conversion_rate"}}
+	for _, goal := range s.Goals {
+		table.Rows = append(table.Rows, []any{goal.Name, s.Visits, goal.Conversions, goal.Visitors, goal.ConversionRate})
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口
+func (s FunnelStats) Table() Table {
+	table := Table{Columns: []string{"step", "goal", "visits", "drop_off", "step_rate", "overall_rate"}}
+	for i, step := range s.Steps {
+		table.Rows = append(table.Rows, []any{i + 1, step.Goal, step.Visits, step.DropOff, step.StepRate, step.OverallRate})
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口，各时段的请求数和错误 URL 以 scope 列区分，错误 URL 的来源单独成行
+func (s StatusStats) Table() Table {
+	table := Table{Columns: []string{"scope", "key", "label", "url", "referer", "count"}}
+	for _, scope := range []struct {
+		name   string
+		series []StatusSeries
+	}{{"class", s.Classes}, {"code", s.Codes}} {
+		for _, item := range scope.series {
+			for i, count := range item.Series {
+				table.Rows = append(table.Rows, []any{scope.name, item.Key, s.Labels[i], nil, nil, count})
+			}
+		}
+	}
+	for _, item := range s.Errors {
+		table.Rows = append(table.Rows, []any{"error", item.Status, nil, item.URL, nil, item.Count})
+		for _, referer := range item.Referers {
+			table.Rows = append(table.Rows, []any{"referer", item.Status, nil, item.URL, referer.Referer, referer.Count})
+		}
+	}
+	return table
+}
+
+// Table 实现 Tabular 接口，各时段的流量和各维度的排名以 scope 列区分
+func (s BandwidthStats) Table() Table {
+	table := Table{Columns: []string{"scope", "key", "requests", "bytes_sent", "pageview_bytes", "percent"}}
+	for i, label := range s.Labels {
+		table.Rows = append(table.Rows, []any{"time", label, s.Requests[i], s.BytesSent[i], s.PageviewBytes[i], nil})
+	}
+	for _, scope := range []struct {
+		name  string
+		items []BandwidthItem
+	}{{"url", s.URLs}, {"extension", s.Extensions}, {"ip", s.IPs}, {"client", s.Clients}} {
+		for _, item := range scope.items {
+			table.Rows = append(table.Rows, []any{scope.name, item.Key, item.Requests, item.BytesSent, nil, item.Percent})
+		}
+	}
+	return table
+}
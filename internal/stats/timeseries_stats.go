@@ -2,7 +2,6 @@ package stats
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/storage"
@@ -62,7 +61,7 @@ func (s *TimeSeriesStatsManager) Query(query StatsQuery) (StatsResult, error) {
 	return result, nil
 }
 
-// statsByTimePointsForWebsite 根据多个时间点批量查询统计数据
+// statsByTimePointsForWebsite 根据多个时间点批量查询统计数据，由按小时汇总的数据合并得到
 func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
 	websiteID string, timePoints []time.Time) ([]StatPoint, error) {
 
@@ -70,70 +69,30 @@ func (s *TimeSeriesStatsManager) statsByTimePointsForWebsite(
 	timeOffset := timePoints[1].Sub(timePoints[0])
 	results := make([]StatPoint, timePointsSize)
 
-	tx, err := s.repo.GetDB().Begin()
+	hours, err := s.repo.QueryHourlyStats(
+		websiteID, timePoints[0], timePoints[timePointsSize-1].Add(timeOffset))
 	if err != nil {
-		return nil, fmt.Errorf("开始事务失败: %v", err)
+		return nil, fmt.Errorf("查询按小时统计失败: %v", err)
 	}
-	defer tx.Rollback()
-
-	tableName := fmt.Sprintf("%s_nginx_logs", websiteID)
-
-	// 关键优化点1: 合并多个查询为一个批量查询
-	args := make([]any, 0, timePointsSize*2)
-
-	for i := range timePointsSize {
-		startTime := timePoints[i]
-		endTime := startTime.Add(timeOffset)
-		args = append(args, startTime.Unix(), endTime.Unix())
-	}
-
-	// 关键优化点3: 构建一次性批量查询SQL
-	batchQuery := fmt.Sprintf(`
-        WITH time_ranges(range_index, start_time, end_time) AS (
-            VALUES %s
-        )
-        SELECT 
-            tr.range_index,
-            COUNT(l.pageview_flag) as pv,
-            COUNT(DISTINCT l.ip) as uv
-        FROM time_ranges tr
-        LEFT JOIN "%s" l INDEXED BY idx_%s_pv_ts_ip
-            ON l.pageview_flag = 1 AND l.timestamp >= tr.start_time AND l.timestamp < tr.end_time
-        GROUP BY tr.range_index
-        ORDER BY tr.range_index`,
-		formatRangeValues(timePointsSize), tableName, websiteID)
-
-	rows, err := tx.Query(batchQuery, args...)
-	if err != nil {
-		return nil, fmt.Errorf("执行批量查询失败: %v", err)
-	}
-	defer rows.Close()
 
+	// 时间点和小时均已排序，依次归入所在的时间段
+	visitors := make([]*storage.UVSketch, timePointsSize)
 	i := 0
-	for rows.Next() {
-		var rangeIdx int
-		if err := rows.Scan(&rangeIdx, &results[i].PV, &results[i].UV); err != nil {
-			return nil, fmt.Errorf("读取查询结果失败: %v", err)
+	for _, hour := range hours {
+		for i+1 < timePointsSize && !hour.Hour.Before(timePoints[i+1]) {
+			i++
 		}
-		i++
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("遍历结果集时发生错误: %v", err)
+		results[i].PV += hour.PV
+		if visitors[i] == nil {
+			visitors[i] = storage.NewUVSketch()
+		}
+		visitors[i].Merge(hour.Visitors)
 	}
-
-	if err = tx.Commit(); err != nil {
-		return nil, fmt.Errorf("提交事务失败: %v", err)
+	for i, sketch := range visitors {
+		if sketch != nil {
+			results[i].UV = sketch.Count()
+		}
 	}
 
 	return results, nil
 }
-
-// formatRangeValues 生成SQL中的值列表 (0, ?, ?), (1, ?, ?), ...
-func formatRangeValues(count int) string {
-	values := make([]string, count)
-	for i := 0; i < count; i++ {
-		values[i] = fmt.Sprintf("(%d, ?, ?)", i)
-	}
-	return strings.Join(values, ", ")
-}
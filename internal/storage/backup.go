@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+const (
+	backupManifestName = "manifest.json"
+	backupDatabaseName = "nixvis.db"
+	backupStateName    = "nginx_scan_state.json" // 旧版本的备份中单独保存的扫描状态
+)
+
+// BackupManifest 备份包中的说明文件
+type BackupManifest struct {
+	Version       string            `json:"version"`        // 生成备份的程序版本
+	SchemaVersion int               `json:"schema_version"` // 数据库结构版本
+	CreatedAt     time.Time         `json:"created_at"`
+	Files         map[string]string `json:"files"` // 文件名 -> SHA-256
+}
+
+// Backup 在服务运行时生成数据库的一致快照，写入 tar.gz 文件，只支持 SQLite
+//
+// 扫描状态保存在数据库中，与日志始终一致。
+func (r *Repository) Backup(path string) (*BackupManifest, error) {
+	if r.db.dialect.SnapshotSQL() == "" {
+		return nil, fmt.Errorf("%s 数据库请使用数据库自带的工具备份，如 pg_dump", r.db.dialect.Name())
+	}
+	return r.backup(path)
+}
+
+func (r *Repository) backup(path string) (*BackupManifest, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	workDir, err := os.MkdirTemp(filepath.Dir(path), ".nixvis-backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	databaseFile := filepath.Join(workDir, backupDatabaseName)
+	if _, err := r.db.Exec(r.db.dialect.SnapshotSQL(), databaseFile); err != nil {
+		return nil, fmt.Errorf("复制数据库失败: %v", err)
+	}
+
+	manifest := &BackupManifest{Version: util.Version, CreatedAt: time.Now(), Files: make(map[string]string)}
+	database, err := os.Open(databaseFile)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files[backupDatabaseName], err = hashFileContent(database)
+	database.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", databaseFile)
+	if err != nil {
+		return nil, err
+	}
+	manifest.SchemaVersion, err = newRepository(db, sqliteDialect{}).SchemaVersion()
+	db.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	archivePath := filepath.Join(workDir, "backup.tar.gz")
+	if err := writeBackupArchive(archivePath, manifest, databaseFile); err != nil {
+		return nil, err
+	}
+	return manifest, os.Rename(archivePath, path)
+}
+
+// writeBackupArchive 依次写入说明文件和数据库
+func writeBackupArchive(path string, manifest *BackupManifest, databaseFile string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarEntry(tarWriter, backupManifestName, int64(len(manifestData)), bytes.NewReader(manifestData)); err != nil {
+		return err
+	}
+
+	database, err := os.Open(databaseFile)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	info, err := database.Stat()
+	if err != nil {
+		return err
+	}
+	if err := writeTarEntry(tarWriter, backupDatabaseName, info.Size(), database); err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func writeTarEntry(writer *tar.Writer, name string, size int64, content io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(writer, content)
+	return err
+}
+
+// RestoreBackup 校验备份文件后替换当前的数据库和扫描状态，须在服务停止时执行
+//
+// 原数据库移动到 backups 目录下，不会被删除。旧版本备份中的扫描状态文件恢复到原位置，
+// 启动时导入数据库。
+func RestoreBackup(path string) (*BackupManifest, error) {
+	return restoreBackup(path, databasePath, scanStatePath, migrationBackupDir())
+}
+
+func restoreBackup(path, databaseFile, statePath, backupDir string) (*BackupManifest, error) {
+	workDir, err := os.MkdirTemp(filepath.Dir(databaseFile), ".nixvis-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	manifest, hashes, err := extractBackupArchive(path, workDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := hashes[backupDatabaseName]; !ok {
+		return nil, errors.New("备份中缺少数据库文件")
+	}
+	for name, expected := range manifest.Files {
+		if hashes[name] != expected {
+			return nil, fmt.Errorf("备份中的 %s 校验失败，文件可能已损坏", name)
+		}
+	}
+	for name := range hashes {
+		if _, ok := manifest.Files[name]; !ok {
+			return nil, fmt.Errorf("备份中的 %s 不在说明文件中", name)
+		}
+	}
+
+	restoredDatabase := filepath.Join(workDir, backupDatabaseName)
+	if err := checkBackupDatabase(restoredDatabase); err != nil {
+		return nil, err
+	}
+
+	// 当前数据库连同 WAL 文件一起移走，避免旧的 WAL 被应用到恢复的数据库上
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return nil, err
+	}
+	suffix := ".before-restore-" + time.Now().Format("20060102150405")
+	for _, name := range []string{databaseFile, databaseFile + "-wal", databaseFile + "-shm", statePath} {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(name, filepath.Join(backupDir, filepath.Base(name)+suffix)); err != nil {
+			return nil, fmt.Errorf("移动原有的 %s 失败: %v", name, err)
+		}
+	}
+
+	if err := os.Rename(restoredDatabase, databaseFile); err != nil {
+		return nil, err
+	}
+	if _, ok := hashes[backupStateName]; ok {
+		if err := os.Rename(filepath.Join(workDir, backupStateName), statePath); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// extractBackupArchive 解压备份文件，返回说明文件和各文件的 SHA-256
+func extractBackupArchive(path, dir string) (*BackupManifest, map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s 不是有效的备份文件: %v", path, err)
+	}
+	defer gzipReader.Close()
+
+	var manifest *BackupManifest
+	hashes := make(map[string]string)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("读取备份文件失败: %v", err)
+		}
+
+		switch header.Name {
+		case backupManifestName:
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(tarReader).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("解析备份说明文件失败: %v", err)
+			}
+		case backupDatabaseName, backupStateName:
+			output, err := os.Create(filepath.Join(dir, header.Name))
+			if err != nil {
+				return nil, nil, err
+			}
+			hash := sha256.New()
+			_, err = io.Copy(io.MultiWriter(output, hash), tarReader)
+			output.Close()
+			if err != nil {
+				return nil, nil, fmt.Errorf("解压 %s 失败: %v", header.Name, err)
+			}
+			hashes[header.Name] = hex.EncodeToString(hash.Sum(nil))
+		default:
+			return nil, nil, fmt.Errorf("备份中包含未知文件 %s", header.Name)
+		}
+	}
+
+	if manifest == nil {
+		return nil, nil, errors.New("备份中缺少说明文件")
+	}
+	return manifest, hashes, nil
+}
+
+// checkBackupDatabase 检查数据库的完整性，以及结构版本是否为当前程序支持
+func checkBackupDatabase(path string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("检查数据库完整性失败: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("备份中的数据库已损坏: %s", result)
+	}
+
+	version, err := newRepository(db, sqliteDialect{}).SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > latestSchemaVersion() {
+		return fmt.Errorf("备份的数据库结构版本为 %d，高于当前程序支持的 %d，请先升级 NixVis", version, latestSchemaVersion())
+	}
+	return nil
+}
+
+// readOptionalFile 读取文件，文件不存在时返回 nil
+func readOptionalFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
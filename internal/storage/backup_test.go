@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(dir, "nixvis.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo := newRepository(db, sqliteDialect{})
+	if err := repo.migrate(filepath.Join(dir, "backups")); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := repo.createWebsiteTables("site"); err != nil {
+		t.Fatalf("create tables: %v", err)
+	}
+	if err := repo.createScanStateTable(); err != nil {
+		t.Fatalf("create scan state table: %v", err)
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", []NginxLogRecord{{IP: "192.0.2.1", Timestamp: time.Now(), Url: "/", Status: 200}}); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+	if err := repo.saveScanStates(map[string]LogScanState{"site": {Files: map[string]FileState{"access.log": {LastOffset: 42}}}}); err != nil {
+		t.Fatalf("save scan state: %v", err)
+	}
+
+	archive := filepath.Join(dir, "backup.tar.gz")
+	manifest, err := repo.backup(archive)
+	if err != nil || manifest.SchemaVersion != latestSchemaVersion() || len(manifest.Files) != 1 {
+		t.Fatalf("unexpected backup result: %+v (%v)", manifest, err)
+	}
+
+	// 恢复到另一台主机的空数据目录
+	target := t.TempDir()
+	targetDatabase := filepath.Join(target, "nixvis.db")
+	targetState := filepath.Join(target, "nginx_scan_state.json")
+	if _, err := restoreBackup(archive, targetDatabase, targetState, filepath.Join(target, "backups")); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	restored, err := sql.Open("sqlite", targetDatabase)
+	if err != nil {
+		t.Fatalf("open restored database: %v", err)
+	}
+	defer restored.Close()
+	restoredRepo := newRepository(restored, sqliteDialect{})
+	if count := countTestRows(t, restoredRepo, "site"); count != 1 {
+		t.Fatalf("expected 1 restored row, got %d", count)
+	}
+	if states, err := restoredRepo.loadScanStates(); err != nil || states["site"].Files["access.log"].LastOffset != 42 {
+		t.Fatalf("unexpected restored state: %+v (%v)", states, err)
+	}
+
+	// 损坏的备份不会覆盖现有数据
+	data, _ := os.ReadFile(archive)
+	data[len(data)/2] ^= 0xff
+	corrupted := filepath.Join(dir, "corrupted.tar.gz")
+	os.WriteFile(corrupted, data, 0644)
+	if _, err := restoreBackup(corrupted, targetDatabase, targetState, filepath.Join(target, "backups")); err == nil {
+		t.Fatal("expected a corrupted backup to be rejected")
+	}
+	if _, err := os.Stat(targetDatabase); err != nil {
+		t.Fatalf("expected the current database to be kept: %v", err)
+	}
+}
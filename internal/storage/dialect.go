@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Dialect 数据库方言，屏蔽不同数据库在建表语法、元数据查询等方面的差异
+//
+// Repository 中的 SQL 统一使用 ? 占位符，执行前由 Rebind 改写。新增后端时实现该接口，
+// 并在 dialects 中注册驱动名即可。
+type Dialect interface {
+	// Name 方言名称，与配置中的 database.driver 一致
+	Name() string
+	// DriverName database/sql 驱动名
+	DriverName() string
+	// Rebind 将 ? 占位符改写为数据库使用的形式
+	Rebind(query string) string
+	// InitSQL 打开数据库后执行的设置语句，可为空
+	InitSQL() string
+
+	// AutoIncrementKey 自增整数主键的列定义
+	AutoIncrementKey() string
+	// BlobType 二进制列的类型
+	BlobType() string
+	// TableOptions 追加在 CREATE TABLE 末尾、以复合主键组织的表的选项
+	TableOptions() string
+	// IndexedBy 强制查询使用指定索引的子句，不支持时为空
+	IndexedBy(index string) string
+	// LocalDate 将 Unix 秒转换为本地日期字符串（2006-01-02）的表达式
+	LocalDate(column string) string
+	// CaseInsensitiveLike 不区分大小写的 LIKE 运算符
+	CaseInsensitiveLike() string
+	// CreateView 创建视图，视图已存在时不报错
+	CreateView(name, query string) string
+
+	// TableExistsSQL 判断表是否存在，参数为表名
+	TableExistsSQL() string
+	// ListTablesSQL 按 LIKE 模式列出表名，参数为模式，\ 为转义符
+	ListTablesSQL() string
+	// ColumnExistsSQL 判断列是否存在，参数为表名和列名
+	ColumnExistsSQL() string
+	// ListIndexesSQL 列出表上可以删除重建的索引，参数为表名
+	ListIndexesSQL() string
+	// SnapshotSQL 将数据库复制到文件的语句，参数为文件路径；不支持时为空
+	SnapshotSQL() string
+}
+
+// dialects 支持的数据库方言，键为配置中的 database.driver
+var dialects = map[string]Dialect{
+	"sqlite":   sqliteDialect{},
+	"postgres": postgresDialect{},
+}
+
+// DialectFor 按名称返回数据库方言，名称为空时使用 SQLite
+func DialectFor(name string) (Dialect, error) {
+	if name == "" {
+		name = "sqlite"
+	}
+	dialect, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的数据库类型 %q，可选: sqlite、postgres", name)
+	}
+	return dialect, nil
+}
+
+// sqliteDialect 默认的嵌入式 SQLite 数据库
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                { return "sqlite" }
+func (sqliteDialect) DriverName() string          { return "sqlite" }
+func (sqliteDialect) Rebind(query string) string  { return query }
+func (sqliteDialect) AutoIncrementKey() string    { return "INTEGER PRIMARY KEY AUTOINCREMENT" }
+func (sqliteDialect) BlobType() string            { return "BLOB" }
+func (sqliteDialect) TableOptions() string        { return "WITHOUT ROWID" }
+func (sqliteDialect) SnapshotSQL() string         { return `VACUUM INTO ?` }
+func (sqliteDialect) CaseInsensitiveLike() string { return "LIKE" }
+
+func (sqliteDialect) InitSQL() string {
+	return `
+        PRAGMA journal_mode=WAL;
+        PRAGMA synchronous=NORMAL;
+        PRAGMA cache_size=32768;
+        PRAGMA temp_store=MEMORY;`
+}
+
+func (sqliteDialect) IndexedBy(index string) string {
+	return "INDEXED BY " + index
+}
+
+func (sqliteDialect) LocalDate(column string) string {
+	return fmt.Sprintf("date(%s, 'unixepoch', 'localtime')", column)
+}
+
+func (sqliteDialect) CreateView(name, query string) string {
+	return fmt.Sprintf(`CREATE VIEW IF NOT EXISTS "%s" AS %s`, name, query)
+}
+
+func (sqliteDialect) TableExistsSQL() string {
+	return `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)`
+}
+
+func (sqliteDialect) ListTablesSQL() string {
+	return `SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE ? ESCAPE '\' ORDER BY name`
+}
+
+func (sqliteDialect) ColumnExistsSQL() string {
+	return `SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`
+}
+
+func (sqliteDialect) ListIndexesSQL() string {
+	// 自动创建的索引没有 sql，随表一起重命名
+	return `SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL`
+}
+
+// postgresDialect PostgreSQL 数据库，多个 NixVis 实例可以共用一个数据库
+//
+// 本地日期按连接的 TimeZone 计算，可在连接串中设置，如 options='-c TimeZone=Asia/Shanghai'。
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string                { return "postgres" }
+func (postgresDialect) DriverName() string          { return "pgx" }
+func (postgresDialect) InitSQL() string             { return "" }
+func (postgresDialect) BlobType() string            { return "BYTEA" }
+func (postgresDialect) TableOptions() string        { return "" }
+func (postgresDialect) IndexedBy(string) string     { return "" }
+func (postgresDialect) CaseInsensitiveLike() string { return "ILIKE" }
+func (postgresDialect) SnapshotSQL() string         { return "" }
+
+func (postgresDialect) AutoIncrementKey() string {
+	return "BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY"
+}
+
+func (postgresDialect) LocalDate(column string) string {
+	return fmt.Sprintf("to_char(to_timestamp(%s), 'YYYY-MM-DD')", column)
+}
+
+func (postgresDialect) CreateView(name, query string) string {
+	return fmt.Sprintf(`CREATE OR REPLACE VIEW "%s" AS %s`, name, query)
+}
+
+func (postgresDialect) TableExistsSQL() string {
+	return `
+        SELECT EXISTS (SELECT 1 FROM information_schema.tables
+            WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' AND table_name = ?)`
+}
+
+func (postgresDialect) ListTablesSQL() string {
+	return `
+        SELECT table_name FROM information_schema.tables
+        WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' AND table_name LIKE ? ESCAPE '\'
+        ORDER BY table_name`
+}
+
+func (postgresDialect) ColumnExistsSQL() string {
+	return `
+        SELECT EXISTS (SELECT 1 FROM information_schema.columns
+            WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?)`
+}
+
+func (postgresDialect) ListIndexesSQL() string {
+	// 主键和唯一约束的索引随表一起重命名
+	return `
+        SELECT i.relname FROM pg_index x
+        JOIN pg_class i ON i.oid = x.indexrelid
+        JOIN pg_class t ON t.oid = x.indrelid
+        WHERE t.relname = ? AND t.relnamespace = current_schema()::regnamespace
+            AND NOT x.indisprimary AND NOT x.indisunique`
+}
+
+// Rebind 将 ? 改写为 $1、$2 ...，跳过字符串和带引号的标识符中的问号
+func (postgresDialect) Rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(query) + 16)
+	index := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			index++
+			builder.WriteByte('$')
+			builder.WriteString(strconv.Itoa(index))
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+// database 执行前按方言改写 SQL 的数据库连接，其余方法与 sql.DB 相同
+type database struct {
+	*sql.DB
+	dialect Dialect
+}
+
+func (d *database) Exec(query string, args ...any) (sql.Result, error) {
+	return d.DB.Exec(d.dialect.Rebind(query), args...)
+}
+
+func (d *database) Query(query string, args ...any) (*sql.Rows, error) {
+	return d.DB.Query(d.dialect.Rebind(query), args...)
+}
+
+func (d *database) QueryRow(query string, args ...any) *sql.Row {
+	return d.DB.QueryRow(d.dialect.Rebind(query), args...)
+}
+
+func (d *database) Begin() (*transaction, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, dialect: d.dialect}, nil
+}
+
+// transaction 执行前按方言改写 SQL 的事务
+type transaction struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (t *transaction) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.Rebind(query), args...)
+}
+
+func (t *transaction) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.Rebind(query), args...)
+}
+
+func (t *transaction) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.Rebind(query), args...)
+}
+
+func (t *transaction) Prepare(query string) (*sql.Stmt, error) {
+	return t.Tx.Prepare(t.dialect.Rebind(query))
+}
+
+// upsertSQL 生成按主键插入或覆盖的语句，SQLite 和 PostgreSQL 均支持 ON CONFLICT
+func upsertSQL(table string, keys, columns []string) string {
+	all := append(append([]string{}, keys...), columns...)
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		updates[i] = fmt.Sprintf("%[1]s = excluded.%[1]s", column)
+	}
+	return fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s`,
+		table, strings.Join(all, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", "),
+		strings.Join(keys, ", "), strings.Join(updates, ", "))
+}
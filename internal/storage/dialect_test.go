@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPostgresRebindSkipsQuotedText(t *testing.T) {
+	query := `SELECT '?', "a?" FROM t WHERE a = ? AND b LIKE '%\_x' ESCAPE '\' AND c = ?`
+	expected := `SELECT '?', "a?" FROM t WHERE a = $1 AND b LIKE '%\_x' ESCAPE '\' AND c = $2`
+	if rebound := (postgresDialect{}).Rebind(query); rebound != expected {
+		t.Fatalf("unexpected query: %s", rebound)
+	}
+}
+
+// TestRepositoryDialects 在各数据库上执行同一组写入和查询，PostgreSQL 需设置
+// NIXVIS_TEST_POSTGRES_DSN 指向一个可写的测试库，CI 的 Test 工作流中由 postgres 服务提供
+func TestRepositoryDialects(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testRepositoryQueries(t, newTestRepository(t, "site"), "site")
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("NIXVIS_TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("未设置 NIXVIS_TEST_POSTGRES_DSN")
+		}
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			t.Fatalf("open database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		websiteID := fmt.Sprintf("test_%d", time.Now().UnixNano())
+		repo := newRepository(db, postgresDialect{})
+		t.Cleanup(func() {
+			db.Exec(fmt.Sprintf(`DROP VIEW IF EXISTS "%s"`, LogsView(websiteID)))
+			for _, suffix := range websiteTableSuffixes {
+				db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s_%s"`, websiteID, suffix))
+			}
+		})
+		if err := repo.createWebsiteTables(websiteID); err != nil {
+			t.Fatalf("create tables: %v", err)
+		}
+		testRepositoryQueries(t, repo, websiteID)
+	})
+}
+
+func testRepositoryQueries(t *testing.T, repo *Repository, websiteID string) {
+	t.Helper()
+	day := startOfDay(time.Now().AddDate(0, 0, -2))
+	requestTime := 0.5
+	logs := []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: day.Add(time.Hour), Method: "GET", Url: "/Docs", Status: 200, BytesSent: 10, RequestTime: &requestTime},
+		{IP: "192.0.2.2", PageviewFlag: 1, Timestamp: day.Add(2 * time.Hour), Method: "GET", Url: "/", Status: 200, BytesSent: 20, Extra: map[string]string{"host": "a"}},
+		{IP: "192.0.2.1", PageviewFlag: 0, Timestamp: day.Add(25 * time.Hour), Method: "GET", Url: "/a.css", Status: 404, BytesSent: 30},
+	}
+	if err := repo.BatchInsertLogsForWebsite(websiteID, logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	if exists, err := repo.HasWebsiteData(websiteID); err != nil || !exists {
+		t.Fatalf("expected website tables, got %v (%v)", exists, err)
+	}
+	if ok, err := repo.hasColumn(websiteID+"_nginx_logs", "url_id"); err != nil || !ok {
+		t.Fatalf("expected url_id column, got %v (%v)", ok, err)
+	}
+
+	hours, err := repo.QueryHourlyStats(websiteID, day, day.AddDate(0, 0, 2))
+	if err != nil || len(hours) != 3 || hours[0].PV != 1 || hours[2].Status4xx != 1 {
+		t.Fatalf("unexpected hourly stats: %+v (%v)", hours, err)
+	}
+	urls, err := repo.QueryDimensionStats(websiteID, "url", day, day.AddDate(0, 0, 2))
+	if err != nil || len(urls) != 2 || urls["/Docs"].PV != 1 || urls["/"].Visitors.Count() != 1 {
+		t.Fatalf("unexpected url stats: %+v (%v)", urls, err)
+	}
+	days, err := repo.QueryDailyStats(websiteID, day, day.AddDate(0, 0, 2))
+	if err != nil || len(days) != 2 || days[0].PV != 2 || days[0].UV != 2 || days[1].Requests != 1 || days[1].BytesSent != 30 {
+		t.Fatalf("unexpected daily stats: %+v (%v)", days, err)
+	}
+
+	// 日志查询的过滤不区分大小写
+	page, total, err := repo.QueryLogs(websiteID, LogQuery{Page: 1, PageSize: 10, SortField: "timestamp", SortOrder: "asc", Filter: "docs"})
+	if err != nil || total != 1 || len(page) != 1 || page[0].Url != "/Docs" || *page[0].RequestTime != requestTime {
+		t.Fatalf("unexpected logs: %+v, total %d (%v)", page, total, err)
+	}
+	page, total, err = repo.QueryLogs(websiteID, LogQuery{Page: 2, PageSize: 2, SortField: "bytes_sent", SortOrder: "desc"})
+	if err != nil || total != 3 || len(page) != 1 || page[0].BytesSent != 10 {
+		t.Fatalf("unexpected second page: %+v, total %d (%v)", page, total, err)
+	}
+
+	// 0.5 秒落在上限不小于 0.5 秒且误差不超过 10% 的桶中
+	bucket := 0
+	for LatencyBucketUpperBound(bucket) < requestTime {
+		bucket++
+	}
+	latency, err := repo.QueryURLLatency(websiteID, day, day.AddDate(0, 0, 1))
+	if err != nil || len(latency) != 1 || latency["/Docs"] == nil ||
+		!reflect.DeepEqual(latency["/Docs"].Request, LatencyHistogram{bucket: 1}) || latency["/Docs"].Upstream != nil {
+		t.Fatalf("unexpected url latency: %+v (%v)", latency, err)
+	}
+	if LatencyBucketUpperBound(bucket) > requestTime*1.1 {
+		t.Fatalf("bucket %d is too coarse", bucket)
+	}
+	periods, err := repo.QueryLatencyPeriods(websiteID, day, day.AddDate(0, 0, 1))
+	if err != nil || len(periods) != 1 || !periods[0].Time.Equal(day.Add(time.Hour)) ||
+		!reflect.DeepEqual(periods[0].Histogram, LatencyHistogram{bucket: 1}) {
+		t.Fatalf("unexpected latency periods: %+v (%v)", periods, err)
+	}
+
+	if summary, err := repo.QuerySessionSummary(websiteID, day, day.AddDate(0, 0, 2)); err != nil ||
+		summary != (SessionSummary{Visits: 2, Bounces: 2, Pageviews: 2}) {
+		t.Fatalf("unexpected session summary: %+v (%v)", summary, err)
+	}
+
+	if _, err := repo.rollupDailyStats(websiteID, day); err != nil {
+		t.Fatalf("rollup daily stats: %v", err)
+	}
+	if rolled, err := repo.QueryDailyStats(websiteID, day, day.AddDate(0, 0, 2)); err != nil || rolled[0] != days[0] || rolled[1] != days[1] {
+		t.Fatalf("rolled up stats differ: %+v (%v)", rolled, err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity 返回文件所在设备号和 inode
+func fileIdentity(info os.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package storage
+
+import "os"
+
+// fileIdentity Windows 下无法从 FileInfo 获取文件 ID，轮转检测只依赖内容指纹
+func fileIdentity(info os.FileInfo) (uint64, uint64) {
+	return 0, 0
+}
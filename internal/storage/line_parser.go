@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/beyondxinxin/nixvis/internal/netparser"
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// LineParser 日志行解析器，将单行日志解析为以 Nginx 变量名为键的字段
+//
+// 字段随后由 buildLogRecord 统一映射为 NginxLogRecord。解析器按文件创建，
+// 可以在同一文件内保存状态，例如 W3C 日志的 #Fields 指令。
+type LineParser interface {
+	ParseLine(line string) (map[string]string, error)
+}
+
+// headerParser 依赖文件头部指令的解析器，从文件中间开始读取前需要先读取指令行
+type headerParser interface {
+	LineParser
+	NeedsHeader() bool
+	// Header 返回恢复当前状态所需的指令行，保存在扫描状态中，下次从中间读取时依次交给 ParseLine
+	Header() []string
+}
+
+// LineParserFactory 为每个日志文件创建解析器
+type LineParserFactory func() LineParser
+
+// LogFormats 支持的日志格式，即网站配置中 format 的可选值
+var LogFormats = []string{"nginx", "json", "caddy", "traefik", "clf", "apache", "w3c"}
+
+// newLineParserFactory 根据网站的 format 配置创建解析器工厂，配置了 realIPHeader 时还原真实 IP
+func newLineParserFactory(website util.WebsiteConfig) (LineParserFactory, error) {
+	factory, err := newFormatParserFactory(website)
+	if err != nil || strings.TrimSpace(website.RealIPHeader) == "" {
+		return factory, err
+	}
+
+	resolver, err := netparser.NewRealIPResolver(website.RealIPHeader, website.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return func() LineParser {
+		return &realIPParser{LineParser: factory(), resolver: resolver}
+	}, nil
+}
+
+// newFormatParserFactory 根据网站的 format 配置创建解析器工厂
+func newFormatParserFactory(website util.WebsiteConfig) (LineParserFactory, error) {
+	var parser LineParser
+	var err error
+
+	switch strings.ToLower(website.Format) {
+	case "", "nginx":
+		if strings.TrimSpace(website.LogFormat) == "" {
+			parser = defaultNginxLogFormat
+		} else {
+			parser, err = compileNginxLogFormat(website.LogFormat)
+		}
+	case "json", "caddy", "traefik":
+		parser, err = compileJSONLogFormat(strings.ToLower(website.Format), website.Fields)
+	case "clf", "common":
+		parser, err = compileApacheLogFormats(ApacheCommonLogFormat)
+	case "apache":
+		if strings.TrimSpace(website.LogFormat) != "" {
+			parser, err = compileApacheLogFormats(website.LogFormat)
+		} else {
+			parser, err = compileApacheLogFormats(
+				ApacheCombinedDurationLogFormat, ApacheCombinedLogFormat, ApacheCommonLogFormat)
+		}
+	case "w3c", "iis":
+		return func() LineParser { return newW3CLogParser() }, nil
+	default:
+		return nil, fmt.Errorf("不支持的日志格式 %q，可选值: %s",
+			website.Format, strings.Join(LogFormats, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 无状态的解析器可在文件之间共享
+	return func() LineParser { return parser }, nil
+}
+
+// realIPParser 位于 CDN 或负载均衡之后时，用请求头中的客户端地址替换 remote_addr
+//
+// 原始的代理地址保存在 realip_remote_addr 中，与 Nginx 的同名变量一致。
+type realIPParser struct {
+	LineParser
+	resolver *netparser.RealIPResolver
+}
+
+// ParseLine 解析日志行并还原客户端真实 IP
+func (p *realIPParser) ParseLine(line string) (map[string]string, error) {
+	fields, err := p.LineParser.ParseLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteAddr := fields["remote_addr"]
+	if realIP := p.resolver.Resolve(remoteAddr, fields[p.resolver.Field()]); realIP != remoteAddr {
+		fields["realip_remote_addr"] = remoteAddr
+		fields["remote_addr"] = realIP
+	}
+	return fields, nil
+}
+
+// NeedsHeader 与被包装的解析器一致
+func (p *realIPParser) NeedsHeader() bool {
+	return needsHeader(p.LineParser)
+}
+
+// Header 与被包装的解析器一致
+func (p *realIPParser) Header() []string {
+	return parserHeader(p.LineParser)
+}
+
+// needsHeader 判断解析器是否依赖文件头部的指令
+func needsHeader(parser LineParser) bool {
+	headerAware, ok := parser.(headerParser)
+	return ok && headerAware.NeedsHeader()
+}
+
+// parserHeader 返回解析器当前依赖的指令行，不依赖文件头的解析器返回空
+func parserHeader(parser LineParser) []string {
+	if !needsHeader(parser) {
+		return nil
+	}
+	return parser.(headerParser).Header()
+}
+
+// readHeaderDirectives 读取 limit 字节内的 # 指令行，供从文件中间开始解析时使用
+//
+// 不依赖文件头的解析器只跳过这部分内容，用于无法随机读取的解压数据流。
+func readHeaderDirectives(reader io.Reader, limit int64, parser LineParser) error {
+	if !needsHeader(parser) || limit <= 0 {
+		_, err := io.CopyN(io.Discard, reader, limit)
+		return err
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(reader, limit))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "#") {
+			parser.ParseLine(line)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveState 已导入的压缩日志，以内容哈希为键保存在扫描状态中
+type ArchiveState struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mod_time"`
+	Imported int64  `json:"imported,omitempty"` // 导入中断时已导入的解压后字节数，为 0 表示已完整导入
+}
+
+// isCompressedLog 根据扩展名判断是否为压缩的归档日志
+func isCompressedLog(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz", ".bz2", ".zst", ".zstd":
+		return true
+	}
+	return false
+}
+
+// newArchiveReader 根据扩展名创建解压读取器
+func newArchiveReader(path string, file io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz":
+		return gzip.NewReader(file)
+	case ".bz2":
+		return io.NopCloser(bzip2.NewReader(file)), nil
+	case ".zst", ".zstd":
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("不支持的压缩格式: %s", path)
+}
+
+// hashFileContent 计算文件内容的 SHA-256
+func hashFileContent(file *os.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// scanArchiveFile 完整导入一个压缩日志，按内容哈希保证只导入一次
+func (p *LogParser) scanArchiveFile(
+	websiteID string, logPath string, parserResult *ParserResult) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		logrus.Errorf("无法打开日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法读取日志文件 %s: %w", logPath, err)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		logrus.Errorf("无法获取文件信息 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法获取日志文件信息 %s: %w", logPath, err)
+		return
+	}
+
+	state := p.siteState(websiteID)
+
+	// 路径、大小和修改时间都未变化时无需重新计算哈希
+	p.stateMu.Lock()
+	for _, archive := range state.Archives {
+		if archive.Path == logPath && archive.Size == fileInfo.Size() &&
+			archive.ModTime == fileInfo.ModTime().Unix() && archive.Imported == 0 {
+			p.stateMu.Unlock()
+			return
+		}
+	}
+	p.stateMu.Unlock()
+
+	contentHash, err := hashFileContent(file)
+	if err != nil {
+		logrus.Errorf("无法读取日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法读取日志文件 %s: %w", logPath, err)
+		return
+	}
+
+	archiveState := ArchiveState{
+		Path:    logPath,
+		Size:    fileInfo.Size(),
+		ModTime: fileInfo.ModTime().Unix(),
+	}
+
+	// 内容已导入过（例如 logrotate 将 access.log.2.gz 重命名为 access.log.3.gz），
+	// 或内容相同的文件正在由其他协程导入；上次导入中断时从中断的位置继续
+	p.stateMu.Lock()
+	previous, ok := state.Archives[contentHash]
+	if ok && previous.Imported == 0 {
+		state.Archives[contentHash] = archiveState
+		p.stateMu.Unlock()
+		return
+	}
+	if p.importing[contentHash] {
+		p.stateMu.Unlock()
+		return
+	}
+	if p.importing == nil {
+		p.importing = make(map[string]bool)
+	}
+	p.importing[contentHash] = true
+	p.stateMu.Unlock()
+
+	defer func() {
+		p.stateMu.Lock()
+		delete(p.importing, contentHash)
+		p.stateMu.Unlock()
+	}()
+
+	reader, err := newArchiveReader(logPath, file)
+	if err != nil {
+		logrus.Errorf("无法解压日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法解压日志文件 %s: %w", logPath, err)
+		return
+	}
+	defer reader.Close()
+
+	// 压缩前已从未压缩文件读取的部分直接跳过
+	head := make([]byte, fingerprintSize)
+	headSize, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		logrus.Errorf("无法解压日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法解压日志文件 %s: %w", logPath, err)
+		return
+	}
+	head = head[:headSize]
+
+	var content io.Reader = io.MultiReader(bytes.NewReader(head), reader)
+	offset, matched := p.matchArchiveHead(websiteID, head)
+	if matched && offset > 0 {
+		logrus.Infof("压缩日志 %s 的前 %d 字节已在轮转前导入，跳过该部分", logPath, offset)
+	} else {
+		offset = 0
+	}
+	if previous.Imported > offset {
+		logrus.Infof("压缩日志 %s 上次导入中断，从解压后的第 %d 字节继续", logPath, previous.Imported)
+		offset = previous.Imported
+	}
+
+	parser, err := p.newLineParser(websiteID, content, offset)
+	if err != nil {
+		logrus.Errorf("无法解压日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法解压日志文件 %s: %w", logPath, err)
+		return
+	}
+
+	checkpoint := func(state *LogScanState, consumed int64) {
+		partial := archiveState
+		partial.Imported = offset + consumed
+		state.Archives[contentHash] = partial
+	}
+	entriesCount := p.parseLogLines(content, websiteID, parser, parserResult, checkpoint)
+	if entriesCount < 0 {
+		parserResult.Success = false
+		parserResult.Error = errors.New("日志写入失败，读取进度未更新，将在下一轮重试")
+		return
+	}
+	parserResult.TotalEntries += entriesCount
+
+	p.stateMu.Lock()
+	state.Archives[contentHash] = archiveState
+	p.stateMu.Unlock()
+
+	logrus.Infof("网站 %s 的压缩日志 %s 导入完成，解析了 %d 条记录",
+		websiteID, logPath, entriesCount)
+}
+
+// pruneArchiveStates 移除已不存在的压缩日志记录，避免状态无限增长
+func (p *LogParser) pruneArchiveStates(websiteID string) {
+	state, ok := p.lookupSiteState(websiteID)
+	if !ok {
+		return
+	}
+
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	for contentHash, archive := range state.Archives {
+		if _, err := os.Stat(archive.Path); os.IsNotExist(err) {
+			delete(state.Archives, contentHash)
+		}
+	}
+}
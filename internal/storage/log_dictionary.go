@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// dictionaryColumns 日志表中重复度高、以字典 ID 保存的列，日志表中的列名为 <列名>_id
+var dictionaryColumns = []string{
+	"url", "referer", "user_browser", "user_os", "user_device",
+	"domestic_location", "global_location",
+}
+
+// LogsView 返回网站日志视图的名称，视图将字典 ID 还原为原始字符串，列名与旧版日志表一致
+func LogsView(websiteID string) string {
+	return websiteID + "_nginx_logs_v"
+}
+
+// createDictionaryTable 创建网站的字符串字典表
+func (r *Repository) createDictionaryTable(id string) error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS "%s_dictionary" (
+            id %s,
+            value TEXT NOT NULL UNIQUE
+        )`, id, r.db.dialect.AutoIncrementKey()))
+	return err
+}
+
+// createLogsView 创建日志视图，查询时自动关联字典表
+func (r *Repository) createLogsView(id string) error {
+	var columns, joins strings.Builder
+	for _, column := range dictionaryColumns {
+		fmt.Fprintf(&columns, "\n            %[1]s.value AS %[1]s,", column)
+		fmt.Fprintf(&joins, "\n        JOIN \"%[1]s_dictionary\" %[2]s ON %[2]s.id = l.%[2]s_id", id, column)
+	}
+
+	_, err := r.db.Exec(r.db.dialect.CreateView(LogsView(id), fmt.Sprintf(`
+        SELECT
+            l.id, l.ip, l.pageview_flag, l.timestamp, l.method, l.status_code, l.bytes_sent,%[2]s
+            l.extra, l.request_time, l.upstream_response_time
+        FROM "%[1]s_nginx_logs" l%[3]s`,
+		id, columns.String(), joins.String())))
+	return err
+}
+
+// dictionaryWriter 在写入事务中将字符串转换为字典 ID
+type dictionaryWriter struct {
+	lookup *sql.Stmt
+	insert *sql.Stmt
+	ids    map[string]int64
+}
+
+func newDictionaryWriter(tx *transaction, websiteID string) (*dictionaryWriter, error) {
+	lookup, err := tx.Prepare(fmt.Sprintf(`SELECT id FROM "%s_dictionary" WHERE value = ?`, websiteID))
+	if err != nil {
+		return nil, err
+	}
+	insert, err := tx.Prepare(fmt.Sprintf(`INSERT INTO "%s_dictionary" (value) VALUES (?) RETURNING id`, websiteID))
+	if err != nil {
+		lookup.Close()
+		return nil, err
+	}
+	return &dictionaryWriter{lookup: lookup, insert: insert, ids: make(map[string]int64)}, nil
+}
+
+// id 返回字符串的字典 ID，不存在时新增
+func (w *dictionaryWriter) id(value string) (int64, error) {
+	if id, ok := w.ids[value]; ok {
+		return id, nil
+	}
+
+	var id int64
+	err := w.lookup.QueryRow(value).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = w.insert.QueryRow(value).Scan(&id)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	w.ids[value] = id
+	return id, nil
+}
+
+// recordIDs 依次返回日志记录中各字典列的 ID，顺序与 dictionaryColumns 一致
+func (w *dictionaryWriter) recordIDs(log *NginxLogRecord) ([]any, error) {
+	values := []string{
+		log.Url, log.Referer, log.UserBrowser, log.UserOs, log.UserDevice,
+		log.DomesticLocation, log.GlobalLocation,
+	}
+	ids := make([]any, len(values))
+	for i, value := range values {
+		id, err := w.id(value)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func (w *dictionaryWriter) Close() {
+	w.lookup.Close()
+	w.insert.Close()
+}
+
+// pruneDictionary 删除日志清理后不再被引用的字符串
+func (r *Repository) pruneDictionary(websiteID string) error {
+	references := make([]string, len(dictionaryColumns))
+	for i, column := range dictionaryColumns {
+		references[i] = fmt.Sprintf(`SELECT %s_id FROM "%s_nginx_logs"`, column, websiteID)
+	}
+	// 跨越清理时间的访问仍引用已删除日志的着陆页
+	references = append(references,
+		fmt.Sprintf(`SELECT entry_url_id FROM "%s_sessions"`, websiteID),
+		fmt.Sprintf(`SELECT exit_url_id FROM "%s_sessions"`, websiteID),
+		fmt.Sprintf(`SELECT value_id FROM "%s_hourly_dimensions"`, websiteID),
+		// 旧版本导入的日志没有保存原始 User-Agent，NULL 会使 NOT IN 不成立
+		fmt.Sprintf(`SELECT user_agent_id FROM "%s_nginx_logs" WHERE user_agent_id IS NOT NULL`, websiteID))
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	_, err := r.db.Exec(fmt.Sprintf(`DELETE FROM "%s_dictionary" WHERE id NOT IN (%s)`,
+		websiteID, strings.Join(references, " UNION ")))
+	return err
+}
+
+// convertToDictionary 将旧版直接保存字符串的日志表转换为字典编码，在同一事务中完成
+func (r *Repository) convertToDictionary(websiteID string) error {
+	table := websiteID + "_nginx_logs"
+	converted, err := r.hasColumn(table, "url_id")
+	if err != nil {
+		return err
+	}
+	if !converted {
+		if err := r.createDictionaryTable(websiteID); err != nil {
+			return err
+		}
+		if err := r.rewriteWithDictionary(websiteID); err != nil {
+			return err
+		}
+	}
+
+	if err := r.createLogsIndexes(websiteID); err != nil {
+		return err
+	}
+	return r.createLogsView(websiteID)
+}
+
+// rewriteWithDictionary 将字符串写入字典表，并按新结构重建日志表
+func (r *Repository) rewriteWithDictionary(websiteID string) error {
+	table := websiteID + "_nginx_logs"
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	values := make([]string, len(dictionaryColumns))
+	lookups := make([]string, len(dictionaryColumns))
+	for i, column := range dictionaryColumns {
+		values[i] = fmt.Sprintf(`SELECT %s FROM "%s"`, column, table)
+		lookups[i] = fmt.Sprintf(`(SELECT id FROM "%s_dictionary" WHERE value = l.%s)`, websiteID, column)
+	}
+	statements := []string{
+		fmt.Sprintf(`INSERT OR IGNORE INTO "%s_dictionary" (value) %s`, websiteID, strings.Join(values, " UNION ")),
+		fmt.Sprintf(`CREATE TABLE "%s_converting" (%s)`, table, r.logsTableColumns()),
+		fmt.Sprintf(`
+            INSERT INTO "%[1]s_converting" (id, ip, pageview_flag, timestamp, method, status_code, bytes_sent,
+                %[2]s_id, extra, request_time, upstream_response_time)
+            SELECT l.id, l.ip, l.pageview_flag, l.timestamp, l.method, l.status_code, l.bytes_sent,
+                %[3]s, l.extra, l.request_time, l.upstream_response_time
+            FROM "%[1]s" l`,
+			table, strings.Join(dictionaryColumns, "_id, "), strings.Join(lookups, ", ")),
+		fmt.Sprintf(`DROP TABLE "%s"`, table),
+		fmt.Sprintf(`ALTER TABLE "%[1]s_converting" RENAME TO "%[1]s"`, table),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
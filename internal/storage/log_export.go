@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// exportBatchSize 导出原始日志时每次读取的行数
+const exportBatchSize = 5000
+
+// ScanLogs 按写入顺序读取 [start, end) 范围内的原始日志，逐条交给 fn 处理
+//
+// 日志分批读取，不会一次载入内存，也不会长时间占用读事务；end 为零时不限制结束时间。
+// fn 返回错误时停止读取并返回该错误。
+func (r *Repository) ScanLogs(websiteID string, start, end time.Time, fn func(*NginxLogRecord) error) error {
+	endUnix := int64(math.MaxInt64)
+	if !end.IsZero() {
+		endUnix = end.Unix()
+	}
+
+	// 先通过时间索引确定 ID 范围，之后按主键分批读取
+	var minID, maxID sql.NullInt64
+	if err := r.db.QueryRow(fmt.Sprintf(`
+        SELECT MIN(id), MAX(id) FROM "%s_nginx_logs"
+        WHERE timestamp >= ? AND timestamp < ?`, websiteID),
+		start.Unix(), endUnix).Scan(&minID, &maxID); err != nil {
+		return fmt.Errorf("查询日志范围失败: %v", err)
+	}
+	if !minID.Valid {
+		return nil
+	}
+
+	lastID := minID.Int64 - 1
+	for lastID < maxID.Int64 {
+		logs, err := r.readLogBatch(websiteID, start.Unix(), endUnix, lastID, maxID.Int64)
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		for i := range logs {
+			if err := fn(&logs[i]); err != nil {
+				return err
+			}
+		}
+		lastID = logs[len(logs)-1].ID
+	}
+	return nil
+}
+
+// readLogBatch 读取 ID 在 (afterID, maxID] 之间、时间在范围内的一批日志
+func (r *Repository) readLogBatch(websiteID string, start, end, afterID, maxID int64) ([]NginxLogRecord, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT %s
+        FROM "%s"
+        WHERE id > ? AND id <= ? AND timestamp >= ? AND timestamp < ?
+        ORDER BY id LIMIT ?`, logRecordColumns, LogsView(websiteID)),
+		afterID, maxID, start, end, exportBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("读取日志失败: %v", err)
+	}
+	defer rows.Close()
+
+	logs := make([]NginxLogRecord, 0, exportBatchSize)
+	for rows.Next() {
+		log, err := scanLogRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
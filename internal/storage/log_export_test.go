@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScanLogsReadsRangeInBatches(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	base := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	requestTime := 0.25
+	logs := make([]NginxLogRecord, exportBatchSize+1000)
+	for i := range logs {
+		logs[i] = NginxLogRecord{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: base.Add(time.Duration(i) * time.Second),
+			Url: fmt.Sprintf("/%d", i%10), Status: 200, BytesSent: i}
+	}
+	logs[100].RequestTime = &requestTime
+	logs[100].Extra = map[string]string{"host": "example.org"}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	var scanned []NginxLogRecord
+	err := repo.ScanLogs("site", base.Add(100*time.Second), base.Add(time.Duration(exportBatchSize+500)*time.Second),
+		func(log *NginxLogRecord) error {
+			scanned = append(scanned, *log)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("scan logs: %v", err)
+	}
+	if len(scanned) != exportBatchSize+400 {
+		t.Fatalf("expected %d logs, got %d", exportBatchSize+400, len(scanned))
+	}
+	first := scanned[0]
+	if !first.Timestamp.Equal(base.Add(100*time.Second)) || first.Url != "/0" || first.BytesSent != 100 ||
+		first.RequestTime == nil || *first.RequestTime != requestTime || first.Extra["host"] != "example.org" {
+		t.Fatalf("unexpected first log: %+v", first)
+	}
+	for i := 1; i < len(scanned); i++ {
+		if scanned[i].ID <= scanned[i-1].ID {
+			t.Fatalf("logs out of order at %d", i)
+		}
+	}
+
+	if err := repo.ScanLogs("site", base.Add(-time.Hour), base, func(*NginxLogRecord) error {
+		t.Fatal("no logs expected before the range")
+		return nil
+	}); err != nil {
+		t.Fatalf("scan empty range: %v", err)
+	}
+}
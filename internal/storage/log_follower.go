@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// followDelay 收到文件变化后等待的时间，合并短时间内的多次写入
+const followDelay = 500 * time.Millisecond
+
+// LogFollower 监听日志文件所在目录，文件写入后立即增量解析
+type LogFollower struct {
+	parser   *LogParser
+	watcher  *fsnotify.Watcher
+	onUpdate func(websiteID string) // 网站写入了新日志后回调
+}
+
+// NewLogFollower 创建日志实时跟踪器并监听所有网站的日志目录
+func NewLogFollower(
+	parser *LogParser, onUpdate func(websiteID string)) (*LogFollower, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	watchedDirs := make(map[string]bool)
+	for _, id := range util.GetAllWebsiteIDs() {
+		website, _ := util.GetWebsiteByID(id)
+		dirs := []string{filepath.Dir(website.LogPath)}
+		if strings.Contains(dirs[0], "*") {
+			dirs, _ = filepath.Glob(dirs[0])
+		}
+
+		for _, dir := range dirs {
+			if watchedDirs[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				watcher.Close()
+				return nil, err
+			}
+			watchedDirs[dir] = true
+		}
+	}
+
+	parser.mu.Lock()
+	parser.following = true
+	parser.mu.Unlock()
+
+	return &LogFollower{
+		parser:   parser,
+		watcher:  watcher,
+		onUpdate: onUpdate,
+	}, nil
+}
+
+// Run 处理文件变化事件，直到上下文取消
+func (f *LogFollower) Run(ctx context.Context) {
+	defer f.watcher.Close()
+
+	pending := make(map[string]bool)
+	var flush <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-f.watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			for _, id := range websitesForPath(event.Name) {
+				pending[id] = true
+			}
+			if len(pending) > 0 && flush == nil {
+				flush = time.After(followDelay)
+			}
+
+		case err, ok := <-f.watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.WithError(err).Warn("监听日志文件变化失败")
+
+		case <-flush:
+			flush = nil
+			ids := make([]string, 0, len(pending))
+			for id := range pending {
+				ids = append(ids, id)
+			}
+			for _, result := range f.parser.ScanWebsites(ids) {
+				if !result.Success {
+					logrus.Warnf("网站 %s (%s) 实时扫描失败: %s",
+						result.WebName, result.WebID, result.Error)
+				}
+				if result.TotalEntries > 0 && f.onUpdate != nil {
+					f.onUpdate(result.WebID)
+				}
+			}
+			clear(pending)
+		}
+	}
+}
+
+// websitesForPath 返回日志路径与该文件匹配的网站
+func websitesForPath(filePath string) []string {
+	var ids []string
+	for _, id := range util.GetAllWebsiteIDs() {
+		website, _ := util.GetWebsiteByID(id)
+		logPath := filepath.Clean(website.LogPath)
+
+		matched := false
+		if strings.Contains(logPath, "*") {
+			matched, _ = filepath.Match(logPath, filepath.Clean(filePath))
+		} else {
+			// 包含轮转后仍在写入的旧文件，如 access.log.1
+			matched = strings.HasPrefix(filepath.Clean(filePath), logPath)
+		}
+		if matched {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/beyondxinxin/nixvis/internal/netparser"
+)
+
+// DefaultNginxLogFormat Nginx 内置的 combined 日志格式
+const DefaultNginxLogFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+var (
+	logFormatVariablePattern = regexp.MustCompile(`\$(?:\{([A-Za-z0-9_]+)\}|([A-Za-z0-9_]+))`)
+	defaultNginxLogFormat    = mustCompileNginxLogFormat(DefaultNginxLogFormat)
+
+	errLogFormatMismatch = errors.New("日志格式不匹配")
+)
+
+// knownLogVariables 可以映射到 NginxLogRecord 字段的变量，其余变量写入 Extra
+var knownLogVariables = map[string]bool{
+	"remote_addr":            true,
+	"remote_user":            true,
+	"time_local":             true,
+	"time_iso8601":           true,
+	"msec":                   true,
+	"request":                true,
+	"request_method":         true,
+	"request_uri":            true,
+	"uri":                    true,
+	"args":                   true,
+	"server_protocol":        true,
+	"status":                 true,
+	"body_bytes_sent":        true,
+	"bytes_sent":             true,
+	"http_referer":           true,
+	"http_user_agent":        true,
+	"request_time":           true,
+	"upstream_response_time": true,
+}
+
+// regexLogFormat 由日志格式字符串编译得到的正则解析器
+type regexLogFormat struct {
+	pattern   *regexp.Regexp
+	variables []string
+}
+
+// logFormatToken 日志格式中的一段：字面文本或变量
+type logFormatToken struct {
+	literal  string
+	variable string
+	pattern  string // 变量的自定义匹配模式，须包含且只包含一个捕获组
+}
+
+// compileNginxLogFormat 将 Nginx log_format 字符串编译为解析器
+func compileNginxLogFormat(format string) (*regexLogFormat, error) {
+	format = strings.TrimSpace(strings.Trim(strings.TrimSpace(format), "'"))
+	if format == "" {
+		return nil, errors.New("log_format 不能为空")
+	}
+
+	locations := logFormatVariablePattern.FindAllStringSubmatchIndex(format, -1)
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("log_format %q 不包含任何变量", format)
+	}
+
+	tokens := make([]logFormatToken, 0, len(locations)*2+1)
+	last := 0
+	for _, loc := range locations {
+		if loc[0] > last {
+			tokens = append(tokens, logFormatToken{literal: format[last:loc[0]]})
+		}
+
+		name := ""
+		if loc[2] >= 0 {
+			name = format[loc[2]:loc[3]]
+		} else {
+			name = format[loc[4]:loc[5]]
+		}
+		tokens = append(tokens, logFormatToken{variable: name})
+		last = loc[1]
+	}
+	if last < len(format) {
+		tokens = append(tokens, logFormatToken{literal: format[last:]})
+	}
+
+	return compileLogFormatTokens(format, tokens)
+}
+
+// compileLogFormatTokens 将格式片段编译为正则解析器
+//
+// 每个变量匹配到下一个字面字符为止，例如 "$request" 匹配引号内的全部内容，
+// [$time_local] 匹配方括号内的全部内容；行尾多出的内容会被忽略。
+func compileLogFormatTokens(format string, tokens []logFormatToken) (*regexLogFormat, error) {
+	var builder strings.Builder
+	builder.WriteString("^")
+	variables := make([]string, 0, len(tokens))
+	seen := make(map[string]bool, len(tokens))
+
+	for i, token := range tokens {
+		if token.variable == "" {
+			builder.WriteString(regexp.QuoteMeta(token.literal))
+			continue
+		}
+
+		if seen[token.variable] {
+			return nil, fmt.Errorf("日志格式 %q 中变量 %s 重复出现", format, token.variable)
+		}
+		seen[token.variable] = true
+		variables = append(variables, token.variable)
+
+		switch {
+		case token.pattern != "":
+			builder.WriteString(token.pattern)
+		case i+1 == len(tokens):
+			builder.WriteString("(.*)")
+		case tokens[i+1].variable != "":
+			return nil, fmt.Errorf("日志格式 %q 中的变量之间缺少分隔符", format)
+		default:
+			delimiter, _ := utf8.DecodeRuneInString(tokens[i+1].literal)
+			builder.WriteString("([^" + regexp.QuoteMeta(string(delimiter)) + "]*)")
+		}
+	}
+
+	pattern, err := regexp.Compile(builder.String())
+	if err != nil {
+		return nil, fmt.Errorf("编译日志格式 %q 失败: %w", format, err)
+	}
+
+	return &regexLogFormat{
+		pattern:   pattern,
+		variables: variables,
+	}, nil
+}
+
+func mustCompileNginxLogFormat(format string) *regexLogFormat {
+	compiled, err := compileNginxLogFormat(format)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// ParseLine 按变量名提取单行日志中的字段
+func (f *regexLogFormat) ParseLine(line string) (map[string]string, error) {
+	matches := f.pattern.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, errLogFormatMismatch
+	}
+
+	fields := make(map[string]string, len(f.variables))
+	for i, name := range f.variables {
+		fields[name] = matches[i+1]
+	}
+	return fields, nil
+}
+
+// buildLogRecord 将提取出的字段映射为日志记录
+func buildLogRecord(fields map[string]string, cutoffTime time.Time) (*NginxLogRecord, error) {
+	ip := fields["remote_addr"]
+	if ip == "" {
+		return nil, errors.New("日志缺少客户端地址")
+	}
+
+	timestamp, err := parseLogTimestamp(fields)
+	if err != nil {
+		return nil, err
+	}
+	if !cutoffTime.IsZero() && timestamp.Before(cutoffTime) {
+		return nil, errors.New("日志超过保留期限")
+	}
+
+	method, rawPath, err := parseLogRequest(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedPath, err := url.QueryUnescape(rawPath)
+	if err != nil {
+		decodedPath = rawPath
+	}
+	statusCode, err := strconv.Atoi(fields["status"])
+	if err != nil {
+		return nil, fmt.Errorf("无效的状态码 %q", fields["status"])
+	}
+	bytesSent := parseLogBytes(fields)
+	requestTime := parseLogDuration(fields["request_time"])
+	upstreamResponseTime := parseLogDuration(fields["upstream_response_time"])
+	referPath, err := url.QueryUnescape(fields["http_referer"])
+	if err != nil {
+		referPath = fields["http_referer"]
+	}
+
+	pageviewFlag := netparser.ShouldCountAsPageView(statusCode, decodedPath, ip)
+	domesticLocation, globalLocation, _ := netparser.GetIPLocation(ip)
+	browser, os, device := netparser.ParseUserAgent(fields["http_user_agent"])
+
+	return &NginxLogRecord{
+		ID:                   0,
+		IP:                   ip,
+		PageviewFlag:         pageviewFlag,
+		Timestamp:            timestamp,
+		Method:               method,
+		Url:                  decodedPath,
+		Status:               statusCode,
+		BytesSent:            bytesSent,
+		Referer:              referPath,
+		UserBrowser:          browser,
+		UserOs:               os,
+		UserDevice:           device,
+		UserAgent:            fields["http_user_agent"],
+		DomesticLocation:     domesticLocation,
+		GlobalLocation:       globalLocation,
+		RequestTime:          requestTime,
+		UpstreamResponseTime: upstreamResponseTime,
+		Extra:                extraLogFields(fields),
+	}, nil
+}
+
+// parseLogTimestamp 依次尝试 $time_local、$time_iso8601 和 $msec
+func parseLogTimestamp(fields map[string]string) (time.Time, error) {
+	if value, ok := fields["time_local"]; ok {
+		return time.Parse("02/Jan/2006:15:04:05 -0700", value)
+	}
+	if value, ok := fields["time_iso8601"]; ok {
+		return time.Parse(time.RFC3339, value)
+	}
+	if value, ok := fields["msec"]; ok {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("无效的时间戳 %q", value)
+		}
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Time{}, errors.New("日志缺少时间字段")
+}
+
+// parseLogRequest 从 $request 或 $request_method + $request_uri 中解析方法和路径
+func parseLogRequest(fields map[string]string) (string, string, error) {
+	if request, ok := fields["request"]; ok {
+		firstSpace := strings.IndexByte(request, ' ')
+		lastSpace := strings.LastIndexByte(request, ' ')
+		if firstSpace <= 0 || lastSpace <= firstSpace ||
+			!strings.HasPrefix(request[lastSpace+1:], "HTTP/") {
+			return "", "", fmt.Errorf("无效的请求行 %q", request)
+		}
+		return request[:firstSpace], request[firstSpace+1 : lastSpace], nil
+	}
+
+	method := fields["request_method"]
+	path, ok := fields["request_uri"]
+	if !ok {
+		path, ok = fields["uri"]
+		if args := fields["args"]; ok && args != "" && args != "-" {
+			path += "?" + args
+		}
+	}
+	if method == "" || !ok || path == "" {
+		return "", "", errors.New("日志缺少请求方法或路径")
+	}
+	return method, path, nil
+}
+
+// parseLogBytes 优先使用 $body_bytes_sent，其次 $bytes_sent
+func parseLogBytes(fields map[string]string) int {
+	value, ok := fields["body_bytes_sent"]
+	if !ok {
+		value = fields["bytes_sent"]
+	}
+	bytesSent, _ := strconv.Atoi(value)
+	return bytesSent
+}
+
+// parseLogDuration 解析以秒为单位的耗时，无法解析或为 "-" 时返回 nil
+//
+// 请求经过多个上游时 $upstream_response_time 形如 "0.010, 0.020 : 0.005"，返回各段之和。
+func parseLogDuration(value string) *float64 {
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ':' || r == ' '
+	})
+
+	total, found := 0.0, false
+	for _, part := range parts {
+		seconds, err := strconv.ParseFloat(part, 64)
+		if err != nil || seconds < 0 {
+			continue
+		}
+		total += seconds
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return &total
+}
+
+// extraLogFields 收集未映射到记录字段的变量，忽略空值和 "-"
+func extraLogFields(fields map[string]string) map[string]string {
+	var extra map[string]string
+	for name, value := range fields {
+		if knownLogVariables[name] || value == "" || value == "-" {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[name] = value
+	}
+	return extra
+}
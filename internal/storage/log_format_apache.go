@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ApacheCommonLogFormat Apache httpd 的 Common Log Format
+	ApacheCommonLogFormat = `%h %l %u %t "%r" %>s %b`
+	// ApacheCombinedLogFormat Apache httpd 的 combined 格式
+	ApacheCombinedLogFormat = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-Agent}i"`
+	// ApacheCombinedDurationLogFormat 在 combined 格式末尾追加请求耗时（微秒）
+	ApacheCombinedDurationLogFormat = ApacheCombinedLogFormat + ` %D`
+)
+
+// apacheDirectiveVariables Apache LogFormat 指令与 Nginx 变量名的对应关系
+var apacheDirectiveVariables = map[byte]string{
+	'a': "remote_addr",
+	'h': "remote_addr",
+	'l': "remote_ident",
+	'u': "remote_user",
+	'r': "request",
+	's': "status",
+	'b': "body_bytes_sent",
+	'B': "body_bytes_sent",
+	'O': "bytes_sent",
+	'I': "request_length",
+	'D': "request_time_us",
+	'T': "request_time_s",
+	'm': "request_method",
+	'U': "uri",
+	'q': "args",
+	'H': "server_protocol",
+	'v': "host",
+	'V': "host",
+	'p': "server_port",
+	'X': "connection_status",
+}
+
+// apacheLogFormat Apache httpd 日志解析器，按顺序尝试多个格式
+type apacheLogFormat struct {
+	formats []*regexLogFormat
+}
+
+// compileApacheLogFormats 编译一个或多个 Apache LogFormat 字符串
+func compileApacheLogFormats(formats ...string) (*apacheLogFormat, error) {
+	parser := &apacheLogFormat{}
+	for _, format := range formats {
+		compiled, err := compileApacheLogFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		parser.formats = append(parser.formats, compiled)
+	}
+	return parser, nil
+}
+
+// compileApacheLogFormat 将 Apache LogFormat 字符串编译为正则解析器
+func compileApacheLogFormat(format string) (*regexLogFormat, error) {
+	format = strings.TrimSpace(format)
+	if format == "" {
+		return nil, fmt.Errorf("LogFormat 不能为空")
+	}
+
+	var tokens []logFormatToken
+	var literal strings.Builder
+	flushLiteral := func() {
+		if literal.Len() > 0 {
+			tokens = append(tokens, logFormatToken{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			literal.WriteByte('%')
+			i++
+			continue
+		}
+
+		// 跳过修饰符，如 %>s、%400,501{User-agent}i
+		j := i + 1
+		for j < len(format) && strings.IndexByte("<>!,0123456789", format[j]) >= 0 {
+			j++
+		}
+		param := ""
+		if j < len(format) && format[j] == '{' {
+			end := strings.IndexByte(format[j:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("LogFormat %q 中的 %%{ 缺少 }", format)
+			}
+			param = format[j+1 : j+end]
+			j += end + 1
+		}
+		if j >= len(format) {
+			return nil, fmt.Errorf("LogFormat %q 以不完整的指令结尾", format)
+		}
+
+		directive := format[j]
+		token, err := apacheDirectiveToken(directive, param)
+		if err != nil {
+			return nil, fmt.Errorf("LogFormat %q: %w", format, err)
+		}
+		flushLiteral()
+		tokens = append(tokens, token)
+		i = j
+	}
+	flushLiteral()
+
+	return compileLogFormatTokens(format, tokens)
+}
+
+// apacheDirectiveToken 将单个 Apache 指令转换为格式片段
+func apacheDirectiveToken(directive byte, param string) (logFormatToken, error) {
+	switch directive {
+	case 't':
+		if param != "" {
+			return logFormatToken{}, fmt.Errorf("不支持自定义时间格式 %%{%s}t", param)
+		}
+		// %t 输出带方括号的时间，如 [10/Oct/2000:13:55:36 -0700]
+		return logFormatToken{variable: "time_local", pattern: `\[([^\]]*)\]`}, nil
+	case 'i':
+		return logFormatToken{variable: "http_" + headerVariableName(param)}, nil
+	case 'o':
+		return logFormatToken{variable: "sent_http_" + headerVariableName(param)}, nil
+	case 'C':
+		return logFormatToken{variable: "cookie_" + headerVariableName(param)}, nil
+	case 'e':
+		return logFormatToken{variable: "env_" + headerVariableName(param)}, nil
+	case 'n':
+		return logFormatToken{variable: "note_" + headerVariableName(param)}, nil
+	case 'T':
+		if param == "ms" {
+			return logFormatToken{variable: "request_time_ms"}, nil
+		}
+		if param == "us" {
+			return logFormatToken{variable: "request_time_us"}, nil
+		}
+	}
+
+	name, ok := apacheDirectiveVariables[directive]
+	if !ok {
+		return logFormatToken{}, fmt.Errorf("不支持的指令 %%%c", directive)
+	}
+	return logFormatToken{variable: name}, nil
+}
+
+// headerVariableName 按 Nginx 的规则将请求头名转换为变量名，如 User-Agent -> user_agent
+func headerVariableName(header string) string {
+	return strings.ReplaceAll(strings.ToLower(header), "-", "_")
+}
+
+// ParseLine 依次尝试各个格式解析日志行，并统一字段的表示方式
+func (f *apacheLogFormat) ParseLine(line string) (map[string]string, error) {
+	for _, format := range f.formats {
+		fields, err := format.ParseLine(line)
+		if err != nil {
+			continue
+		}
+
+		// %q 输出的查询字符串带有前导 "?"
+		if args, ok := fields["args"]; ok {
+			fields["args"] = strings.TrimPrefix(args, "?")
+		}
+		normalizeRequestTime(fields)
+		return fields, nil
+	}
+	return nil, errLogFormatMismatch
+}
+
+// normalizeRequestTime 将以纳秒、微秒、毫秒或整秒记录的耗时换算为秒，
+// 写入 request_time 和 upstream_response_time
+func normalizeRequestTime(fields map[string]string) {
+	units := []struct {
+		suffix  string
+		divisor float64
+	}{
+		{"_ns", 1e9},
+		{"_us", 1e6},
+		{"_ms", 1e3},
+		{"_s", 1},
+	}
+
+	for _, name := range []string{"request_time", "upstream_response_time"} {
+		for _, unit := range units {
+			value, ok := fields[name+unit.suffix]
+			if !ok {
+				continue
+			}
+			delete(fields, name+unit.suffix)
+
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if _, exists := fields[name]; !exists {
+				fields[name] = strconv.FormatFloat(number/unit.divisor, 'f', 6, 64)
+			}
+		}
+	}
+}
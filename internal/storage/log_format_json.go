@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonFieldPresets 常见 JSON 访问日志的字段映射，键为 Nginx 变量名，值为 JSON 字段路径
+//
+// 路径使用 "." 访问嵌套对象，使用 "|" 分隔多个候选路径。
+var jsonFieldPresets = map[string]map[string]string{
+	// Nginx log_format escape=json，字段名与变量名一致
+	"json": {},
+	// Caddy 默认的 JSON 访问日志
+	"caddy": {
+		"remote_addr":     "request.client_ip|request.remote_ip",
+		"msec":            "ts",
+		"request_method":  "request.method",
+		"request_uri":     "request.uri",
+		"server_protocol": "request.proto",
+		"status":          "status",
+		"body_bytes_sent": "size",
+		"http_referer":    "request.headers.Referer",
+		"http_user_agent": "request.headers.User-Agent",
+		"host":            "request.host",
+		"request_time":    "duration",
+	},
+	// Traefik JSON 格式的访问日志
+	"traefik": {
+		"remote_addr":     "ClientHost",
+		"time_iso8601":    "StartUTC|time",
+		"request_method":  "RequestMethod",
+		"request_uri":     "RequestPath",
+		"server_protocol": "RequestProtocol",
+		"status":          "DownstreamStatus",
+		"body_bytes_sent": "DownstreamContentSize",
+		"http_referer":    "request_Referer",
+		"http_user_agent": "request_User-Agent",
+		"host":            "RequestHost",
+		// Traefik 的耗时以纳秒记录
+		"request_time_ns":           "Duration",
+		"upstream_response_time_ns": "OriginDuration",
+	},
+}
+
+// jsonLogFormat JSON 访问日志的字段提取器
+type jsonLogFormat struct {
+	fields      map[string][]string // Nginx 变量名 -> 候选 JSON 字段路径
+	mappedKeys  map[string]bool     // 已被映射使用的 JSON 字段
+	passThrough bool                // 是否将未映射的顶层字段按原名保留
+}
+
+// compileJSONLogFormat 根据预设和自定义映射创建 JSON 字段提取器
+func compileJSONLogFormat(preset string, custom map[string]string) (*jsonLogFormat, error) {
+	presetFields, ok := jsonFieldPresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("不支持的 JSON 日志格式 %q", preset)
+	}
+
+	format := &jsonLogFormat{
+		fields:      make(map[string][]string),
+		passThrough: preset == "json",
+	}
+	for name, path := range presetFields {
+		format.fields[name] = strings.Split(path, "|")
+	}
+	for name, path := range custom {
+		if strings.TrimSpace(path) == "" {
+			delete(format.fields, name)
+			continue
+		}
+		format.fields[name] = strings.Split(path, "|")
+	}
+
+	format.mappedKeys = make(map[string]bool)
+	for _, paths := range format.fields {
+		for _, path := range paths {
+			format.mappedKeys[path] = true
+		}
+	}
+	return format, nil
+}
+
+// ParseLine 解析 JSON 日志行并按映射提取字段
+func (f *jsonLogFormat) ParseLine(line string) (map[string]string, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return nil, errLogFormatMismatch
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var document map[string]any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("解析 JSON 日志失败: %w", err)
+	}
+
+	fields := make(map[string]string, len(f.fields))
+	if f.passThrough {
+		for key, value := range document {
+			if f.mappedKeys[key] {
+				continue
+			}
+			if text, ok := jsonScalarString(value); ok {
+				fields[key] = text
+			}
+		}
+	}
+
+	for name, paths := range f.fields {
+		for _, path := range paths {
+			if value, ok := lookupJSONPath(document, path); ok {
+				if text, ok := jsonScalarString(value); ok {
+					fields[name] = text
+					break
+				}
+			}
+		}
+	}
+	normalizeRequestTime(fields)
+	return fields, nil
+}
+
+// lookupJSONPath 按 "." 分隔的路径查找字段，优先匹配包含 "." 的完整键名
+func lookupJSONPath(document map[string]any, path string) (any, bool) {
+	if value, ok := document[path]; ok {
+		return value, true
+	}
+
+	head, rest, found := strings.Cut(path, ".")
+	if !found {
+		return nil, false
+	}
+	child, ok := document[head].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return lookupJSONPath(child, rest)
+}
+
+// jsonScalarString 将 JSON 标量转换为字符串，数组取第一个元素（如 Caddy 的请求头）
+func jsonScalarString(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "false", true
+	case []any:
+		if len(v) == 0 {
+			return "", true
+		}
+		return jsonScalarString(v[0])
+	case nil:
+		return "", true
+	}
+
+	// 嵌套对象原样保留为 JSON 文本
+	var buffer bytes.Buffer
+	if err := json.NewEncoder(&buffer).Encode(value); err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(buffer.String()), true
+}
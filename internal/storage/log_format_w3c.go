@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+// w3cFieldVariables W3C 扩展日志字段与 Nginx 变量名的对应关系
+var w3cFieldVariables = map[string]string{
+	"c-ip":           "remote_addr",
+	"cs-username":    "remote_user",
+	"cs-method":      "request_method",
+	"cs-uri-stem":    "uri",
+	"cs-uri-query":   "args",
+	"cs-version":     "server_protocol",
+	"sc-status":      "status",
+	"sc-bytes":       "bytes_sent",
+	"cs-bytes":       "request_length",
+	"time-taken":     "request_time_ms",
+	"cs(user-agent)": "http_user_agent",
+	"cs(referer)":    "http_referer",
+	"cs-host":        "host",
+	"cs(host)":       "host",
+}
+
+// errLogDirective 日志中的指令或注释行，不计入跳过的记录
+var errLogDirective = errors.New("日志指令行")
+
+// w3cLogParser IIS 使用的 W3C 扩展日志解析器，字段顺序由 #Fields 指令决定
+type w3cLogParser struct {
+	fields []string
+}
+
+func newW3CLogParser() *w3cLogParser {
+	return &w3cLogParser{}
+}
+
+// NeedsHeader 从文件中间开始读取时需要先读取之前的 #Fields 指令
+func (p *w3cLogParser) NeedsHeader() bool {
+	return true
+}
+
+// Header 返回当前生效的 #Fields 指令
+func (p *w3cLogParser) Header() []string {
+	if len(p.fields) == 0 {
+		return nil
+	}
+	return []string{"#Fields: " + strings.Join(p.fields, " ")}
+}
+
+// ParseLine 解析 W3C 日志行，# 开头的指令行用于更新字段列表
+func (p *w3cLogParser) ParseLine(line string) (map[string]string, error) {
+	line = strings.TrimRight(line, "\r")
+	if strings.HasPrefix(line, "#") {
+		if directive, ok := strings.CutPrefix(line, "#Fields:"); ok {
+			p.fields = strings.Fields(directive)
+		}
+		return nil, errLogDirective
+	}
+	if len(p.fields) == 0 {
+		return nil, errors.New("W3C 日志缺少 #Fields 指令")
+	}
+
+	values := strings.Fields(line)
+	if len(values) != len(p.fields) {
+		return nil, errLogFormatMismatch
+	}
+
+	fields := make(map[string]string, len(values))
+	date, clock := "", ""
+	for i, name := range p.fields {
+		value := values[i]
+		if value == "-" {
+			value = ""
+		}
+
+		switch key := strings.ToLower(name); key {
+		case "date":
+			date = value
+		case "time":
+			clock = value
+		case "cs(user-agent)", "cs(referer)":
+			// W3C 日志用 + 代替字段中的空格
+			fields[w3cFieldVariables[key]] = strings.ReplaceAll(value, "+", " ")
+		default:
+			if variable, ok := w3cFieldVariables[key]; ok {
+				fields[variable] = value
+			} else {
+				fields[w3cExtraName(key)] = value
+			}
+		}
+	}
+
+	// W3C 日志的时间均为 UTC
+	if date != "" && clock != "" {
+		fields["time_iso8601"] = date + "T" + clock + "Z"
+	}
+	normalizeRequestTime(fields)
+	return fields, nil
+}
+
+// w3cExtraName 将未映射的 W3C 字段名转换为变量名，如 s-sitename -> s_sitename
+func w3cExtraName(field string) string {
+	replacer := strings.NewReplacer("-", "_", "(", "_", ")", "")
+	return replacer.Replace(field)
+}
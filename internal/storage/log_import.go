@@ -0,0 +1,494 @@
+package storage
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ImportOptions 历史日志导入的参数
+type ImportOptions struct {
+	From     time.Time            // 只导入该时间及之后的日志，为零时不限制
+	To       time.Time            // 只导入该时间之前的日志，为零时使用网站的导入边界
+	Progress func(ImportProgress) // 导入进度回调，可为空
+}
+
+// ImportProgress 单个文件的导入进度
+type ImportProgress struct {
+	Path       string
+	FileIndex  int   // 当前文件序号，从 1 开始
+	FileCount  int   // 文件总数
+	ReadBytes  int64 // 已读取的字节数（压缩文件为压缩后的字节数）
+	TotalBytes int64
+	Entries    int  // 已导入的记录数
+	Skipped    int  // 跳过的行数（格式不匹配、不在时间范围内或已导入过）
+	Done       bool // 当前文件是否已处理完
+}
+
+// ImportResult 历史日志导入的结果
+type ImportResult struct {
+	Files        int // 处理的文件数
+	SkippedFiles int // 指定范围已全部导入过而跳过的文件数
+	Entries      int
+	Skipped      int
+	From         time.Time
+	To           time.Time
+}
+
+// importRange 已导入的时间范围 [From, To)，单位为秒
+type importRange struct {
+	From int64
+	To   int64
+}
+
+// ImportFiles 导入历史日志文件或目录到指定网站，不受增量扫描保留期限的限制
+//
+// 每个文件按内容哈希记录已导入的时间范围，重复执行不会重复导入。未指定结束时间时，
+// 只导入早于首次导入时数据库中已有最早记录的日志，避免与增量扫描的数据重叠。
+func (p *LogParser) ImportFiles(
+	websiteID string, paths []string, options ImportOptions) (ImportResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := ImportResult{}
+	files, err := collectImportFiles(paths)
+	if err != nil {
+		return result, err
+	}
+	if len(files) == 0 {
+		return result, errors.New("没有找到可导入的日志文件")
+	}
+
+	requested := importRange{From: math.MinInt64, To: math.MaxInt64}
+	if !options.From.IsZero() {
+		requested.From = options.From.Unix()
+	}
+	if !options.To.IsZero() {
+		requested.To = options.To.Unix()
+	} else {
+		boundary, err := p.repo.importBoundary(websiteID)
+		if err != nil {
+			return result, fmt.Errorf("获取网站导入边界失败: %w", err)
+		}
+		requested.To = boundary
+	}
+	if requested.From >= requested.To {
+		return result, fmt.Errorf("导入的时间范围为空")
+	}
+	if requested.From != math.MinInt64 {
+		result.From = time.Unix(requested.From, 0)
+	}
+	result.To = time.Unix(requested.To, 0)
+
+	for i, filePath := range files {
+		progress := ImportProgress{Path: filePath, FileIndex: i + 1, FileCount: len(files)}
+		imported, err := p.importFile(websiteID, filePath, requested, &progress, options.Progress)
+		if err != nil {
+			return result, fmt.Errorf("导入日志文件 %s 失败: %w", filePath, err)
+		}
+
+		result.Files++
+		if !imported {
+			result.SkippedFiles++
+		}
+		result.Entries += progress.Entries
+		result.Skipped += progress.Skipped
+	}
+
+	logrus.Infof("网站 %s 导入历史日志完成，%d 个文件共导入 %d 条记录",
+		websiteID, result.Files, result.Entries)
+	return result, nil
+}
+
+// collectImportFiles 展开目录，返回按路径排序的日志文件列表，跳过隐藏文件
+func collectImportFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != root && strings.HasPrefix(entry.Name(), ".") {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// importFile 导入单个文件中尚未导入的时间范围，范围已全部导入过时返回 false
+//
+// 上次导入该文件时中断的，先按当时的时间范围从中断处继续，再导入本次请求中尚未导入的部分。
+func (p *LogParser) importFile(websiteID string, filePath string, requested importRange,
+	progress *ImportProgress, report func(ImportProgress)) (bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	progress.TotalBytes = fileInfo.Size()
+
+	contentHash, err := hashFileContent(file)
+	if err != nil {
+		return false, err
+	}
+
+	resumed := false
+	checkpoint, err := p.repo.importCheckpoint(websiteID, contentHash)
+	if err != nil {
+		return false, err
+	}
+	if checkpoint != nil {
+		logrus.Infof("日志文件 %s 上次导入中断，从第 %d 行继续", filePath, checkpoint.Lines+1)
+		if err := p.importContent(websiteID, file, filePath, contentHash, *checkpoint, progress, report); err != nil {
+			return false, err
+		}
+		resumed = true
+	}
+
+	covered, err := p.repo.importedRanges(websiteID, contentHash)
+	if err != nil {
+		return false, err
+	}
+	if len(subtractImportRanges(requested, covered)) == 0 {
+		progress.ReadBytes = progress.TotalBytes
+		progress.Done = true
+		if report != nil {
+			report(*progress)
+		}
+		return resumed, nil
+	}
+
+	if err := p.importContent(websiteID, file, filePath, contentHash,
+		importCheckpoint{Requested: requested}, progress, report); err != nil {
+		return false, err
+	}
+	progress.Done = true
+	if report != nil {
+		report(*progress)
+	}
+	return true, nil
+}
+
+// importCheckpoint 文件导入的进度，与每批日志在同一事务中保存
+type importCheckpoint struct {
+	Requested importRange // 导入请求的时间范围
+	Lines     int64       // 已处理的行数，其中属于该范围的日志均已写入
+	Entries   int         // 已导入的记录数
+}
+
+// importContent 从文件开头读取，跳过 checkpoint 中已处理的行，导入请求范围内尚未导入的日志
+//
+// 每批日志与导入进度在同一事务中提交，全部完成后在一个事务中记录导入历史并删除进度，
+// 中途退出后再次导入同一文件不会重复写入。
+func (p *LogParser) importContent(websiteID string, file *os.File, filePath, contentHash string,
+	checkpoint importCheckpoint, progress *ImportProgress, report func(ImportProgress)) error {
+	covered, err := p.repo.importedRanges(websiteID, contentHash)
+	if err != nil {
+		return err
+	}
+	pending := subtractImportRanges(checkpoint.Requested, covered)
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	counter := &countingReader{reader: file}
+	var reader io.Reader = counter
+	if isCompressedLog(filePath) {
+		archiveReader, err := newArchiveReader(filePath, counter)
+		if err != nil {
+			return err
+		}
+		defer archiveReader.Close()
+		reader = archiveReader
+	}
+
+	parser, err := p.newLineParser(websiteID, strings.NewReader(""), 0)
+	if err != nil {
+		return err
+	}
+	route := p.routeForWebsite(websiteID)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	const batchSize = 1000
+	batch := make([]NginxLogRecord, 0, batchSize)
+	current := checkpoint
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		current.Entries = checkpoint.Entries + len(batch)
+		save := func(tx *transaction) error {
+			return saveImportCheckpoint(tx, websiteID, contentHash, current)
+		}
+		if err := p.repo.writeLogBatches(map[string][]NginxLogRecord{websiteID: batch}, save, nil); err != nil {
+			return err
+		}
+		checkpoint = current
+		progress.Entries += len(batch)
+		progress.ReadBytes = counter.count
+		if report != nil {
+			report(*progress)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	skip := checkpoint.Lines
+	var lines int64
+	for scanner.Scan() {
+		lines++
+		if lines <= skip {
+			// 已处理的行只需重放其中的指令，如 W3C 日志的 #Fields
+			if needsHeader(parser) && strings.HasPrefix(scanner.Text(), "#") {
+				p.parseLogRecord(parser, scanner.Text(), time.Time{})
+			}
+			continue
+		}
+		current.Lines = lines
+
+		entry, err := p.parseLogRecord(parser, scanner.Text(), time.Time{})
+		if errors.Is(err, errLogDirective) {
+			continue
+		}
+		if err != nil || !importRangesContain(pending, entry.Timestamp.Unix()) ||
+			(route != nil && route.match(recordHost(entry)) != websiteID) {
+			progress.Skipped++
+			continue
+		}
+
+		batch = append(batch, *entry)
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if err := p.repo.finishImport(websiteID, contentHash, filePath, checkpoint.Requested, checkpoint.Entries); err != nil {
+		return err
+	}
+	progress.ReadBytes = counter.count
+	return nil
+}
+
+// subtractImportRanges 返回 requested 中未被 covered 覆盖的部分
+func subtractImportRanges(requested importRange, covered []importRange) []importRange {
+	sort.Slice(covered, func(i, j int) bool { return covered[i].From < covered[j].From })
+
+	var pending []importRange
+	start := requested.From
+	for _, r := range covered {
+		if r.To <= start || r.From >= requested.To {
+			continue
+		}
+		if r.From > start {
+			pending = append(pending, importRange{From: start, To: r.From})
+		}
+		start = max(start, r.To)
+		if start >= requested.To {
+			return pending
+		}
+	}
+	return append(pending, importRange{From: start, To: requested.To})
+}
+
+// importRangesContain 判断时间戳是否落在任一范围内
+func importRangesContain(ranges []importRange, timestamp int64) bool {
+	for _, r := range ranges {
+		if timestamp >= r.From && timestamp < r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// countingReader 统计已读取的字节数，用于显示进度
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(buffer []byte) (int, error) {
+	n, err := r.reader.Read(buffer)
+	r.count += int64(n)
+	return n, err
+}
+
+// importBoundary 获取网站的导入边界，首次导入时取数据库中已有的最早记录时间
+func (r *Repository) importBoundary(websiteID string) (int64, error) {
+	var boundary int64
+	err := r.db.QueryRow(
+		`SELECT import_before FROM import_boundaries WHERE website_id = ?`, websiteID,
+	).Scan(&boundary)
+	if err == nil {
+		return boundary, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	var earliest sql.NullInt64
+	if err := r.db.QueryRow(fmt.Sprintf(
+		`SELECT MIN(timestamp) FROM "%s_nginx_logs"`, websiteID)).Scan(&earliest); err != nil {
+		return 0, err
+	}
+	boundary = time.Now().Unix()
+	if earliest.Valid {
+		boundary = earliest.Int64
+	}
+
+	_, err = r.db.Exec(
+		`INSERT INTO import_boundaries (website_id, import_before) VALUES (?, ?)`,
+		websiteID, boundary)
+	return boundary, err
+}
+
+// importedRanges 查询文件内容已导入过的时间范围
+func (r *Repository) importedRanges(websiteID, contentHash string) ([]importRange, error) {
+	rows, err := r.db.Query(`
+        SELECT range_from, range_to FROM import_history
+        WHERE website_id = ? AND content_hash = ?`, websiteID, contentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ranges []importRange
+	for rows.Next() {
+		var item importRange
+		if err := rows.Scan(&item.From, &item.To); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, item)
+	}
+	return ranges, rows.Err()
+}
+
+// importCheckpoint 查询文件内容中断的导入进度，没有中断的导入时返回 nil
+func (r *Repository) importCheckpoint(websiteID, contentHash string) (*importCheckpoint, error) {
+	var checkpoint importCheckpoint
+	err := r.db.QueryRow(`
+        SELECT range_from, range_to, lines, entries FROM import_progress
+        WHERE website_id = ? AND content_hash = ?`, websiteID, contentHash).Scan(
+		&checkpoint.Requested.From, &checkpoint.Requested.To, &checkpoint.Lines, &checkpoint.Entries)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// saveImportCheckpoint 在写入日志的事务中保存导入进度
+func saveImportCheckpoint(tx *transaction, websiteID, contentHash string, checkpoint importCheckpoint) error {
+	_, err := tx.Exec(upsertSQL("import_progress", []string{"website_id", "content_hash"},
+		[]string{"range_from", "range_to", "lines", "entries"}),
+		websiteID, contentHash, checkpoint.Requested.From, checkpoint.Requested.To,
+		checkpoint.Lines, checkpoint.Entries)
+	return err
+}
+
+// finishImport 在一个事务中记录文件内容已导入的时间范围，并删除导入进度
+func (r *Repository) finishImport(websiteID, contentHash, filePath string,
+	imported importRange, entries int) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+        INSERT INTO import_history
+            (website_id, content_hash, path, range_from, range_to, entries, imported_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		websiteID, contentHash, filePath, imported.From, imported.To, entries, time.Now().Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM import_progress WHERE website_id = ? AND content_hash = ?`,
+		websiteID, contentHash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createImportTables 创建记录历史导入的表
+func (r *Repository) createImportTables() error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS import_history (
+            id %s,
+            website_id TEXT NOT NULL,
+            content_hash TEXT NOT NULL,
+            path TEXT NOT NULL,
+            range_from BIGINT NOT NULL,
+            range_to BIGINT NOT NULL,
+            entries INTEGER NOT NULL,
+            imported_at BIGINT NOT NULL
+        );
+        CREATE INDEX IF NOT EXISTS idx_import_history_hash ON import_history(website_id, content_hash);
+
+        CREATE TABLE IF NOT EXISTS import_boundaries (
+            website_id TEXT PRIMARY KEY,
+            import_before BIGINT NOT NULL
+        );
+
+        CREATE TABLE IF NOT EXISTS import_progress (
+            website_id TEXT NOT NULL,
+            content_hash TEXT NOT NULL,
+            range_from BIGINT NOT NULL,
+            range_to BIGINT NOT NULL,
+            lines BIGINT NOT NULL,
+            entries INTEGER NOT NULL,
+            PRIMARY KEY (website_id, content_hash)
+        );`, r.db.dialect.AutoIncrementKey()))
+	return err
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportFilesResumesInterruptedImport(t *testing.T) {
+	const total = 2500
+	var content strings.Builder
+	start := time.Now().AddDate(0, 0, -30)
+	for i := 0; i < total; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Second).Format("02/Jan/2006:15:04:05 -0700")
+		content.WriteString(`192.0.2.1 - - [` + timestamp + `] "GET / HTTP/1.1" 200 5 "-" "NixVisTest/1.0"` + "\n")
+	}
+	logPath := filepath.Join(t.TempDir(), "access.log.1")
+	if err := os.WriteFile(logPath, []byte(content.String()), 0644); err != nil {
+		t.Fatalf("write log file: %v", err)
+	}
+
+	repo := newTestRepository(t, "site")
+	if err := repo.createImportTables(); err != nil {
+		t.Fatalf("create import tables: %v", err)
+	}
+	parser := &LogParser{repo: repo, states: make(map[string]LogScanState)}
+	options := ImportOptions{To: time.Now()}
+
+	// 第一批提交后进程退出
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected the import to be interrupted")
+			}
+		}()
+		interrupted := options
+		interrupted.Progress = func(progress ImportProgress) {
+			if progress.Entries > 0 {
+				panic("interrupted")
+			}
+		}
+		parser.ImportFiles("site", []string{logPath}, interrupted)
+	}()
+	if count := countTestRows(t, repo, "site"); count != 1000 {
+		t.Fatalf("expected the first batch to be committed, got %d rows", count)
+	}
+
+	result, err := parser.ImportFiles("site", []string{logPath}, options)
+	if err != nil {
+		t.Fatalf("import again: %v", err)
+	}
+	if result.Entries != total-1000 {
+		t.Fatalf("expected only the remaining entries to be imported, got %+v", result)
+	}
+	if count := countTestRows(t, repo, "site"); count != total {
+		t.Fatalf("expected %d rows without duplicates, got %d", total, count)
+	}
+	var pending int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM import_progress`).Scan(&pending); err != nil || pending != 0 {
+		t.Fatalf("expected the import progress to be cleared, got %d (%v)", pending, err)
+	}
+
+	if result, err = parser.ImportFiles("site", []string{logPath}, options); err != nil || result.SkippedFiles != 1 {
+		t.Fatalf("expected the file to be skipped, got %+v (%v)", result, err)
+	}
+}
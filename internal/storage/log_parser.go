@@ -2,15 +2,15 @@ package storage
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
 	"errors"
 	"fmt"
-	"net/url"
+	"io"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/netparser"
@@ -18,30 +18,7 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func writeFileAtomically(path string, data []byte) error {
-	temporaryFile, err := os.CreateTemp(filepath.Dir(path), ".nixvis-*")
-	if err != nil {
-		return err
-	}
-	temporaryPath := temporaryFile.Name()
-	defer os.Remove(temporaryPath)
-
-	if err := temporaryFile.Chmod(0644); err != nil {
-		temporaryFile.Close()
-		return err
-	}
-	if _, err := temporaryFile.Write(data); err != nil {
-		temporaryFile.Close()
-		return err
-	}
-	if err := temporaryFile.Close(); err != nil {
-		return err
-	}
-	return os.Rename(temporaryPath, path)
-}
-
 var (
-	nginxLogPattern = regexp.MustCompile(`^(\S+) - (\S+) \[([^\]]+)\] "(\S+) ([^"]+) HTTP\/\d\.\d" (\d+) (\d+) "([^"]*)" "([^"]*)"`)
 	lastCleanupDate = ""
 )
 
@@ -54,71 +31,80 @@ type ParserResult struct {
 	Duration       time.Duration
 	Success        bool
 	Error          error
+
+	routed map[string]int // 共用日志中分发到各网站的记录数
 }
 
 type LogScanState struct {
-	Files map[string]FileState `json:"files"` // 每个文件的状态
+	Files    map[string]FileState    `json:"files"`              // 每个文件的状态
+	Archives map[string]ArchiveState `json:"archives,omitempty"` // 已导入的压缩日志，以内容哈希为键
+	Rotated  []FileState             `json:"rotated,omitempty"`  // 已轮转离开原路径的文件状态
 }
 
 type FileState struct {
-	LastOffset int64 `json:"last_offset"`
-	LastSize   int64 `json:"last_size"`
+	LastOffset      int64  `json:"last_offset"`
+	LastSize        int64  `json:"last_size"`
+	Device          uint64 `json:"device,omitempty"`
+	Inode           uint64 `json:"inode,omitempty"`
+	Fingerprint     string `json:"fingerprint,omitempty"`      // 文件头的 SHA-256
+	FingerprintSize int64  `json:"fingerprint_size,omitempty"` // 参与指纹计算的字节数
+	Path            string `json:"path,omitempty"`             // 轮转前的路径，仅用于 Rotated
+	// Header 读取到 LastOffset 时解析器依赖的文件头指令（如 W3C 的 #Fields），继续读取时无需重读文件开头
+	Header []string `json:"header,omitempty"`
 }
 
 type LogParser struct {
-	repo      *Repository
-	statePath string
-	states    map[string]LogScanState // 各网站的扫描状态，以网站ID为键
+	repo        *Repository
+	states      map[string]LogScanState      // 各网站的扫描状态，以网站ID为键
+	formats     map[string]LineParserFactory // 各网站的日志解析器，以网站ID为键
+	routes      map[string]*logRoute         // 多个网站共用的日志来源，以来源的键为键
+	importing   map[string]bool              // 正在导入的压缩日志，以内容哈希为键
+	concurrency int                          // 同时扫描的文件数上限
+	following   bool                         // 是否处于实时跟踪模式
+	mu          sync.Mutex                   // 保护扫描状态，周期扫描与实时跟踪互斥
+	stateMu     sync.Mutex                   // 并发扫描时保护 states、formats 和 importing
 }
 
 // NewLogParser 创建新的日志解析器
 func NewLogParser(userRepoPtr *Repository) *LogParser {
-	statePath := filepath.Join(util.DataDir, "nginx_scan_state.json")
 	parser := &LogParser{
-		repo:      userRepoPtr,
-		statePath: statePath,
-		states:    make(map[string]LogScanState),
+		repo:        userRepoPtr,
+		states:      make(map[string]LogScanState),
+		formats:     make(map[string]LineParserFactory),
+		concurrency: util.ReadConfig().System.ScanConcurrency,
 	}
 	parser.loadState()
 	netparser.InitPVFilters()
 	return parser
 }
 
-// loadState 加载上次扫描状态
+// loadState 从数据库加载上次扫描状态
 func (p *LogParser) loadState() {
-	data, err := os.ReadFile(p.statePath)
-	if os.IsNotExist(err) {
-		// 状态文件不存在，创建空状态映射
-		p.states = make(map[string]LogScanState)
-		return
-	}
-
+	states, err := p.repo.loadScanStates()
 	if err != nil {
-		logrus.Errorf("无法读取扫描状态文件: %v", err)
-		p.states = make(map[string]LogScanState)
-		return
-	}
-
-	if err := json.Unmarshal(data, &p.states); err != nil {
-		logrus.Errorf("解析扫描状态失败: %v", err)
-		p.states = make(map[string]LogScanState)
+		logrus.Errorf("读取扫描状态失败: %v", err)
+		states = make(map[string]LogScanState)
 	}
+	p.states = states
 }
 
-// updateState 更新并保存状态
+// updateState 保存扫描状态
+//
+// 读取进度已随日志一起提交，这里保存轮转、删除文件等不伴随日志写入的状态变化。
 func (p *LogParser) updateState() {
-	data, err := json.Marshal(p.states)
-	if err != nil {
-		logrus.Errorf("保存扫描状态失败: %v", err)
-		return
+	p.stateMu.Lock()
+	states := make(map[string]LogScanState, len(p.states))
+	for id, state := range p.states {
+		states[id] = cloneScanState(state)
 	}
+	p.stateMu.Unlock()
 
-	if err := writeFileAtomically(p.statePath, data); err != nil {
+	if err := p.repo.saveScanStates(states); err != nil {
 		logrus.Errorf("保存扫描状态失败: %v", err)
 	}
 }
 
-// CleanOldLogs 清理45天前的日志数据
+// CleanOldLogs 每天清理一次超过保留期限的日志数据
 func (p *LogParser) CleanOldLogs() error {
 	today := time.Now().Format("2006-01-02")
 	currentHour := time.Now().Hour()
@@ -141,56 +127,186 @@ func (p *LogParser) CleanOldLogs() error {
 
 // ScanNginxLogs 增量扫描Nginx日志文件
 func (p *LogParser) ScanNginxLogs() []ParserResult {
-	// 获取所有网站ID
-	websiteIDs := util.GetAllWebsiteIDs()
-	parserResults := make([]ParserResult, len(websiteIDs))
+	return p.ScanWebsites(util.GetAllWebsiteIDs())
+}
 
-	for i, id := range websiteIDs {
-		startTime := time.Now()
-
-		website, _ := util.GetWebsiteByID(id)
-		parserResult := EmptyParserResult(website.Name, id)
-
-		logPath := website.LogPath
-		if strings.Contains(logPath, "*") {
-			matches, err := filepath.Glob(logPath)
-			if err != nil {
-				errstr := "解析日志路径模式 " + logPath + " 失败: " + err.Error()
-				parserResult.Success = false
-				parserResult.Error = errors.New(errstr)
-			} else if len(matches) == 0 {
-				errstr := "日志路径模式 " + logPath + " 未匹配到任何文件"
-				parserResult.Success = false
-				parserResult.Error = errors.New(errstr)
-			} else {
-				for _, matchPath := range matches {
-					p.scanSingleFile(id, matchPath, &parserResult)
-				}
-			}
-		} else {
-			p.scanSingleFile(id, logPath, &parserResult)
+// ScanWebsite 增量扫描单个网站的日志文件并保存状态
+func (p *LogParser) ScanWebsite(websiteID string) ParserResult {
+	return p.ScanWebsites([]string{websiteID})[0]
+}
+
+// ScanWebsites 增量扫描多个网站的日志文件并保存状态
+//
+// 各网站并发扫描，同时扫描的文件数不超过 scanConcurrency；共用的日志文件只扫描一次。
+func (p *LogParser) ScanWebsites(websiteIDs []string) []ParserResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 每个网站或共用的日志来源为一个扫描任务
+	type scanJob struct {
+		websiteID string
+		route     *logRoute
+	}
+	var jobs []scanJob
+	queued := make(map[string]bool)
+	for _, id := range websiteIDs {
+		job := scanJob{websiteID: id, route: p.routeForWebsite(id)}
+		key := id
+		if job.route != nil {
+			key = job.route.key
 		}
+		if !queued[key] {
+			queued[key] = true
+			jobs = append(jobs, job)
+		}
+	}
 
-		parserResult.Duration = time.Since(startTime)
-		parserResults[i] = parserResult
+	slots := make(chan struct{}, p.scanConcurrency())
+	jobResults := make([]map[string]ParserResult, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Go(func() {
+			if job.route != nil {
+				jobResults[i] = p.scanRoute(job.route, slots)
+				return
+			}
+			jobResults[i] = map[string]ParserResult{job.websiteID: p.scanWebsite(job.websiteID, slots)}
+		})
+	}
+	wg.Wait()
+
+	results := make(map[string]ParserResult, len(websiteIDs))
+	for _, jobResult := range jobResults {
+		for id, result := range jobResult {
+			results[id] = result
+		}
+	}
+	parserResults := make([]ParserResult, len(websiteIDs))
+	for i, id := range websiteIDs {
+		parserResults[i] = results[id]
 	}
 
-	// 2. 更新并保存状态
+	// 更新并保存状态
 	p.updateState()
 
 	return parserResults
 }
 
+// scanConcurrency 返回同时扫描的文件数上限，未配置时为 CPU 核数
+func (p *LogParser) scanConcurrency() int {
+	if p.concurrency > 0 {
+		return p.concurrency
+	}
+	return runtime.NumCPU()
+}
+
+// scanWebsite 扫描网站独立使用的日志文件
+func (p *LogParser) scanWebsite(websiteID string, slots chan struct{}) ParserResult {
+	website, _ := util.GetWebsiteByID(websiteID)
+	return p.scanSource(websiteID, website.LogPath,
+		EmptyParserResult(website.Name, websiteID), slots)
+}
+
+// scanSource 扫描日志来源配置的日志文件，以及轮转后仍可能被写入的旧文件
+//
+// sourceID 为网站ID，或多个网站共用日志时的来源键，用于记录扫描状态。
+// 未压缩的文件按顺序扫描以便跟踪轮转，之后匹配到的压缩日志并发导入；
+// 每个文件扫描前从 slots 中取得名额。
+func (p *LogParser) scanSource(sourceID string, logPath string,
+	parserResult ParserResult, slots chan struct{}) ParserResult {
+	startTime := time.Now()
+
+	scanFile := func(filePath string, result *ParserResult) {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		p.scanSingleFile(sourceID, filePath, result)
+	}
+
+	scanned := make(map[string]bool)
+	if strings.Contains(logPath, "*") {
+		matches, err := filepath.Glob(logPath)
+		if err != nil {
+			errstr := "解析日志路径模式 " + logPath + " 失败: " + err.Error()
+			parserResult.Success = false
+			parserResult.Error = errors.New(errstr)
+		} else if len(matches) == 0 {
+			errstr := "日志路径模式 " + logPath + " 未匹配到任何文件"
+			parserResult.Success = false
+			parserResult.Error = errors.New(errstr)
+		} else {
+			var archives []string
+			for _, matchPath := range matches {
+				if isCompressedLog(matchPath) {
+					archives = append(archives, matchPath)
+					continue
+				}
+				scanFile(matchPath, &parserResult)
+				scanned[matchPath] = true
+			}
+
+			var wg sync.WaitGroup
+			var resultMu sync.Mutex
+			for _, archivePath := range archives {
+				wg.Go(func() {
+					archiveResult := EmptyParserResult(parserResult.WebName, parserResult.WebID)
+					scanFile(archivePath, &archiveResult)
+
+					resultMu.Lock()
+					mergeParserResult(&parserResult, archiveResult)
+					resultMu.Unlock()
+				})
+			}
+			wg.Wait()
+		}
+	} else {
+		scanFile(logPath, &parserResult)
+		scanned[logPath] = true
+
+		// Nginx 重新打开日志前仍会写入已重命名的旧文件
+		for filePath := range p.siteState(sourceID).Files {
+			if !scanned[filePath] && strings.HasPrefix(filePath, logPath) {
+				scanFile(filePath, &parserResult)
+			}
+		}
+	}
+	p.retireMissingFiles(sourceID)
+
+	parserResult.Duration = time.Since(startTime)
+	return parserResult
+}
+
+// mergeParserResult 将单个文件的扫描结果合并到网站的扫描结果中
+func mergeParserResult(target *ParserResult, result ParserResult) {
+	target.TotalEntries += result.TotalEntries
+	target.SkippedEntries += result.SkippedEntries
+	if !result.Success {
+		target.Success = false
+		target.Error = result.Error
+	}
+	for id, count := range result.routed {
+		if target.routed == nil {
+			target.routed = make(map[string]int)
+		}
+		target.routed[id] += count
+	}
+}
+
 // scanSingleFile 扫描单个日志文件
 func (p *LogParser) scanSingleFile(
 	websiteID string, logPath string, parserResult *ParserResult) {
-	if strings.HasSuffix(strings.ToLower(logPath), ".gz") {
+	if _, err := p.lineParserFactory(websiteID); err != nil {
 		parserResult.Success = false
-		parserResult.Error = fmt.Errorf("不支持压缩日志文件 %s，请在 logPath 中排除 .gz 文件", logPath)
+		parserResult.Error = err
 		logrus.Warn(parserResult.Error)
 		return
 	}
 
+	// 压缩的归档日志整体导入
+	if isCompressedLog(logPath) {
+		p.scanArchiveFile(websiteID, logPath, parserResult)
+		return
+	}
+
 	// 打开文件
 	file, err := os.Open(logPath)
 	if err != nil {
@@ -210,196 +326,347 @@ func (p *LogParser) scanSingleFile(
 		return
 	}
 
+	currentState, err := readFileState(file, fileInfo)
+	if err != nil {
+		logrus.Errorf("无法读取日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法读取日志文件 %s: %w", logPath, err)
+		return
+	}
+
 	// 确定扫描起始位置
-	currentSize := fileInfo.Size()
-	startOffset := p.determineStartOffset(websiteID, logPath, currentSize)
+	startOffset, header := p.determineStartOffset(websiteID, logPath, file, currentState, parserResult)
+	currentState.Header = header
+
+	currentState, ok := p.scanFileRange(websiteID, logPath, file, currentState, startOffset, parserResult)
+	if !ok {
+		return
+	}
+
+	// 更新文件状态
+	p.updateFileState(websiteID, logPath, currentState)
+}
+
+// scanFileRange 解析文件中从 startOffset 到 current 记录的文件大小之间的完整日志行
+//
+// current.Header 为读取到 startOffset 时的文件头指令。每批日志写入时，读取进度以 current 的
+// 身份信息记录在 logPath 下。返回读取完成后的文件状态。
+func (p *LogParser) scanFileRange(websiteID string, logPath string, file *os.File,
+	current FileState, startOffset int64, parserResult *ParserResult) (FileState, bool) {
+	current.LastOffset = startOffset
+	endOffset, err := lastLineEnd(file, startOffset, current.LastSize)
+	if err != nil {
+		logrus.Errorf("无法读取日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法读取日志文件 %s: %w", logPath, err)
+		return current, false
+	}
+	if endOffset == startOffset {
+		return current, true
+	}
 
 	// 设置读取位置
-	_, err = file.Seek(startOffset, 0)
+	_, err = file.Seek(startOffset, io.SeekStart)
 	if err != nil {
 		logrus.Errorf("无法设置文件读取位置 %s: %v", logPath, err)
 		parserResult.Success = false
 		parserResult.Error = fmt.Errorf("无法定位日志文件 %s: %w", logPath, err)
-		return
+		return current, false
 	}
 
-	// 读取并解析日志
-	entriesCount := p.parseLogLines(file, websiteID, parserResult)
+	parser, err := p.newFileLineParser(websiteID, file, current.Header, startOffset)
+	if err != nil {
+		logrus.Errorf("无法读取日志文件 %s: %v", logPath, err)
+		parserResult.Success = false
+		parserResult.Error = fmt.Errorf("无法读取日志文件 %s: %w", logPath, err)
+		return current, false
+	}
+
+	checkpoint := func(state *LogScanState, consumed int64) {
+		fileState := current
+		fileState.LastOffset = startOffset + consumed
+		fileState.Header = parserHeader(parser)
+		state.Files[logPath] = fileState
+	}
+
+	// 读取并解析日志，只读到记录的文件大小，之后追加的内容留给下一轮
+	reader := io.LimitReader(file, endOffset-startOffset)
+	entriesCount := p.parseLogLines(reader, websiteID, parser, parserResult, checkpoint)
 	if entriesCount < 0 {
 		parserResult.Success = false
 		parserResult.Error = errors.New("日志写入失败，读取进度未更新，将在下一轮重试")
-		return
+		return current, false
 	}
 	parserResult.TotalEntries += entriesCount
 
-	// 更新文件状态
-	p.updateFileState(websiteID, logPath, currentSize)
-
 	if entriesCount > 0 {
-		logrus.Infof("网站 %s 的日志文件 %s 扫描完成，解析了 %d 条记录",
-			websiteID, logPath, entriesCount)
+		if p.following {
+			logrus.Debugf("网站 %s 的日志文件 %s 扫描完成，解析了 %d 条记录",
+				websiteID, logPath, entriesCount)
+		} else {
+			logrus.Infof("网站 %s 的日志文件 %s 扫描完成，解析了 %d 条记录",
+				websiteID, logPath, entriesCount)
+		}
 	}
+	current.LastOffset = endOffset
+	current.Header = parserHeader(parser)
+	return current, true
 }
 
-// updateFileState 更新文件状态
-func (p *LogParser) updateFileState(
-	websiteID string, filePath string, currentSize int64) {
-	state, ok := p.states[websiteID]
-	if !ok {
-		state = LogScanState{
-			Files: make(map[string]FileState),
+// lastLineEnd 返回 [startOffset, endOffset) 范围内最后一个完整行的结束位置
+//
+// 写入中的半行留给下一轮扫描；范围内没有换行符时返回 startOffset。
+func lastLineEnd(file io.ReaderAt, startOffset, endOffset int64) (int64, error) {
+	const chunkSize = 64 * 1024
+	buffer := make([]byte, chunkSize)
+
+	for end := endOffset; end > startOffset; {
+		start := max(end-chunkSize, startOffset)
+		chunk := buffer[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return startOffset, err
+		}
+		if index := bytes.LastIndexByte(chunk, '\n'); index >= 0 {
+			return start + int64(index) + 1, nil
 		}
+		end = start
 	}
+	return startOffset, nil
+}
+
+// siteState 获取网站的扫描状态，不存在时创建
+func (p *LogParser) siteState(websiteID string) LogScanState {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 
+	state, ok := p.states[websiteID]
+	if ok && state.Files != nil && state.Archives != nil {
+		return state
+	}
 	if state.Files == nil {
 		state.Files = make(map[string]FileState)
 	}
-
-	fileState := FileState{
-		LastOffset: currentSize,
-		LastSize:   currentSize,
+	if state.Archives == nil {
+		state.Archives = make(map[string]ArchiveState)
 	}
-
-	state.Files[filePath] = fileState
 	p.states[websiteID] = state
+	return state
 }
 
-// determineStartOffset 确定扫描起始位置
-func (p *LogParser) determineStartOffset(
-	websiteID string, filePath string, currentSize int64) int64 {
+// lookupSiteState 获取网站已有的扫描状态
+func (p *LogParser) lookupSiteState(websiteID string) (LogScanState, bool) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 
 	state, ok := p.states[websiteID]
-	if !ok { // 网站没有扫描记录，创建新状态
-		p.states[websiteID] = LogScanState{
-			Files: make(map[string]FileState),
-		}
-		return 0
-	}
+	return state, ok
+}
 
-	if state.Files == nil {
-		state.Files = make(map[string]FileState)
-		p.states[websiteID] = state
-		return 0
-	}
+// storeSiteState 保存网站的扫描状态
+func (p *LogParser) storeSiteState(websiteID string, state LogScanState) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 
-	fileState, ok := state.Files[filePath]
-	if !ok {
-		return 0
-	}
+	p.states[websiteID] = state
+}
 
-	// 文件是否被轮转
-	if currentSize < fileState.LastSize {
-		logrus.Infof("检测到网站 %s 的日志文件 %s 已被轮转，从头开始扫描", websiteID, filePath)
-		return 0
-	}
+// updateFileState 记录文件的扫描进度
+func (p *LogParser) updateFileState(
+	websiteID string, filePath string, fileState FileState) {
+	state := p.siteState(websiteID)
 
-	return fileState.LastOffset
+	fileState.Path = ""
+	state.Files[filePath] = fileState
 }
 
 // parseLogLines 解析日志行并返回解析的记录数
-func (p *LogParser) parseLogLines(
-	file *os.File, websiteID string, parserResult *ParserResult) int {
+//
+// 各网站的日志攒够一批后一起写入，checkpoint 不为空时在同一事务中记录已读取的字节数。
+func (p *LogParser) parseLogLines(file io.Reader, websiteID string,
+	parser LineParser, parserResult *ParserResult, checkpoint scanCheckpoint) int {
 	scanner := bufio.NewScanner(file)
 	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	entriesCount := 0
 
-	// 批量插入相关
+	// 记录已读取的字节数，包括换行符和跳过的行
+	var consumed int64
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		consumed += int64(advance)
+		return advance, token, err
+	})
+
+	// 共用的日志按主机名分发到各网站
+	route := p.logRoutes()[websiteID]
+
+	// 批量插入相关，以目标网站ID为键
 	const batchSize = 100
-	batch := make([]NginxLogRecord, 0, batchSize)
+	batches := make(map[string][]NginxLogRecord)
+	pending := 0
 
-	// 处理一批数据
-	processBatch := func() error {
-		if len(batch) == 0 {
+	// 写入所有网站攒下的日志，读取进度只能推进到全部写入的位置
+	processBatches := func() error {
+		if pending == 0 {
 			return nil
 		}
 
-		if err := p.repo.BatchInsertLogsForWebsite(websiteID, batch); err != nil {
+		var progress func(*LogScanState)
+		if checkpoint != nil {
+			offset := consumed
+			progress = func(state *LogScanState) { checkpoint(state, offset) }
+		}
+		if err := p.commitBatches(websiteID, batches, progress); err != nil {
 			logrus.Errorf("批量插入网站 %s 的日志记录失败: %v", websiteID, err)
 			return err
 		}
 
-		batch = batch[:0] // 清空批次但保留容量
+		for targetID, batch := range batches {
+			batches[targetID] = batch[:0] // 清空批次但保留容量
+		}
+		pending = 0
 		return nil
 	}
 
+	cutoffTime := p.retentionCutoff(websiteID)
+
 	// 逐行处理
 	for scanner.Scan() {
 		line := scanner.Text()
-		entry, err := p.parseNginxLogLine(line)
+		entry, err := p.parseLogRecord(parser, line, cutoffTime)
+		if errors.Is(err, errLogDirective) {
+			continue
+		}
 		if err != nil {
 			parserResult.SkippedEntries++
 			continue
 		}
-		batch = append(batch, *entry)
+
+		targetID := websiteID
+		if route != nil {
+			if targetID = route.match(recordHost(entry)); targetID == "" {
+				parserResult.SkippedEntries++
+				continue
+			}
+			if parserResult.routed == nil {
+				parserResult.routed = make(map[string]int)
+			}
+			parserResult.routed[targetID]++
+		}
+
+		batches[targetID] = append(batches[targetID], *entry)
 		entriesCount++
-		if len(batch) >= batchSize {
-			if err := processBatch(); err != nil {
+		pending++
+		if pending >= batchSize {
+			if err := processBatches(); err != nil {
 				return -1
 			}
 		}
 	}
 
-	if err := processBatch(); err != nil { // 处理剩余的记录
-			return -1
-		}
-
 	if err := scanner.Err(); err != nil {
 		logrus.Errorf("扫描网站 %s 的文件时出错: %v", websiteID, err)
 		return -1
 	}
 
+	if err := processBatches(); err != nil { // 处理剩余的记录
+		return -1
+	}
+
 	return entriesCount // 返回当前文件的日志条数
 }
 
-// parseNginxLogLine 解析单行Nginx日志
-func (p *LogParser) parseNginxLogLine(line string) (*NginxLogRecord, error) {
-	matches := nginxLogPattern.FindStringSubmatch(line)
+// lineParserFactory 获取网站配置的日志解析器工厂，未配置时使用 combined 格式
+func (p *LogParser) lineParserFactory(websiteID string) (LineParserFactory, error) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+
+	if factory, ok := p.formats[websiteID]; ok {
+		return factory, nil
+	}
 
-	if len(matches) < 10 {
-		return nil, errors.New("日志格式不匹配")
+	website, ok := p.sourceConfig(websiteID)
+	if !ok {
+		return func() LineParser { return defaultNginxLogFormat }, nil
 	}
 
-	timestamp, err := time.Parse("02/Jan/2006:15:04:05 -0700", matches[3])
+	factory, err := newLineParserFactory(website)
+	if err != nil {
+		return nil, fmt.Errorf("网站 %s 的日志格式配置无效: %w", website.Name, err)
+	}
+
+	if p.formats == nil {
+		p.formats = make(map[string]LineParserFactory)
+	}
+	p.formats[websiteID] = factory
+	return factory, nil
+}
+
+// newLineParser 为一个日志文件创建解析器，并读取 skipped 中 size 字节的已跳过内容
+//
+// 已跳过的内容不会导入，但其中的文件头指令（如 W3C 的 #Fields）仍需交给解析器。
+func (p *LogParser) newLineParser(
+	websiteID string, skipped io.Reader, size int64) (LineParser, error) {
+	factory, err := p.lineParserFactory(websiteID)
 	if err != nil {
 		return nil, err
 	}
 
-	cutoffTime := time.Now().AddDate(0, 0, -31)
-	if timestamp.Before(cutoffTime) {
-		return nil, errors.New("日志超过30天")
+	parser := factory()
+	if err := readHeaderDirectives(skipped, size, parser); err != nil && err != io.EOF {
+		return nil, err
 	}
+	return parser, nil
+}
 
-	decodedPath, err := url.QueryUnescape(matches[5])
+// newFileLineParser 为从 startOffset 继续读取的未压缩日志文件创建解析器
+//
+// 只有依赖文件头指令的解析器需要之前的内容：优先使用扫描状态中保存的指令 header，
+// 旧版本的扫描状态没有保存时才读取文件开头；其他格式不会读取已扫描的部分。
+func (p *LogParser) newFileLineParser(
+	websiteID string, file io.ReaderAt, header []string, startOffset int64) (LineParser, error) {
+	factory, err := p.lineParserFactory(websiteID)
 	if err != nil {
-		decodedPath = matches[5]
+		return nil, err
+	}
+
+	parser := factory()
+	if startOffset == 0 || !needsHeader(parser) {
+		return parser, nil
+	}
+	if len(header) > 0 {
+		for _, line := range header {
+			parser.ParseLine(line)
+		}
+		return parser, nil
+	}
+	if err := readHeaderDirectives(io.NewSectionReader(file, 0, startOffset), startOffset, parser); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return parser, nil
+}
+
+// retentionCutoff 返回日志来源的保留期限，早于该时间的日志不再写入
+//
+// 多个网站共用日志时取其中最长的保留天数，各网站超出的部分由定期清理删除。
+func (p *LogParser) retentionCutoff(sourceID string) time.Time {
+	days := util.RetentionDays(sourceID)
+	if route, ok := p.logRoutes()[sourceID]; ok {
+		for _, id := range route.websiteIDs {
+			days = max(days, util.RetentionDays(id))
+		}
 	}
-	statusCode, _ := strconv.Atoi(matches[6])
-	bytesSent, _ := strconv.Atoi(matches[7])
-	referPath, err := url.QueryUnescape(matches[8])
+	return startOfDay(time.Now().AddDate(0, 0, -days))
+}
+
+// parseLogRecord 使用指定解析器解析单行日志，cutoffTime 为零时不限制日志时间
+func (p *LogParser) parseLogRecord(
+	parser LineParser, line string, cutoffTime time.Time) (*NginxLogRecord, error) {
+	fields, err := parser.ParseLine(line)
 	if err != nil {
-		referPath = matches[8]
-	}
-
-	pageviewFlag := netparser.ShouldCountAsPageView(statusCode, decodedPath, matches[1])
-	domesticLocation, globalLocation, _ := netparser.GetIPLocation(matches[1])
-	browser, os, device := netparser.ParseUserAgent(matches[9])
-
-	return &NginxLogRecord{
-		ID:               0,
-		IP:               matches[1],
-		PageviewFlag:     pageviewFlag,
-		Timestamp:        timestamp,
-		Method:           matches[4],
-		Url:              decodedPath,
-		Status:           statusCode,
-		BytesSent:        bytesSent,
-		Referer:          referPath,
-		UserBrowser:      browser,
-		UserOs:           os,
-		UserDevice:       device,
-		DomesticLocation: domesticLocation,
-		GlobalLocation:   globalLocation,
-	}, nil
+		return nil, err
+	}
+
+	return buildLogRecord(fields, cutoffTime)
 }
 
 // EmptyParserResult 生成空结果
@@ -1,11 +1,21 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
 	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
 )
 
 func testLogLine() string {
@@ -15,9 +25,9 @@ func testLogLine() string {
 
 func TestParseNginxLogLine(t *testing.T) {
 	parser := &LogParser{}
-	record, err := parser.parseNginxLogLine(testLogLine())
+	record, err := parser.parseLogRecord(defaultNginxLogFormat, testLogLine(), time.Time{})
 	if err != nil {
-		t.Fatalf("parseNginxLogLine returned an error: %v", err)
+		t.Fatalf("parseLogRecord returned an error: %v", err)
 	}
 	if record.Url != "/hello world" {
 		t.Fatalf("unexpected decoded URL: %q", record.Url)
@@ -27,17 +37,62 @@ func TestParseNginxLogLine(t *testing.T) {
 	}
 }
 
+func TestParseCustomLogFormat(t *testing.T) {
+	format, err := compileNginxLogFormat(`$remote_addr - $remote_user [$time_local] "$request" ` +
+		`$status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time "$http_x_forwarded_for" $host`)
+	if err != nil {
+		t.Fatalf("compileNginxLogFormat returned an error: %v", err)
+	}
+
+	line := strings.TrimSuffix(testLogLine(), `"NixVisTest/1.0"`) +
+		`"NixVisTest/1.0" 0.012 "203.0.113.9, 10.0.0.1" example.com`
+	parser := &LogParser{}
+	record, err := parser.parseLogRecord(format, line, time.Time{})
+	if err != nil {
+		t.Fatalf("parseLogRecord returned an error: %v", err)
+	}
+	if record.Method != "GET" || record.Status != 200 || record.BytesSent != 123 {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+	if record.RequestTime == nil || *record.RequestTime != 0.012 || record.Extra["host"] != "example.com" ||
+		record.Extra["http_x_forwarded_for"] != "203.0.113.9, 10.0.0.1" {
+		t.Fatalf("unexpected extra fields: %v", record.Extra)
+	}
+	if _, ok := record.Extra["remote_user"]; ok {
+		t.Fatalf("known variables must not be stored as extra fields: %v", record.Extra)
+	}
+
+	if _, err := parser.parseLogRecord(defaultNginxLogFormat, "not a log line", time.Time{}); err == nil {
+		t.Fatal("expected mismatched line to be rejected")
+	}
+}
+
+func TestParseLogDuration(t *testing.T) {
+	if value := parseLogDuration("0.010, 0.020 : 0.005"); value == nil || math.Abs(*value-0.035) > 1e-9 {
+		t.Fatalf("expected upstream times to be summed, got %v", value)
+	}
+	if value := parseLogDuration("-"); value != nil {
+		t.Fatalf("expected missing duration to be nil, got %v", *value)
+	}
+}
+
+func TestCompileNginxLogFormatRejectsAdjacentVariables(t *testing.T) {
+	if _, err := compileNginxLogFormat(`$remote_addr$status`); err == nil {
+		t.Fatal("expected adjacent variables to be rejected")
+	}
+}
+
 func TestUpdateStateRoundTrip(t *testing.T) {
-	statePath := filepath.Join(t.TempDir(), "nginx_scan_state.json")
+	repo := newTestRepository(t, "site")
 	parser := &LogParser{
-		statePath: statePath,
+		repo: repo,
 		states: map[string]LogScanState{
 			"site": {Files: map[string]FileState{"access.log": {LastOffset: 12, LastSize: 18}}},
 		},
 	}
 	parser.updateState()
 
-	reloaded := &LogParser{statePath: statePath}
+	reloaded := &LogParser{repo: repo}
 	reloaded.loadState()
 	state := reloaded.states["site"].Files["access.log"]
 	if state.LastOffset != 12 || state.LastSize != 18 {
@@ -45,6 +100,74 @@ func TestUpdateStateRoundTrip(t *testing.T) {
 	}
 }
 
+func TestScanStateCommittedWithLogs(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	var content strings.Builder
+	for i := 0; i < 250; i++ {
+		content.WriteString(testLogLine() + "\n")
+	}
+	if err := os.WriteFile(logPath, []byte(content.String()), 0644); err != nil {
+		t.Fatalf("write log file: %v", err)
+	}
+
+	// 扫描后未执行 updateState 就退出，重新启动后不会重复导入
+	parser := &LogParser{repo: repo, states: make(map[string]LogScanState)}
+	result := EmptyParserResult("site", "site")
+	parser.scanSingleFile("site", logPath, &result)
+	restarted := &LogParser{repo: repo}
+	restarted.loadState()
+	restarted.scanSingleFile("site", logPath, &result)
+	if count := countTestRows(t, repo, "site"); count != 250 || !result.Success {
+		t.Fatalf("expected 250 rows after restart, got %d (%+v)", count, result)
+	}
+
+	// 读取中途失败时，已提交的日志与记录的进度一致
+	var lines strings.Builder
+	for i := 0; i < 150; i++ {
+		lines.WriteString(testLogLine() + "\n")
+	}
+	failing := io.MultiReader(strings.NewReader(lines.String()), iotest.ErrReader(errors.New("read failed")))
+	checkpoint := func(state *LogScanState, consumed int64) {
+		state.Files["other.log"] = FileState{LastOffset: consumed}
+	}
+	if entries := parser.parseLogLines(failing, "site", defaultNginxLogFormat, &result, checkpoint); entries != -1 {
+		t.Fatalf("expected read failure marker, got %d", entries)
+	}
+	states, err := repo.loadScanStates()
+	if err != nil {
+		t.Fatalf("load scan states: %v", err)
+	}
+	committed := states["site"].Files["other.log"].LastOffset
+	if committed != int64(len(testLogLine())+1)*100 || countTestRows(t, repo, "site") != 350 {
+		t.Fatalf("expected 100 committed lines, got offset %d and %d rows", committed, countTestRows(t, repo, "site"))
+	}
+	if states["site"].Files[logPath].LastOffset != int64(content.Len()) {
+		t.Fatalf("unexpected committed offset: %+v", states["site"].Files[logPath])
+	}
+}
+
+func TestImportScanStateFile(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	statePath := filepath.Join(t.TempDir(), "nginx_scan_state.json")
+	if err := os.WriteFile(statePath, []byte(`{"site":{"files":{"/var/log/a.log":{"last_offset":10,"last_size":10}}}}`), 0644); err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+	if err := repo.importScanStateFile(statePath); err != nil {
+		t.Fatalf("import state: %v", err)
+	}
+	if states, err := repo.loadScanStates(); err != nil || states["site"].Files["/var/log/a.log"].LastOffset != 10 {
+		t.Fatalf("unexpected imported state: %+v (%v)", states, err)
+	}
+	if _, err := os.Stat(statePath + ".imported"); err != nil {
+		t.Fatalf("expected the state file to be renamed: %v", err)
+	}
+	// 文件已导入，再次启动时不再处理
+	if err := repo.importScanStateFile(statePath); err != nil {
+		t.Fatalf("import missing state: %v", err)
+	}
+}
+
 func TestParseLogLinesReportsDatabaseFailure(t *testing.T) {
 	db, err := sql.Open("sqlite", ":memory:")
 	if err != nil {
@@ -64,8 +187,586 @@ func TestParseLogLinesReportsDatabaseFailure(t *testing.T) {
 		t.Fatalf("seek log file: %v", err)
 	}
 
-	parser := &LogParser{repo: &Repository{db: db}}
-	if entries := parser.parseLogLines(file, "site", &ParserResult{}); entries != -1 {
+	parser := &LogParser{repo: newRepository(db, sqliteDialect{})}
+	if entries := parser.parseLogLines(file, "site", defaultNginxLogFormat, &ParserResult{}, nil); entries != -1 {
 		t.Fatalf("expected database failure marker, got %d", entries)
 	}
 }
+
+func newTestRepository(t *testing.T, websiteID string) *Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	repo := newRepository(db, sqliteDialect{})
+	if err := repo.createWebsiteTables(websiteID); err != nil {
+		t.Fatalf("create tables: %v", err)
+	}
+	if err := repo.createScanStateTable(); err != nil {
+		t.Fatalf("create scan state table: %v", err)
+	}
+	return repo
+}
+
+func countTestRows(t *testing.T, repo *Repository, websiteID string) int {
+	t.Helper()
+	var count int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM "` + websiteID + `_nginx_logs"`).Scan(&count); err != nil {
+		t.Fatalf("count rows: %v", err)
+	}
+	return count
+}
+
+func TestScanArchiveFileImportsOnce(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "access.log.2.gz")
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write([]byte(testLogLine() + "\n" + testLogLine() + "\n"))
+	writer.Close()
+	if err := os.WriteFile(archivePath, buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+
+	repo := newTestRepository(t, "site")
+	parser := &LogParser{repo: repo, states: make(map[string]LogScanState)}
+
+	result := EmptyParserResult("site", "site")
+	parser.scanSingleFile("site", archivePath, &result)
+	if !result.Success || result.TotalEntries != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	// logrotate 重命名后内容不变，不应再次导入
+	rotatedPath := filepath.Join(dir, "access.log.3.gz")
+	if err := os.Rename(archivePath, rotatedPath); err != nil {
+		t.Fatalf("rename archive: %v", err)
+	}
+	parser.scanSingleFile("site", rotatedPath, &result)
+	parser.scanSingleFile("site", rotatedPath, &result)
+
+	if count := countTestRows(t, repo, "site"); count != 2 {
+		t.Fatalf("expected archive to be imported once, got %d rows", count)
+	}
+	if archive := parser.states["site"].Archives; len(archive) != 1 {
+		t.Fatalf("unexpected archive state: %+v", archive)
+	}
+}
+
+func TestScanArchiveFileResumesInterruptedImport(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "access.log.2.gz")
+	first, second := testLogLine()+"\n", strings.Replace(testLogLine(), "/hello", "/second", 1)+"\n"
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write([]byte(first + second))
+	writer.Close()
+	if err := os.WriteFile(archivePath, buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	file, _ := os.Open(archivePath)
+	contentHash, err := hashFileContent(file)
+	file.Close()
+	if err != nil {
+		t.Fatalf("hash archive: %v", err)
+	}
+
+	// 上次导入第一行后中断
+	repo := newTestRepository(t, "site")
+	if err := repo.saveScanStates(map[string]LogScanState{"site": {
+		Archives: map[string]ArchiveState{contentHash: {Path: archivePath, Imported: int64(len(first))}},
+	}}); err != nil {
+		t.Fatalf("save scan state: %v", err)
+	}
+	parser := &LogParser{repo: repo}
+	parser.loadState()
+
+	result := EmptyParserResult("site", "site")
+	parser.scanSingleFile("site", archivePath, &result)
+	var url string
+	if err := repo.db.QueryRow(`SELECT url FROM "site_nginx_logs_v"`).Scan(&url); err != nil || url != "/second world" {
+		t.Fatalf("expected only the second line to be imported, got %q (%v)", url, err)
+	}
+	if archive := parser.states["site"].Archives[contentHash]; archive.Imported != 0 || countTestRows(t, repo, "site") != 1 {
+		t.Fatalf("expected the archive to be complete: %+v", archive)
+	}
+}
+
+func TestScanFollowsRenamedFile(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+	appendLines := func(path string, count int) {
+		t.Helper()
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatalf("open log file: %v", err)
+		}
+		defer file.Close()
+		for i := 0; i < count; i++ {
+			if _, err := file.WriteString(testLogLine() + "\n"); err != nil {
+				t.Fatalf("write log file: %v", err)
+			}
+		}
+	}
+
+	repo := newTestRepository(t, "site")
+	parser := &LogParser{repo: repo, states: make(map[string]LogScanState)}
+	result := EmptyParserResult("site", "site")
+
+	appendLines(logPath, 2)
+	parser.scanSingleFile("site", logPath, &result)
+
+	// 轮转前又写入了一行，轮转后新文件很快超过旧文件大小
+	appendLines(logPath, 1)
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatalf("rotate log file: %v", err)
+	}
+	appendLines(logPath, 5)
+
+	parser.scanSingleFile("site", logPath, &result)
+	if !result.Success {
+		t.Fatalf("scan failed: %v", result.Error)
+	}
+	if count := countTestRows(t, repo, "site"); count != 8 {
+		t.Fatalf("expected 8 rows after rotation, got %d", count)
+	}
+
+	// 使用 glob 时轮转后的旧文件会再次被匹配到，不应重复导入
+	parser.scanSingleFile("site", logPath+".1", &result)
+	parser.scanSingleFile("site", logPath, &result)
+	if count := countTestRows(t, repo, "site"); count != 8 {
+		t.Fatalf("expected rescans to be idempotent, got %d rows", count)
+	}
+	// 旧文件随后被压缩，已导入的内容不应再次导入
+	content, err := os.ReadFile(logPath + ".1")
+	if err != nil {
+		t.Fatalf("read rotated file: %v", err)
+	}
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write(content)
+	writer.Close()
+	if err := os.WriteFile(logPath+".2.gz", buffer.Bytes(), 0644); err != nil {
+		t.Fatalf("write archive: %v", err)
+	}
+	os.Remove(logPath + ".1")
+
+	parser.scanSingleFile("site", logPath+".2.gz", &result)
+	if count := countTestRows(t, repo, "site"); count != 8 {
+		t.Fatalf("expected compressed rotated file to be skipped, got %d rows", count)
+	}
+}
+
+func TestScanLeavesPartialLineForNextRound(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	line := testLogLine()
+	if err := os.WriteFile(logPath, []byte(line+"\n"+line[:20]), 0644); err != nil {
+		t.Fatalf("write log file: %v", err)
+	}
+
+	repo := newTestRepository(t, "site")
+	parser := &LogParser{repo: repo, states: make(map[string]LogScanState)}
+	result := EmptyParserResult("site", "site")
+	parser.scanSingleFile("site", logPath, &result)
+	if result.SkippedEntries != 0 || countTestRows(t, repo, "site") != 1 {
+		t.Fatalf("expected only the complete line to be parsed: %+v", result)
+	}
+
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("open log file: %v", err)
+	}
+	file.WriteString(line[20:] + "\n")
+	file.Close()
+
+	parser.scanSingleFile("site", logPath, &result)
+	if result.SkippedEntries != 0 || countTestRows(t, repo, "site") != 2 {
+		t.Fatalf("expected the completed line to be parsed: %+v", result)
+	}
+}
+
+func TestParseJSONLogFormats(t *testing.T) {
+	now := time.Now()
+	parser := &LogParser{}
+
+	caddy, err := compileJSONLogFormat("caddy", nil)
+	if err != nil {
+		t.Fatalf("compileJSONLogFormat returned an error: %v", err)
+	}
+	caddyLine := fmt.Sprintf(`{"level":"info","ts":%d.25,"logger":"http.log.access","request":{"remote_ip":"192.0.2.1",`+
+		`"proto":"HTTP/2.0","method":"GET","host":"example.com","uri":"/docs?page=2",`+
+		`"headers":{"User-Agent":["NixVisTest/1.0"],"Referer":["https://example.org/"]}},"duration":0.01,"size":512,"status":404}`,
+		now.Unix())
+	record, err := parser.parseLogRecord(caddy, caddyLine, time.Time{})
+	if err != nil {
+		t.Fatalf("parse caddy line: %v", err)
+	}
+	if record.IP != "192.0.2.1" || record.Url != "/docs?page=2" || record.Status != 404 ||
+		record.BytesSent != 512 || record.Referer != "https://example.org/" || record.Timestamp.Unix() != now.Unix() {
+		t.Fatalf("unexpected caddy record: %+v", record)
+	}
+	if record.Extra["host"] != "example.com" {
+		t.Fatalf("unexpected caddy extra fields: %v", record.Extra)
+	}
+
+	nginx, err := compileJSONLogFormat("json", map[string]string{"remote_addr": "client"})
+	if err != nil {
+		t.Fatalf("compileJSONLogFormat returned an error: %v", err)
+	}
+	nginxLine := fmt.Sprintf(`{"client":"198.51.100.7","time_iso8601":"%s","request":"POST /api/login HTTP/1.1",`+
+		`"status":"200","body_bytes_sent":"17","http_referer":"","http_user_agent":"curl/8.0","request_time":"0.003"}`,
+		now.Format(time.RFC3339))
+	record, err = parser.parseLogRecord(nginx, nginxLine, time.Time{})
+	if err != nil {
+		t.Fatalf("parse nginx json line: %v", err)
+	}
+	if record.IP != "198.51.100.7" || record.Method != "POST" || record.Url != "/api/login" ||
+		record.RequestTime == nil || *record.RequestTime != 0.003 {
+		t.Fatalf("unexpected nginx json record: %+v", record)
+	}
+
+	if _, err := compileJSONLogFormat("unknown", nil); err == nil {
+		t.Fatal("expected unknown preset to be rejected")
+	}
+}
+
+func TestParseApacheLogFormats(t *testing.T) {
+	parser := &LogParser{}
+	combined, err := compileApacheLogFormats(ApacheCombinedDurationLogFormat, ApacheCombinedLogFormat, ApacheCommonLogFormat)
+	if err != nil {
+		t.Fatalf("compileApacheLogFormats returned an error: %v", err)
+	}
+
+	record, err := parser.parseLogRecord(combined, testLogLine()+" 2500", time.Time{})
+	if err != nil {
+		t.Fatalf("parse combined line with duration: %v", err)
+	}
+	if record.Url != "/hello world" || record.RequestTime == nil || *record.RequestTime != 0.0025 {
+		t.Fatalf("unexpected apache record: %+v", record)
+	}
+
+	common := strings.TrimSuffix(testLogLine(), ` "-" "NixVisTest/1.0"`)
+	record, err = parser.parseLogRecord(combined, common, time.Time{})
+	if err != nil {
+		t.Fatalf("parse common line: %v", err)
+	}
+	if record.Status != 200 || record.BytesSent != 123 {
+		t.Fatalf("unexpected common record: %+v", record)
+	}
+
+	if _, err := compileApacheLogFormat(`%h %{%Y}t`); err == nil {
+		t.Fatal("expected custom time format to be rejected")
+	}
+}
+
+func TestScanW3CLogResumesWithFieldsDirective(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	parser := &LogParser{
+		repo:    repo,
+		states:  make(map[string]LogScanState),
+		formats: map[string]LineParserFactory{"site": func() LineParser { return newW3CLogParser() }},
+	}
+
+	now := time.Now().UTC()
+	line := func(path string) string {
+		return now.Format("2006-01-02 15:04:05") + " 192.0.2.10 GET " + path +
+			" - 200 Mozilla/5.0+(Windows+NT+10.0) 15\n"
+	}
+	logPath := filepath.Join(t.TempDir(), "u_ex.log")
+	header := "#Software: Microsoft Internet Information Services 10.0\n" +
+		"#Fields: date time c-ip cs-method cs-uri-stem cs-uri-query sc-status cs(User-Agent) time-taken\n"
+	if err := os.WriteFile(logPath, []byte(header+line("/first")), 0644); err != nil {
+		t.Fatalf("write log file: %v", err)
+	}
+
+	var result ParserResult
+	parser.scanSingleFile("site", logPath, &result)
+
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open log file: %v", err)
+	}
+	file.WriteString(line("/second"))
+	file.Close()
+	parser.scanSingleFile("site", logPath, &result)
+
+	if result.SkippedEntries != 0 || countTestRows(t, repo, "site") != 2 {
+		t.Fatalf("expected both W3C lines to be parsed: %+v", result)
+	}
+	fields := "#Fields: date time c-ip cs-method cs-uri-stem cs-uri-query sc-status cs(User-Agent) time-taken"
+	if header := parser.siteState("site").Files[logPath].Header; len(header) != 1 || header[0] != fields {
+		t.Fatalf("expected #Fields directive to be saved in scan state, got %q", header)
+	}
+}
+
+// countingReaderAt 记录读取的字节数
+type countingReaderAt struct {
+	io.ReaderAt
+	read int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	r.read += n
+	return n, err
+}
+
+func TestNewFileLineParserSkipsScannedPrefix(t *testing.T) {
+	content := "#Fields: date time c-ip cs-method cs-uri-stem sc-status\n" +
+		"2024-01-01 00:00:00 192.0.2.10 GET / 200\n"
+	line := "2024-01-01 00:00:01 192.0.2.10 GET /next 200"
+	parser := &LogParser{formats: map[string]LineParserFactory{
+		"nginx": func() LineParser { return defaultNginxLogFormat },
+		"w3c":   func() LineParser { return newW3CLogParser() },
+	}}
+
+	for _, test := range []struct {
+		name      string
+		websiteID string
+		header    []string
+		wantRead  bool
+	}{
+		{"nginx", "nginx", nil, false},
+		{"w3c with saved header", "w3c", []string{"#Fields: date time c-ip cs-method cs-uri-stem sc-status"}, false},
+		{"w3c from legacy state", "w3c", nil, true},
+	} {
+		file := &countingReaderAt{ReaderAt: strings.NewReader(content)}
+		lineParser, err := parser.newFileLineParser(test.websiteID, file, test.header, int64(len(content)))
+		if err != nil {
+			t.Fatalf("%s: newFileLineParser returned an error: %v", test.name, err)
+		}
+		if (file.read > 0) != test.wantRead {
+			t.Fatalf("%s: read %d bytes of the scanned prefix", test.name, file.read)
+		}
+		if test.websiteID == "w3c" {
+			if fields, err := lineParser.ParseLine(line); err != nil || fields["uri"] != "/next" {
+				t.Fatalf("%s: unexpected fields %v (%v)", test.name, fields, err)
+			}
+		}
+	}
+}
+
+func TestParseLogLinesRoutesByHost(t *testing.T) {
+	repo := newTestRepository(t, "aaaa")
+	for _, id := range []string{"bbbb", "cccc"} {
+		if err := repo.createWebsiteTables(id); err != nil {
+			t.Fatalf("create tables: %v", err)
+		}
+	}
+
+	format, err := compileNginxLogFormat(DefaultNginxLogFormat + ` $host`)
+	if err != nil {
+		t.Fatalf("compileNginxLogFormat returned an error: %v", err)
+	}
+	route := &logRoute{
+		key:       "route-test",
+		exact:     map[string]string{"example.com": "aaaa"},
+		wildcards: []hostPattern{{pattern: "*.example.com", websiteID: "bbbb"}},
+		catchAll:  "cccc",
+	}
+	parser := &LogParser{repo: repo, routes: map[string]*logRoute{route.key: route}}
+
+	var lines strings.Builder
+	for _, host := range []string{"Example.com:443", "blog.example.com", "api.example.com", "other.org"} {
+		lines.WriteString(testLogLine() + " " + host + "\n")
+	}
+
+	result := ParserResult{}
+	if entries := parser.parseLogLines(strings.NewReader(lines.String()), route.key, format, &result, nil); entries != 4 {
+		t.Fatalf("expected 4 routed entries, got %d (%+v)", entries, result)
+	}
+	for id, expected := range map[string]int{"aaaa": 1, "bbbb": 2, "cccc": 1} {
+		if count := countTestRows(t, repo, id); count != expected || result.routed[id] != expected {
+			t.Fatalf("website %s: expected %d rows, got %d (routed %v)", id, expected, count, result.routed)
+		}
+	}
+
+	route.catchAll = ""
+	result = ParserResult{}
+	parser.parseLogLines(strings.NewReader(testLogLine()+" other.org\n"), route.key, format, &result, nil)
+	if result.SkippedEntries != 1 {
+		t.Fatalf("expected unmatched host to be skipped without a catch-all site: %+v", result)
+	}
+}
+
+func TestRealIPFromTrustedProxy(t *testing.T) {
+	factory, err := newLineParserFactory(util.WebsiteConfig{
+		LogFormat:      DefaultNginxLogFormat + ` "$http_x_forwarded_for"`,
+		RealIPHeader:   "X-Forwarded-For",
+		TrustedProxies: []string{"127.0.0.0/8", "10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("newLineParserFactory returned an error: %v", err)
+	}
+
+	parser := &LogParser{}
+	record, err := parser.parseLogRecord(factory(), testLogLine()+` "198.51.100.1, 203.0.113.9, 10.0.0.1"`, time.Time{})
+	if err != nil {
+		t.Fatalf("parseLogRecord returned an error: %v", err)
+	}
+	if record.IP != "203.0.113.9" || record.Extra["realip_remote_addr"] != "127.0.0.1" {
+		t.Fatalf("expected the first untrusted address, got %q (%v)", record.IP, record.Extra)
+	}
+
+	// 非受信任代理直接访问时不能伪造请求头
+	line := strings.Replace(testLogLine(), "127.0.0.1", "192.0.2.7", 1) + ` "203.0.113.9"`
+	if record, err = parser.parseLogRecord(factory(), line, time.Time{}); err != nil || record.IP != "192.0.2.7" {
+		t.Fatalf("expected untrusted remote address to be kept, got %+v (%v)", record, err)
+	}
+}
+
+func TestScanSourceImportsArchivesConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "access.log"), []byte(testLogLine()+"\n"), 0644); err != nil {
+		t.Fatalf("write log file: %v", err)
+	}
+	for i := 1; i <= 4; i++ {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		for j := 0; j < i; j++ {
+			line := strings.Replace(testLogLine(), "/hello", fmt.Sprintf("/archive-%d", i), 1)
+			writer.Write([]byte(line + "\n"))
+		}
+		writer.Close()
+		archivePath := filepath.Join(dir, fmt.Sprintf("access.log.%d.gz", i))
+		if err := os.WriteFile(archivePath, buffer.Bytes(), 0644); err != nil {
+			t.Fatalf("write archive: %v", err)
+		}
+	}
+
+	repo := newTestRepository(t, "site")
+	parser := &LogParser{repo: repo, states: make(map[string]LogScanState)}
+	result := parser.scanSource("site", filepath.Join(dir, "access.log*"),
+		EmptyParserResult("site", "site"), make(chan struct{}, 2))
+
+	if !result.Success || result.TotalEntries != 11 || countTestRows(t, repo, "site") != 11 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if archives := parser.states["site"].Archives; len(archives) != 4 {
+		t.Fatalf("expected 4 archive states, got %+v", archives)
+	}
+}
+
+func TestImportFilesIsIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	var content strings.Builder
+	for days := 90; days >= 50; days -= 10 {
+		timestamp := time.Now().AddDate(0, 0, -days).Format("02/Jan/2006:15:04:05 -0700")
+		content.WriteString(`192.0.2.1 - - [` + timestamp + `] "GET / HTTP/1.1" 200 5 "-" "NixVisTest/1.0"` + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "access.log.9"), []byte(content.String()), 0644); err != nil {
+		t.Fatalf("write log file: %v", err)
+	}
+
+	repo := newTestRepository(t, "site")
+	if err := repo.createImportTables(); err != nil {
+		t.Fatalf("create import tables: %v", err)
+	}
+	parser := &LogParser{repo: repo, states: make(map[string]LogScanState)}
+
+	from := time.Now().AddDate(0, 0, -75)
+	result, err := parser.ImportFiles("site", []string{dir}, ImportOptions{From: from})
+	if err != nil || result.Entries != 3 {
+		t.Fatalf("expected 3 entries newer than the lower bound, got %+v (%v)", result, err)
+	}
+
+	// 重复执行不会重复导入，扩大范围时只导入新增的部分
+	if result, err = parser.ImportFiles("site", []string{dir}, ImportOptions{From: from}); err != nil || result.SkippedFiles != 1 {
+		t.Fatalf("expected the file to be skipped, got %+v (%v)", result, err)
+	}
+	if result, err = parser.ImportFiles("site", []string{dir}, ImportOptions{}); err != nil || result.Entries != 2 {
+		t.Fatalf("expected only the 2 older entries, got %+v (%v)", result, err)
+	}
+	if count := countTestRows(t, repo, "site"); count != 5 {
+		t.Fatalf("expected 5 rows, got %d", count)
+	}
+}
+
+func TestDailyStatsSurviveRawLogCleanup(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	today := startOfDay(time.Now())
+	logs := []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: today.AddDate(0, 0, -3).Add(time.Hour), Url: "/", Status: 200, BytesSent: 10},
+		{IP: "192.0.2.2", PageviewFlag: 1, Timestamp: today.AddDate(0, 0, -3).Add(2 * time.Hour), Url: "/", Status: 200, BytesSent: 20},
+		{IP: "192.0.2.1", PageviewFlag: 0, Timestamp: today.AddDate(0, 0, -3).Add(3 * time.Hour), Url: "/a.css", Status: 200, BytesSent: 30},
+		{IP: "192.0.2.3", PageviewFlag: 1, Timestamp: today.Add(time.Hour), Url: "/", Status: 200, BytesSent: 40},
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	// 汇总后原始日志被清理，汇总数据仍然可以查询
+	if deleted, err := repo.rollupDailyStats("site", today); err != nil || deleted != 3 {
+		t.Fatalf("rollup: deleted %d (%v)", deleted, err)
+	}
+	if err := repo.pruneDictionary("site"); err != nil {
+		t.Fatalf("prune dictionary: %v", err)
+	}
+	var unused int
+	repo.db.QueryRow(`SELECT COUNT(*) FROM "site_dictionary" WHERE value = '/a.css'`).Scan(&unused)
+	if unused != 0 {
+		t.Fatalf("expected unreferenced values to be pruned")
+	}
+
+	days, err := repo.QueryDailyStats("site", today.AddDate(0, 0, -3), today.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("query daily stats: %v", err)
+	}
+	if len(days) != 4 {
+		t.Fatalf("expected 4 days, got %+v", days)
+	}
+	if first := days[0]; first.PV != 2 || first.UV != 2 || first.Requests != 3 || first.BytesSent != 60 {
+		t.Fatalf("unexpected rolled up day: %+v", first)
+	}
+	if days[1].Requests != 0 || days[3].PV != 1 || days[3].BytesSent != 40 {
+		t.Fatalf("unexpected daily stats: %+v", days)
+	}
+}
+
+func TestHourlyStatsFollowInserts(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	hour := startOfHour(time.Now().Add(-2 * time.Hour))
+	logs := []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: hour.Add(time.Minute), Url: "/", Status: 200, BytesSent: 10, Referer: "https://example.org/a"},
+		{IP: "192.0.2.2", PageviewFlag: 1, Timestamp: hour.Add(2 * time.Minute), Url: "/about", Status: 200, BytesSent: 20},
+		{IP: "192.0.2.1", PageviewFlag: 0, Timestamp: hour.Add(3 * time.Minute), Url: "/a.css", Status: 404, BytesSent: 30},
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs[:2]); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+	// 第二批落在同一小时，与已有的汇总合并
+	more := append(logs[2:], NginxLogRecord{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: hour.Add(4 * time.Minute), Url: "/", Status: 200, BytesSent: 40})
+	if err := repo.BatchInsertLogsForWebsite("site", more); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	hours, err := repo.QueryHourlyStats("site", hour, hour.Add(time.Hour))
+	if err != nil || len(hours) != 1 {
+		t.Fatalf("expected one hour, got %+v (%v)", hours, err)
+	}
+	stats := hours[0]
+	if stats.PV != 3 || stats.Visitors.Count() != 2 || stats.Requests != 4 ||
+		stats.BytesSent != 100 || stats.PVBytesSent != 70 || stats.Status2xx != 3 || stats.Status4xx != 1 {
+		t.Fatalf("unexpected hourly stats: %+v", stats)
+	}
+
+	urls, err := repo.QueryDimensionStats("site", "url", hour, hour.Add(time.Hour))
+	if err != nil || len(urls) != 2 || urls["/"].PV != 2 || urls["/"].Visitors.Count() != 1 {
+		t.Fatalf("unexpected url stats: %+v (%v)", urls, err)
+	}
+
+	// 清空汇总后重新生成，结果与写入时维护的一致
+	if _, err := repo.db.Exec(`DELETE FROM "site_hourly_stats"; DELETE FROM "site_hourly_dimensions"`); err != nil {
+		t.Fatalf("clear hourly stats: %v", err)
+	}
+	if err := repo.backfillHourlyStats("site", LogsView("site")); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	if hours, _ := repo.QueryHourlyStats("site", hour, hour.Add(time.Hour)); len(hours) != 1 || hours[0].PV != 3 || hours[0].Visitors.Count() != 2 {
+		t.Fatalf("unexpected backfilled stats: %+v", hours)
+	}
+}
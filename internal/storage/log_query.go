@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// logRecordColumns 从日志视图读取完整日志记录的列，顺序与 scanLogRecord 一致
+const logRecordColumns = `id, ip, pageview_flag, timestamp, method, url, status_code, bytes_sent, referer,
+            user_browser, user_os, user_device, domestic_location, global_location,
+            extra, request_time, upstream_response_time`
+
+// logSortFields 日志分页查询允许的排序字段
+var logSortFields = map[string]bool{
+	"timestamp": true, "ip": true, "url": true,
+	"status_code": true, "bytes_sent": true, "request_time": true,
+}
+
+// LogQuery 分页查询原始日志的条件
+type LogQuery struct {
+	Page      int    // 从 1 开始
+	PageSize  int    // 每页条数
+	SortField string // timestamp、ip、url、status_code、bytes_sent 或 request_time
+	SortOrder string // asc 或 desc
+	Filter    string // 按 URL、IP、来源或国内地区模糊匹配，为空时不过滤
+}
+
+// QueryLogs 分页查询原始日志，返回当前页的日志和符合条件的总数
+func (r *Repository) QueryLogs(websiteID string, query LogQuery) ([]NginxLogRecord, int, error) {
+	if !logSortFields[query.SortField] {
+		return nil, 0, fmt.Errorf("不支持按 %s 排序", query.SortField)
+	}
+	if query.SortOrder != "asc" && query.SortOrder != "desc" {
+		return nil, 0, fmt.Errorf("排序方向 %s 无效", query.SortOrder)
+	}
+
+	var where string
+	var args []any
+	if query.Filter != "" {
+		like := r.db.dialect.CaseInsensitiveLike()
+		where = fmt.Sprintf(" WHERE url %[1]s ? OR ip %[1]s ? OR referer %[1]s ? OR domestic_location %[1]s ?", like)
+		filterArg := "%" + query.Filter + "%"
+		args = append(args, filterArg, filterArg, filterArg, filterArg)
+	}
+
+	var total int
+	if err := r.db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"%s`, LogsView(websiteID), where),
+		args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("获取日志总数失败: %v", err)
+	}
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT %s
+        FROM "%s"%s
+        ORDER BY %s %s
+        LIMIT ? OFFSET ?`, logRecordColumns, LogsView(websiteID), where, query.SortField, query.SortOrder),
+		append(args, query.PageSize, (query.Page-1)*query.PageSize)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询日志失败: %v", err)
+	}
+	defer rows.Close()
+
+	logs := make([]NginxLogRecord, 0)
+	for rows.Next() {
+		log, err := scanLogRecord(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, total, rows.Err()
+}
+
+// scanLogRecord 读取按 logRecordColumns 查询的一行日志
+func scanLogRecord(rows *sql.Rows) (NginxLogRecord, error) {
+	var log NginxLogRecord
+	var timestamp int64
+	var extra string
+	var requestTime, upstreamResponseTime sql.NullFloat64
+	if err := rows.Scan(&log.ID, &log.IP, &log.PageviewFlag, &timestamp, &log.Method, &log.Url,
+		&log.Status, &log.BytesSent, &log.Referer, &log.UserBrowser, &log.UserOs,
+		&log.UserDevice, &log.DomesticLocation, &log.GlobalLocation,
+		&extra, &requestTime, &upstreamResponseTime); err != nil {
+		return log, fmt.Errorf("解析日志行失败: %v", err)
+	}
+
+	log.Timestamp = time.Unix(timestamp, 0)
+	if requestTime.Valid {
+		log.RequestTime = &requestTime.Float64
+	}
+	if upstreamResponseTime.Valid {
+		log.UpstreamResponseTime = &upstreamResponseTime.Float64
+	}
+	if extra != "" {
+		if err := json.Unmarshal([]byte(extra), &log.Extra); err != nil {
+			return log, fmt.Errorf("解析日志扩展字段失败: %v", err)
+		}
+	}
+	return log, nil
+}
+
+// 请求耗时直方图的桶：0 号桶为不超过 1 毫秒的请求，第 k 个桶为 (1ms × 1.1^(k-1), 1ms × 1.1^k]，
+// 按桶的上限估算分位数，相对误差不超过 10%
+const (
+	latencyBucketBase   = 0.001
+	latencyBucketGrowth = 1.1
+)
+
+// LatencyHistogram 请求耗时直方图，桶序号 -> 请求数
+type LatencyHistogram map[int]int
+
+// LatencyBucketUpperBound 返回桶的耗时上限，单位秒
+func LatencyBucketUpperBound(bucket int) float64 {
+	return latencyBucketBase * math.Pow(latencyBucketGrowth, float64(bucket))
+}
+
+// Merge 累加另一个直方图
+func (h LatencyHistogram) Merge(other LatencyHistogram) {
+	for bucket, count := range other {
+		h[bucket] += count
+	}
+}
+
+// latencyBucketSQL 返回将耗时列转换为桶序号的表达式
+func latencyBucketSQL(column string) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s <= %[2]g THEN 0 ELSE CAST(ceil(ln(%[1]s / %[2]g) / ln(%[3]g)) AS INTEGER) END`,
+		column, latencyBucketBase, latencyBucketGrowth)
+}
+
+// URLLatencyHistograms 单个 URL 的请求耗时和上游响应耗时直方图
+type URLLatencyHistograms struct {
+	Request  LatencyHistogram
+	Upstream LatencyHistogram // 日志未记录上游耗时时为空
+}
+
+// QueryURLLatency 按 URL 统计 [start, end) 范围内记录了耗时的请求的耗时直方图
+//
+// 在数据库中按桶计数，返回的行数只与 URL 数和桶数有关，与请求数无关。
+func (r *Repository) QueryURLLatency(websiteID string, start, end time.Time) (map[string]*URLLatencyHistograms, error) {
+	result := make(map[string]*URLLatencyHistograms)
+	for _, column := range []string{"request_time", "upstream_response_time"} {
+		rows, err := r.db.Query(fmt.Sprintf(`
+            SELECT d.value, h.bucket, h.requests
+            FROM (
+                SELECT url_id, %[3]s AS bucket, COUNT(*) AS requests
+                FROM "%[1]s_nginx_logs" %[2]s
+                WHERE timestamp >= ? AND timestamp < ? AND %[4]s IS NOT NULL
+                GROUP BY url_id, bucket
+            ) h
+            JOIN "%[1]s_dictionary" d ON d.id = h.url_id`,
+			websiteID, r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp"), latencyBucketSQL(column), column),
+			start.Unix(), end.Unix())
+		if err != nil {
+			return nil, fmt.Errorf("查询请求耗时失败: %v", err)
+		}
+
+		for rows.Next() {
+			var url string
+			var bucket, requests int
+			if err := rows.Scan(&url, &bucket, &requests); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("解析请求耗时失败: %v", err)
+			}
+			item, ok := result[url]
+			if !ok {
+				item = &URLLatencyHistograms{Request: make(LatencyHistogram)}
+				result[url] = item
+			}
+			if column == "request_time" {
+				item.Request[bucket] += requests
+				continue
+			}
+			if item.Upstream == nil {
+				item.Upstream = make(LatencyHistogram)
+			}
+			item.Upstream[bucket] += requests
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("遍历请求耗时失败: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// LatencyPeriod 一个 15 分钟时段内的请求耗时直方图
+type LatencyPeriod struct {
+	Time      time.Time // 时段的开始时间
+	Histogram LatencyHistogram
+}
+
+// QueryLatencyPeriods 按 15 分钟时段统计 [start, end) 范围内的请求耗时直方图，按时间排序
+func (r *Repository) QueryLatencyPeriods(websiteID string, start, end time.Time) ([]LatencyPeriod, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT timestamp - timestamp %% 900 AS period, %[3]s AS bucket, COUNT(*)
+        FROM "%[1]s_nginx_logs" %[2]s
+        WHERE timestamp >= ? AND timestamp < ? AND request_time IS NOT NULL
+        GROUP BY period, bucket
+        ORDER BY period`,
+		websiteID, r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp"), latencyBucketSQL("request_time")),
+		start.Unix(), end.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("查询请求耗时失败: %v", err)
+	}
+	defer rows.Close()
+
+	var result []LatencyPeriod
+	for rows.Next() {
+		var period int64
+		var bucket, requests int
+		if err := rows.Scan(&period, &bucket, &requests); err != nil {
+			return nil, fmt.Errorf("解析请求耗时失败: %v", err)
+		}
+		if len(result) == 0 || result[len(result)-1].Time.Unix() != period {
+			result = append(result, LatencyPeriod{Time: time.Unix(period, 0), Histogram: make(LatencyHistogram)})
+		}
+		result[len(result)-1].Histogram[bucket] += requests
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("遍历请求耗时失败: %v", err)
+	}
+	return result, nil
+}
+
+// StatusCount 一个时段内某个状态码的请求数
+type StatusCount struct {
+	Time   time.Time // 所在 15 分钟时段的开始时间
+	Status int
+	Count  int
+}
+
+// QueryStatusCounts 按 15 分钟时段统计 [start, end) 范围内各状态码的请求数，包括不计入 PV 的请求
+//
+// 各时区与 UTC 的偏移都是 15 分钟的整数倍，时段可以准确归入本地的小时和天。
+func (r *Repository) QueryStatusCounts(websiteID string, start, end time.Time) ([]StatusCount, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT timestamp - timestamp %% 900 AS period, status_code, COUNT(*)
+        FROM "%s_nginx_logs" %s
+        WHERE timestamp >= ? AND timestamp < ?
+        GROUP BY period, status_code
+        ORDER BY period, status_code`,
+		websiteID, r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp")),
+		start.Unix(), end.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("查询状态码统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	var result []StatusCount
+	for rows.Next() {
+		var item StatusCount
+		var period int64
+		if err := rows.Scan(&period, &item.Status, &item.Count); err != nil {
+			return nil, fmt.Errorf("解析状态码统计失败: %v", err)
+		}
+		item.Time = time.Unix(period, 0)
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// ErrorURLCount 某个错误状态码下一个 URL 的请求数，以及请求最多的几个来源
+type ErrorURLCount struct {
+	Status   int
+	URL      string
+	Count    int
+	Referers []ErrorRefererCount // 按请求数从多到少排列
+}
+
+// ErrorRefererCount 来源及其请求数
+type ErrorRefererCount struct {
+	Referer string
+	Count   int
+}
+
+// QueryErrorURLs 统计 [start, end) 范围内状态码不低于 400 的请求，每个状态码保留请求最多的 limit 个 URL，
+// 每个 URL 保留请求最多的 refererLimit 个来源，结果按状态码和请求数排序
+//
+// 先在数据库中按状态码排名 URL，再只为排名靠前的 URL 排名来源，读取的行数与日志中不同 URL 的数量无关。
+func (r *Repository) QueryErrorURLs(websiteID string, start, end time.Time, limit, refererLimit int) ([]ErrorURLCount, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        WITH errors AS (
+            SELECT status_code, url_id, referer_id, COUNT(*) AS requests
+            FROM "%[1]s_nginx_logs" %[2]s
+            WHERE timestamp >= ? AND timestamp < ? AND status_code >= 400
+            GROUP BY status_code, url_id, referer_id
+        ), urls AS (
+            SELECT status_code, url_id, url, requests FROM (
+                SELECT e.status_code, e.url_id, u.value AS url, SUM(e.requests) AS requests,
+                    ROW_NUMBER() OVER (PARTITION BY e.status_code ORDER BY SUM(e.requests) DESC, u.value) AS url_rank
+                FROM errors e
+                JOIN "%[1]s_dictionary" u ON u.id = e.url_id
+                GROUP BY e.status_code, e.url_id, u.value
+            ) ranked
+            WHERE url_rank <= ?
+        )
+        SELECT status_code, url, url_requests, referer, requests FROM (
+            SELECT urls.status_code, urls.url, urls.requests AS url_requests, f.value AS referer, e.requests,
+                ROW_NUMBER() OVER (PARTITION BY urls.status_code, urls.url_id ORDER BY e.requests DESC, f.value) AS referer_rank
+            FROM urls
+            JOIN errors e ON e.status_code = urls.status_code AND e.url_id = urls.url_id
+            JOIN "%[1]s_dictionary" f ON f.id = e.referer_id
+        ) ranked
+        WHERE referer_rank <= ?
+        ORDER BY status_code, url_requests DESC, url, requests DESC, referer`,
+		websiteID, r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp")),
+		start.Unix(), end.Unix(), limit, refererLimit)
+	if err != nil {
+		return nil, fmt.Errorf("查询错误请求失败: %v", err)
+	}
+	defer rows.Close()
+
+	var result []ErrorURLCount
+	for rows.Next() {
+		var item ErrorURLCount
+		var referer ErrorRefererCount
+		if err := rows.Scan(&item.Status, &item.URL, &item.Count, &referer.Referer, &referer.Count); err != nil {
+			return nil, fmt.Errorf("解析错误请求失败: %v", err)
+		}
+		// 同一 URL 的来源相邻排列
+		if last := len(result) - 1; last >= 0 && result[last].Status == item.Status && result[last].URL == item.URL {
+			result[last].Referers = append(result[last].Referers, referer)
+			continue
+		}
+		item.Referers = []ErrorRefererCount{referer}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// BandwidthUsage 某个维度取值的请求数和流量
+type BandwidthUsage struct {
+	Key       string
+	Requests  int
+	BytesSent int64
+}
+
+// bandwidthDimensions 流量统计支持的维度：分组的表达式和需要关联的字典
+//
+// 旧版本导入的日志没有保存原始 User-Agent，client 维度退回使用解析出的浏览器、系统和设备。
+var bandwidthDimensions = map[string]struct {
+	key   string
+	joins []string
+}{
+	"url": {key: "url.value", joins: []string{"url"}},
+	"ip":  {key: "l.ip"},
+	"client": {
+		key:   `COALESCE(user_agent.value, user_browser.value || ' / ' || user_os.value || ' / ' || user_device.value)`,
+		joins: []string{"user_agent", "user_browser", "user_os", "user_device"},
+	},
+}
+
+// QueryBandwidth 按维度统计 [start, end) 范围内全部请求的流量，按流量从高到低排序
+//
+// dimension 为 url、ip 或 client（原始 User-Agent）；limit 不大于 0 时返回全部取值。
+func (r *Repository) QueryBandwidth(websiteID, dimension string, start, end time.Time, limit int) ([]BandwidthUsage, error) {
+	spec, ok := bandwidthDimensions[dimension]
+	if !ok {
+		return nil, fmt.Errorf("不支持按 %s 统计流量", dimension)
+	}
+	var joins strings.Builder
+	for _, column := range spec.joins {
+		fmt.Fprintf(&joins, "\n        LEFT JOIN \"%[1]s_dictionary\" %[2]s ON %[2]s.id = l.%[2]s_id", websiteID, column)
+	}
+	args := []any{start.Unix(), end.Unix()}
+	limitClause := ""
+	if limit > 0 {
+		limitClause = "\n        LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT %[3]s AS usage_key, COUNT(*), SUM(l.bytes_sent) AS bytes
+        FROM "%[1]s_nginx_logs" l %[2]s%[4]s
+        WHERE l.timestamp >= ? AND l.timestamp < ?
+        GROUP BY usage_key
+        ORDER BY bytes DESC, usage_key%[5]s`,
+		websiteID, r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp"), spec.key, joins.String(), limitClause),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询流量统计失败: %v", err)
+	}
+	defer rows.Close()
+
+	var result []BandwidthUsage
+	for rows.Next() {
+		var item BandwidthUsage
+		if err := rows.Scan(&item.Key, &item.Requests, &item.BytesSent); err != nil {
+			return nil, fmt.Errorf("解析流量统计失败: %v", err)
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+// ScanURLBandwidth 按 URL 统计 [start, end) 范围内全部请求的流量，逐条交给 fn 处理，不排序
+//
+// 供按扩展名等需要合并全部 URL 的统计使用，在数据库中按 URL 分组后逐行读取，不会一次读入内存。
+func (r *Repository) ScanURLBandwidth(websiteID string, start, end time.Time, fn func(BandwidthUsage)) error {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT d.value, u.requests, u.bytes
+        FROM (
+            SELECT url_id, COUNT(*) AS requests, SUM(bytes_sent) AS bytes
+            FROM "%[1]s_nginx_logs" %[2]s
+            WHERE timestamp >= ? AND timestamp < ?
+            GROUP BY url_id
+        ) u
+        JOIN "%[1]s_dictionary" d ON d.id = u.url_id`,
+		websiteID, r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp")),
+		start.Unix(), end.Unix())
+	if err != nil {
+		return fmt.Errorf("查询 URL 流量失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item BandwidthUsage
+		if err := rows.Scan(&item.Key, &item.Requests, &item.BytesSent); err != nil {
+			return fmt.Errorf("解析 URL 流量失败: %v", err)
+		}
+		fn(item)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历 URL 流量失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryStatusCountsAndErrorRequests(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Hour)
+	logs := []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: start, Url: "/", Status: 200},
+		{IP: "192.0.2.1", Timestamp: start.Add(time.Minute), Url: "/old.css", Referer: "https://example.com/", Status: 404},
+		{IP: "192.0.2.2", Timestamp: start.Add(2 * time.Minute), Url: "/old.css", Referer: "https://example.com/", Status: 404},
+		{IP: "192.0.2.2", Timestamp: start.Add(3 * time.Minute), Url: "/gone", Status: 404},
+		{IP: "192.0.2.2", Timestamp: start.Add(20 * time.Minute), Url: "/old.css", Referer: "https://other.example/", Status: 404},
+		{IP: "192.0.2.3", Timestamp: start.Add(20 * time.Minute), Url: "/api", Status: 502},
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	counts, err := repo.QueryStatusCounts("site", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("query status counts: %v", err)
+	}
+	expectedCounts := []StatusCount{
+		{Time: start, Status: 200, Count: 1},
+		{Time: start, Status: 404, Count: 3},
+		{Time: start.Add(15 * time.Minute), Status: 404, Count: 1},
+		{Time: start.Add(15 * time.Minute), Status: 502, Count: 1},
+	}
+	if len(counts) != len(expectedCounts) {
+		t.Fatalf("unexpected status counts: %+v", counts)
+	}
+	for i, expected := range expectedCounts {
+		if !counts[i].Time.Equal(expected.Time) || counts[i].Status != expected.Status || counts[i].Count != expected.Count {
+			t.Fatalf("unexpected status count %d: %+v", i, counts[i])
+		}
+	}
+
+	errorURLs, err := repo.QueryErrorURLs("site", start, start.Add(time.Hour), 2, 5)
+	if err != nil {
+		t.Fatalf("query error urls: %v", err)
+	}
+	expected := []ErrorURLCount{
+		{Status: 404, URL: "/old.css", Count: 3, Referers: []ErrorRefererCount{
+			{Referer: "https://example.com/", Count: 2}, {Referer: "https://other.example/", Count: 1}}},
+		{Status: 404, URL: "/gone", Count: 1, Referers: []ErrorRefererCount{{Count: 1}}},
+		{Status: 502, URL: "/api", Count: 1, Referers: []ErrorRefererCount{{Count: 1}}},
+	}
+	if !reflect.DeepEqual(errorURLs, expected) {
+		t.Fatalf("unexpected error urls: %+v", errorURLs)
+	}
+
+	// 每个状态码只保留请求最多的 URL，每个 URL 只保留请求最多的来源
+	if errorURLs, err = repo.QueryErrorURLs("site", start, start.Add(time.Hour), 1, 1); err != nil {
+		t.Fatalf("query limited error urls: %v", err)
+	}
+	expected = []ErrorURLCount{
+		{Status: 404, URL: "/old.css", Count: 3, Referers: []ErrorRefererCount{{Referer: "https://example.com/", Count: 2}}},
+		{Status: 502, URL: "/api", Count: 1, Referers: []ErrorRefererCount{{Count: 1}}},
+	}
+	if !reflect.DeepEqual(errorURLs, expected) {
+		t.Fatalf("unexpected limited error urls: %+v", errorURLs)
+	}
+}
+
+func TestQueryBandwidthCoversAllRequests(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Hour)
+	logs := []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: start, Url: "/", Status: 200, BytesSent: 100,
+			UserBrowser: "Chrome", UserOs: "Windows", UserDevice: "桌面设备", UserAgent: "Chrome/120"},
+		{IP: "192.0.2.1", Timestamp: start.Add(time.Minute), Url: "/video.mp4", Status: 206, BytesSent: 5000,
+			UserBrowser: "Chrome", UserOs: "Windows", UserDevice: "桌面设备", UserAgent: "Chrome/120"},
+		// 解析结果相同但原始 User-Agent 不同，按原始 User-Agent 分开统计
+		{IP: "192.0.2.2", Timestamp: start.Add(time.Minute), Url: "/video.mp4", Status: 206, BytesSent: 3000,
+			UserBrowser: "Chrome", UserOs: "Windows", UserDevice: "桌面设备", UserAgent: "Chrome/121"},
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	expected := map[string][]BandwidthUsage{
+		"url":    {{Key: "/video.mp4", Requests: 2, BytesSent: 8000}, {Key: "/", Requests: 1, BytesSent: 100}},
+		"ip":     {{Key: "192.0.2.1", Requests: 2, BytesSent: 5100}},
+		"client": {{Key: "Chrome/120", Requests: 2, BytesSent: 5100}, {Key: "Chrome/121", Requests: 1, BytesSent: 3000}},
+	}
+	limits := map[string]int{"url": 0, "ip": 1, "client": 2}
+	for dimension, want := range expected {
+		usages, err := repo.QueryBandwidth("site", dimension, start, start.Add(time.Hour), limits[dimension])
+		if err != nil {
+			t.Fatalf("query bandwidth by %s: %v", dimension, err)
+		}
+		if !reflect.DeepEqual(usages, want) {
+			t.Fatalf("unexpected bandwidth by %s: %+v", dimension, usages)
+		}
+	}
+	if _, err := repo.QueryBandwidth("site", "referer", start, start.Add(time.Hour), 0); err == nil {
+		t.Fatal("expected unsupported dimension to fail")
+	}
+
+	urls := make(map[string]BandwidthUsage)
+	if err := repo.ScanURLBandwidth("site", start, start.Add(time.Hour), func(usage BandwidthUsage) {
+		urls[usage.Key] = usage
+	}); err != nil {
+		t.Fatalf("scan url bandwidth: %v", err)
+	}
+	if len(urls) != 2 || urls["/video.mp4"].BytesSent != 8000 || urls["/"].Requests != 1 {
+		t.Fatalf("unexpected url bandwidth: %+v", urls)
+	}
+
+	// 旧版本导入的日志没有原始 User-Agent，按解析结果统计
+	if _, err := repo.db.Exec(`UPDATE site_nginx_logs SET user_agent_id = NULL`); err != nil {
+		t.Fatalf("clear user agents: %v", err)
+	}
+	usages, err := repo.QueryBandwidth("site", "client", start, start.Add(time.Hour), 1)
+	if err != nil || !reflect.DeepEqual(usages, []BandwidthUsage{{Key: "Chrome / Windows / 桌面设备", Requests: 3, BytesSent: 8100}}) {
+		t.Fatalf("unexpected legacy client bandwidth: %+v (%v)", usages, err)
+	}
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// DailyStats 按天汇总的访问统计，原始日志清理后仍会保留
+type DailyStats struct {
+	Date      string `json:"date"` // 本地日期，如 2006-01-02
+	PV        int    `json:"pv"`
+	UV        int    `json:"uv"`
+	Requests  int    `json:"requests"`
+	BytesSent int64  `json:"bytes_sent"`
+}
+
+// createDailyStatsTable 创建网站的按天统计表
+func (r *Repository) createDailyStatsTable(id string) error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS "%s_daily_stats" (
+            date TEXT PRIMARY KEY,
+            pv INTEGER NOT NULL,
+            uv INTEGER NOT NULL,
+            requests INTEGER NOT NULL,
+            bytes_sent BIGINT NOT NULL,
+            finalized INTEGER NOT NULL DEFAULT 0
+        )`, id))
+	return err
+}
+
+// finalizeDailyStats 将原始日志已清理的天数标记为已定稿，供旧版本升级时使用
+//
+// 旧版本每次清理都会删除 cutoff 之前的原始日志，最早一条原始日志之前的天数都已清理。
+func (r *Repository) finalizeDailyStats(websiteID string) error {
+	var first sql.NullInt64
+	if err := r.db.QueryRow(fmt.Sprintf(`SELECT MIN(timestamp) FROM "%s_nginx_logs"`, websiteID)).Scan(&first); err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`UPDATE "%s_daily_stats" SET finalized = 1`, websiteID)
+	if !first.Valid {
+		_, err := r.db.Exec(query)
+		return err
+	}
+	_, err := r.db.Exec(query+` WHERE date < ?`, time.Unix(first.Int64, 0).In(time.Local).Format("2006-01-02"))
+	return err
+}
+
+// rollupDailyStats 将今天之前的完整天数汇总到按天统计表，并在同一事务中删除 cutoff 之前的原始日志，
+// 返回删除的行数
+//
+// 原始日志按天对齐清理，删除某天的原始日志时将该天标记为已定稿（finalized）。未定稿的天数
+// 原始日志是完整的，重新汇总时覆盖已有结果；已定稿的天数只剩清理后导入的历史日志，累加到
+// 已有结果上。累加、标记与删除在同一事务中完成，同一条日志只会累加一次。UV 无法精确合并，
+// 累加后为上限。
+func (r *Repository) rollupDailyStats(websiteID string, cutoff time.Time) (int64, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rollup := fmt.Sprintf(`
+        INSERT INTO "%[1]s_daily_stats" (date, pv, uv, requests, bytes_sent)
+        SELECT
+            %[2]s AS day,
+            SUM(pageview_flag),
+            COUNT(DISTINCT CASE WHEN pageview_flag = 1 THEN ip END),
+            COUNT(*),
+            SUM(bytes_sent)
+        FROM "%[1]s_nginx_logs"
+        WHERE timestamp >= ? AND timestamp < ?
+        GROUP BY day
+        ON CONFLICT (date) DO UPDATE SET `, websiteID, r.db.dialect.LocalDate("timestamp"))
+
+	// 保留期内的天数覆盖汇总，保留天数调大后已定稿的天数不再覆盖
+	if _, err := tx.Exec(rollup+fmt.Sprintf(`pv = excluded.pv, uv = excluded.uv,
+            requests = excluded.requests, bytes_sent = excluded.bytes_sent
+        WHERE "%s_daily_stats".finalized = 0`, websiteID),
+		cutoff.Unix(), startOfDay(time.Now()).Unix()); err != nil {
+		return 0, err
+	}
+
+	// 即将删除的天数：未定稿时原始日志完整，覆盖汇总；已定稿时累加清理后导入的日志
+	if _, err := tx.Exec(rollup+fmt.Sprintf(`
+            pv = CASE WHEN "%[1]s_daily_stats".finalized = 1 THEN "%[1]s_daily_stats".pv + excluded.pv ELSE excluded.pv END,
+            uv = CASE WHEN "%[1]s_daily_stats".finalized = 1 THEN "%[1]s_daily_stats".uv + excluded.uv ELSE excluded.uv END,
+            requests = CASE WHEN "%[1]s_daily_stats".finalized = 1
+                THEN "%[1]s_daily_stats".requests + excluded.requests ELSE excluded.requests END,
+            bytes_sent = CASE WHEN "%[1]s_daily_stats".finalized = 1
+                THEN "%[1]s_daily_stats".bytes_sent + excluded.bytes_sent ELSE excluded.bytes_sent END`, websiteID),
+		int64(math.MinInt64), cutoff.Unix()); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`UPDATE "%s_daily_stats" SET finalized = 1 WHERE date < ? AND finalized = 0`, websiteID),
+		cutoff.Format("2006-01-02")); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s_nginx_logs" WHERE timestamp < ?`, websiteID), cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, tx.Commit()
+}
+
+// pruneDailyStats 删除超过保留天数的按天统计
+func (r *Repository) pruneDailyStats(websiteID string, retentionDays int) error {
+	cutoffDate := time.Now().AddDate(0, 0, -retentionDays).Format("2006-01-02")
+	_, err := r.db.Exec(
+		fmt.Sprintf(`DELETE FROM "%s_daily_stats" WHERE date < ?`, websiteID), cutoffDate)
+	return err
+}
+
+// QueryDailyStats 查询 [start, end) 范围内每天的统计，尚未汇总的天数直接从原始日志计算
+func (r *Repository) QueryDailyStats(websiteID string, start, end time.Time) ([]DailyStats, error) {
+	start, end = startOfDay(start), startOfDay(end)
+	days := make(map[string]DailyStats)
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT date, pv, uv, requests, bytes_sent FROM "%s_daily_stats"
+        WHERE date >= ? AND date < ?`, websiteID),
+		start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	if err := scanDailyStats(rows, days); err != nil {
+		return nil, err
+	}
+
+	// 最近的几天还没有汇总，直接从原始日志计算
+	rows, err = r.db.Query(fmt.Sprintf(`
+        SELECT
+            %[2]s AS day,
+            SUM(pageview_flag),
+            COUNT(DISTINCT CASE WHEN pageview_flag = 1 THEN ip END),
+            COUNT(*),
+            SUM(bytes_sent)
+        FROM "%[1]s_nginx_logs" %[3]s
+        WHERE timestamp >= ? AND timestamp < ?
+        GROUP BY day`,
+		websiteID, r.db.dialect.LocalDate("timestamp"), r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp")),
+		max(start.Unix(), r.lastRollupEnd(websiteID)), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if err := scanDailyStats(rows, days); err != nil {
+		return nil, err
+	}
+
+	result := make([]DailyStats, 0, len(days))
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		stats, ok := days[date]
+		if !ok {
+			stats = DailyStats{Date: date}
+		}
+		result = append(result, stats)
+	}
+	return result, nil
+}
+
+// lastRollupEnd 返回最后一个已汇总日期的次日零点，没有汇总数据时返回 0
+func (r *Repository) lastRollupEnd(websiteID string) int64 {
+	var lastDate sql.NullString
+	r.db.QueryRow(fmt.Sprintf(`SELECT MAX(date) FROM "%s_daily_stats"`, websiteID)).Scan(&lastDate)
+	if !lastDate.Valid {
+		return 0
+	}
+	day, err := time.ParseInLocation("2006-01-02", lastDate.String, time.Local)
+	if err != nil {
+		return 0
+	}
+	return day.AddDate(0, 0, 1).Unix()
+}
+
+// scanDailyStats 读取按天统计的查询结果
+func scanDailyStats(rows *sql.Rows, days map[string]DailyStats) error {
+	defer rows.Close()
+	for rows.Next() {
+		var stats DailyStats
+		var bytesSent sql.NullInt64
+		if err := rows.Scan(&stats.Date, &stats.PV, &stats.UV, &stats.Requests, &bytesSent); err != nil {
+			return err
+		}
+		stats.BytesSent = bytesSent.Int64
+		days[stats.Date] = stats
+	}
+	return rows.Err()
+}
+
+// startOfDay 返回本地时间当天零点
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.In(time.Local).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
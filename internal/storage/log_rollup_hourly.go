@@ -0,0 +1,429 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RollupDimensions 按小时汇总的维度，只统计计入 PV 的请求
+var RollupDimensions = []string{
+	"url", "referer", "user_browser", "user_os", "user_device",
+	"domestic_location", "global_location",
+}
+
+// HourlyStats 按小时汇总的访问统计，写入日志时同步更新
+type HourlyStats struct {
+	Hour        time.Time // 本地时间的整点
+	PV          int
+	Requests    int
+	BytesSent   int64 // 全部请求的流量
+	PVBytesSent int64 // 计入 PV 的请求的流量
+	Status2xx   int
+	Status3xx   int
+	Status4xx   int
+	Status5xx   int
+	Visitors    *UVSketch // 计入 PV 的请求的独立 IP
+}
+
+// DimensionStats 某个维度取值在一段时间内的汇总
+type DimensionStats struct {
+	PV       int
+	Visitors *UVSketch
+}
+
+// dimensionKey 按小时汇总的维度行的主键
+type dimensionKey struct {
+	hour      int64
+	dimension string
+	value     string
+}
+
+// createHourlyStatsTables 创建网站的按小时统计表，维度的取值以字典 ID 保存
+func (r *Repository) createHourlyStatsTables(id string) error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS "%[1]s_hourly_stats" (
+            hour BIGINT PRIMARY KEY,
+            pv INTEGER NOT NULL,
+            requests INTEGER NOT NULL,
+            bytes_sent BIGINT NOT NULL,
+            pv_bytes_sent BIGINT NOT NULL,
+            status_2xx INTEGER NOT NULL,
+            status_3xx INTEGER NOT NULL,
+            status_4xx INTEGER NOT NULL,
+            status_5xx INTEGER NOT NULL,
+            uv_sketch %[2]s NOT NULL
+        );
+        CREATE TABLE IF NOT EXISTS "%[1]s_hourly_dimensions" (%[3]s) %[4]s;`,
+		id, r.db.dialect.BlobType(), r.hourlyDimensionsColumns(), r.db.dialect.TableOptions()))
+	return err
+}
+
+// hourlyDimensionsColumns 按小时维度表的列
+func (r *Repository) hourlyDimensionsColumns() string {
+	return fmt.Sprintf(`
+            dimension TEXT NOT NULL,
+            hour BIGINT NOT NULL,
+            value_id BIGINT NOT NULL,
+            pv INTEGER NOT NULL,
+            uv_sketch %s NOT NULL,
+            PRIMARY KEY (dimension, hour, value_id)
+        `, r.db.dialect.BlobType())
+}
+
+// convertHourlyDimensions 将旧版直接保存字符串的按小时维度表转换为字典编码，在同一事务中完成
+func (r *Repository) convertHourlyDimensions(websiteID string) error {
+	table := websiteID + "_hourly_dimensions"
+	legacy, err := r.hasColumn(table, "value")
+	if err != nil || !legacy {
+		return err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		// 日志清理后字典中可能已没有对应的字符串
+		fmt.Sprintf(`
+            INSERT INTO "%[1]s_dictionary" (value)
+            SELECT DISTINCT h.value FROM "%[2]s" h
+            WHERE NOT EXISTS (SELECT 1 FROM "%[1]s_dictionary" d WHERE d.value = h.value)`, websiteID, table),
+		fmt.Sprintf(`CREATE TABLE "%s_converting" (%s) %s`,
+			table, r.hourlyDimensionsColumns(), r.db.dialect.TableOptions()),
+		fmt.Sprintf(`
+            INSERT INTO "%[2]s_converting" (dimension, hour, value_id, pv, uv_sketch)
+            SELECT h.dimension, h.hour, d.id, h.pv, h.uv_sketch
+            FROM "%[2]s" h JOIN "%[1]s_dictionary" d ON d.value = h.value`, websiteID, table),
+		fmt.Sprintf(`DROP TABLE "%s"`, table),
+		fmt.Sprintf(`ALTER TABLE "%[1]s_converting" RENAME TO "%[1]s"`, table),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// startOfHour 返回本地时间的整点，半小时时区也按本地整点分组
+func startOfHour(t time.Time) time.Time {
+	year, month, day := t.In(time.Local).Date()
+	return time.Date(year, month, day, t.In(time.Local).Hour(), 0, 0, 0, time.Local)
+}
+
+// collectHourlyStats 将一批日志按小时和维度汇总
+func collectHourlyStats(logs []NginxLogRecord) (map[int64]*HourlyStats, map[dimensionKey]*DimensionStats) {
+	hours := make(map[int64]*HourlyStats)
+	dimensions := make(map[dimensionKey]*DimensionStats)
+
+	for i := range logs {
+		log := &logs[i]
+		hour := startOfHour(log.Timestamp)
+		stats, ok := hours[hour.Unix()]
+		if !ok {
+			stats = &HourlyStats{Hour: hour, Visitors: NewUVSketch()}
+			hours[hour.Unix()] = stats
+		}
+
+		stats.Requests++
+		stats.BytesSent += int64(log.BytesSent)
+		switch log.Status / 100 {
+		case 2:
+			stats.Status2xx++
+		case 3:
+			stats.Status3xx++
+		case 4:
+			stats.Status4xx++
+		case 5:
+			stats.Status5xx++
+		}
+		if log.PageviewFlag != 1 {
+			continue
+		}
+		stats.PV++
+		stats.PVBytesSent += int64(log.BytesSent)
+		stats.Visitors.Add(log.IP)
+
+		values := []string{
+			log.Url, log.Referer, log.UserBrowser, log.UserOs, log.UserDevice,
+			log.DomesticLocation, log.GlobalLocation,
+		}
+		for j, dimension := range RollupDimensions {
+			value := values[j]
+			if dimension == "referer" && (value == "" || value == "-") {
+				continue
+			}
+			key := dimensionKey{hour: hour.Unix(), dimension: dimension, value: value}
+			item, ok := dimensions[key]
+			if !ok {
+				item = &DimensionStats{Visitors: NewUVSketch()}
+				dimensions[key] = item
+			}
+			item.PV++
+			item.Visitors.Add(log.IP)
+		}
+	}
+	return hours, dimensions
+}
+
+// writeHourlyStats 将一批日志的汇总合并到按小时统计表，与日志写入在同一事务中
+func writeHourlyStats(tx *transaction, websiteID string, logs []NginxLogRecord, dictionary *dictionaryWriter) error {
+	hours, dimensions := collectHourlyStats(logs)
+
+	selectHour, err := tx.Prepare(fmt.Sprintf(`
+        SELECT pv, requests, bytes_sent, pv_bytes_sent,
+            status_2xx, status_3xx, status_4xx, status_5xx, uv_sketch
+        FROM "%s_hourly_stats" WHERE hour = ?`, websiteID))
+	if err != nil {
+		return err
+	}
+	defer selectHour.Close()
+	upsertHour, err := tx.Prepare(upsertSQL(websiteID+"_hourly_stats", []string{"hour"},
+		[]string{"pv", "requests", "bytes_sent", "pv_bytes_sent",
+			"status_2xx", "status_3xx", "status_4xx", "status_5xx", "uv_sketch"}))
+	if err != nil {
+		return err
+	}
+	defer upsertHour.Close()
+
+	for hour, stats := range hours {
+		var existing HourlyStats
+		var sketch []byte
+		err := selectHour.QueryRow(hour).Scan(&existing.PV, &existing.Requests,
+			&existing.BytesSent, &existing.PVBytesSent, &existing.Status2xx,
+			&existing.Status3xx, &existing.Status4xx, &existing.Status5xx, &sketch)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		default:
+			if existing.Visitors, err = decodeUVSketch(sketch); err != nil {
+				return err
+			}
+			stats.add(&existing)
+		}
+
+		if _, err := upsertHour.Exec(hour, stats.PV, stats.Requests, stats.BytesSent,
+			stats.PVBytesSent, stats.Status2xx, stats.Status3xx, stats.Status4xx,
+			stats.Status5xx, stats.Visitors.encode()); err != nil {
+			return err
+		}
+	}
+
+	selectDimension, err := tx.Prepare(fmt.Sprintf(`
+        SELECT pv, uv_sketch FROM "%s_hourly_dimensions"
+        WHERE dimension = ? AND hour = ? AND value_id = ?`, websiteID))
+	if err != nil {
+		return err
+	}
+	defer selectDimension.Close()
+	upsertDimension, err := tx.Prepare(upsertSQL(websiteID+"_hourly_dimensions",
+		[]string{"dimension", "hour", "value_id"}, []string{"pv", "uv_sketch"}))
+	if err != nil {
+		return err
+	}
+	defer upsertDimension.Close()
+
+	for key, stats := range dimensions {
+		valueID, err := dictionary.id(key.value)
+		if err != nil {
+			return err
+		}
+
+		var pv int
+		var sketch []byte
+		err = selectDimension.QueryRow(key.dimension, key.hour, valueID).Scan(&pv, &sketch)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		default:
+			visitors, err := decodeUVSketch(sketch)
+			if err != nil {
+				return err
+			}
+			stats.PV += pv
+			stats.Visitors.Merge(visitors)
+		}
+
+		if _, err := upsertDimension.Exec(key.dimension, key.hour, valueID,
+			stats.PV, stats.Visitors.encode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add 累加另一段时间的统计
+func (s *HourlyStats) add(other *HourlyStats) {
+	s.PV += other.PV
+	s.Requests += other.Requests
+	s.BytesSent += other.BytesSent
+	s.PVBytesSent += other.PVBytesSent
+	s.Status2xx += other.Status2xx
+	s.Status3xx += other.Status3xx
+	s.Status4xx += other.Status4xx
+	s.Status5xx += other.Status5xx
+	s.Visitors.Merge(other.Visitors)
+}
+
+// backfillHourlyStats 根据 source 表或视图中已有的日志生成按小时统计，由数据库迁移调用
+func (r *Repository) backfillHourlyStats(websiteID, source string) error {
+	var hasStats, hasLogs bool
+	r.db.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s_hourly_stats")`, websiteID)).Scan(&hasStats)
+	r.db.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s")`, source)).Scan(&hasLogs)
+	if hasStats || !hasLogs {
+		return nil
+	}
+
+	logrus.Infof("正在为网站 %s 生成按小时统计，日志较多时需要一些时间", websiteID)
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	const batchSize = 10000
+	lastID := int64(0)
+	for {
+		// 按批读取后再写入，避免单连接时读写相互等待
+		rows, err := r.db.Query(fmt.Sprintf(`
+            SELECT id, ip, pageview_flag, timestamp, url, status_code, bytes_sent, referer,
+                user_browser, user_os, user_device, domestic_location, global_location
+            FROM "%s" WHERE id > ? ORDER BY id LIMIT ?`, source),
+			lastID, batchSize)
+		if err != nil {
+			return err
+		}
+
+		logs := make([]NginxLogRecord, 0, batchSize)
+		for rows.Next() {
+			var log NginxLogRecord
+			var timestamp int64
+			if err := rows.Scan(&log.ID, &log.IP, &log.PageviewFlag, &timestamp, &log.Url,
+				&log.Status, &log.BytesSent, &log.Referer, &log.UserBrowser, &log.UserOs,
+				&log.UserDevice, &log.DomesticLocation, &log.GlobalLocation); err != nil {
+				rows.Close()
+				return err
+			}
+			log.Timestamp = time.Unix(timestamp, 0)
+			logs = append(logs, log)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		lastID = logs[len(logs)-1].ID
+
+		if err := r.writeBackfilledHourlyStats(websiteID, logs); err != nil {
+			return err
+		}
+	}
+}
+
+// writeBackfilledHourlyStats 在一个事务中写入一批已有日志的按小时统计
+func (r *Repository) writeBackfilledHourlyStats(websiteID string, logs []NginxLogRecord) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dictionary, err := newDictionaryWriter(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer dictionary.Close()
+
+	if err := writeHourlyStats(tx, websiteID, logs, dictionary); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// pruneHourlyStats 删除 cutoff 之前的按小时统计，与原始日志同步清理
+func (r *Repository) pruneHourlyStats(websiteID string, cutoff time.Time) error {
+	for _, table := range []string{"hourly_stats", "hourly_dimensions"} {
+		if _, err := r.db.Exec(fmt.Sprintf(`DELETE FROM "%s_%s" WHERE hour < ?`, websiteID, table),
+			cutoff.Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryHourlyStats 查询 [start, end) 范围内每小时的统计，没有访问的小时不返回
+func (r *Repository) QueryHourlyStats(websiteID string, start, end time.Time) ([]HourlyStats, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT hour, pv, requests, bytes_sent, pv_bytes_sent,
+            status_2xx, status_3xx, status_4xx, status_5xx, uv_sketch
+        FROM "%s_hourly_stats"
+        WHERE hour >= ? AND hour < ?
+        ORDER BY hour`, websiteID), start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []HourlyStats
+	for rows.Next() {
+		var stats HourlyStats
+		var hour int64
+		var sketch []byte
+		if err := rows.Scan(&hour, &stats.PV, &stats.Requests, &stats.BytesSent,
+			&stats.PVBytesSent, &stats.Status2xx, &stats.Status3xx, &stats.Status4xx,
+			&stats.Status5xx, &sketch); err != nil {
+			return nil, err
+		}
+		if stats.Visitors, err = decodeUVSketch(sketch); err != nil {
+			return nil, err
+		}
+		stats.Hour = time.Unix(hour, 0)
+		result = append(result, stats)
+	}
+	return result, rows.Err()
+}
+
+// QueryDimensionStats 查询 [start, end) 范围内某个维度各取值的 PV 和访客
+func (r *Repository) QueryDimensionStats(
+	websiteID, dimension string, start, end time.Time) (map[string]*DimensionStats, error) {
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT d.value, h.pv, h.uv_sketch
+        FROM "%[1]s_hourly_dimensions" h
+        JOIN "%[1]s_dictionary" d ON d.id = h.value_id
+        WHERE h.dimension = ? AND h.hour >= ? AND h.hour < ?`, websiteID),
+		dimension, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]*DimensionStats)
+	for rows.Next() {
+		var value string
+		var pv int
+		var sketch []byte
+		if err := rows.Scan(&value, &pv, &sketch); err != nil {
+			return nil, err
+		}
+		visitors, err := decodeUVSketch(sketch)
+		if err != nil {
+			return nil, err
+		}
+
+		item, ok := result[value]
+		if !ok {
+			result[value] = &DimensionStats{PV: pv, Visitors: visitors}
+			continue
+		}
+		item.PV += pv
+		item.Visitors.Merge(visitors)
+	}
+	return result, rows.Err()
+}
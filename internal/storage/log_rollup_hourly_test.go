@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConvertHourlyDimensionsToDictionary(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	hour := startOfHour(time.Now().Add(-time.Hour))
+	if err := repo.BatchInsertLogsForWebsite("site", []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: hour.Add(time.Minute), Url: "/", Status: 200},
+	}); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	// 旧版维度表直接保存字符串，其中 /old 的日志已被清理，字典中没有对应的值
+	sketch := NewUVSketch()
+	sketch.Add("192.0.2.1")
+	if _, err := repo.db.Exec(`
+        DROP TABLE "site_hourly_dimensions";
+        CREATE TABLE "site_hourly_dimensions" (
+            dimension TEXT NOT NULL, hour BIGINT NOT NULL, value TEXT NOT NULL,
+            pv INTEGER NOT NULL, uv_sketch BLOB NOT NULL,
+            PRIMARY KEY (dimension, hour, value)) WITHOUT ROWID`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	for _, value := range []string{"/", "/old"} {
+		if _, err := repo.db.Exec(`INSERT INTO "site_hourly_dimensions" VALUES ('url', ?, ?, 2, ?)`,
+			hour.Unix(), value, sketch.encode()); err != nil {
+			t.Fatalf("insert legacy row: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := repo.convertHourlyDimensions("site"); err != nil {
+			t.Fatalf("convert: %v", err)
+		}
+	}
+	if legacy, err := repo.hasColumn("site_hourly_dimensions", "value"); err != nil || legacy {
+		t.Fatalf("expected the value column to be replaced (%v)", err)
+	}
+
+	urls, err := repo.QueryDimensionStats("site", "url", hour, hour.Add(time.Hour))
+	if err != nil || len(urls) != 2 || urls["/"].PV != 2 || urls["/old"].Visitors.Count() != 1 {
+		t.Fatalf("unexpected url stats: %+v (%v)", urls, err)
+	}
+
+	// 新写入的日志合并到转换后的行
+	if err := repo.BatchInsertLogsForWebsite("site", []NginxLogRecord{
+		{IP: "192.0.2.2", PageviewFlag: 1, Timestamp: hour.Add(2 * time.Minute), Url: "/old", Status: 200},
+	}); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+	if urls, _ = repo.QueryDimensionStats("site", "url", hour, hour.Add(time.Hour)); urls["/old"].PV != 3 {
+		t.Fatalf("expected the new pageview to be merged, got %+v", urls["/old"])
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollupMergesLogsImportedAfterCleanup(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	today := startOfDay(time.Now())
+	cleaned := today.AddDate(0, 0, -10)
+	logs := []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: cleaned.Add(time.Hour), Url: "/", Status: 200, BytesSent: 10},
+		{IP: "192.0.2.2", PageviewFlag: 1, Timestamp: cleaned.Add(2 * time.Hour), Url: "/", Status: 200, BytesSent: 20},
+		{IP: "192.0.2.3", PageviewFlag: 1, Timestamp: today.AddDate(0, 0, -1).Add(time.Hour), Url: "/", Status: 200, BytesSent: 40},
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	cutoff := today.AddDate(0, 0, -7)
+	if deleted, err := repo.rollupDailyStats("site", cutoff); err != nil || deleted != 2 {
+		t.Fatalf("rollup: deleted %d (%v)", deleted, err)
+	}
+
+	// 清理后又导入了同一天的少量日志，汇总时累加而不是覆盖
+	imported := []NginxLogRecord{
+		{IP: "192.0.2.4", PageviewFlag: 1, Timestamp: cleaned.Add(3 * time.Hour), Url: "/", Status: 200, BytesSent: 5},
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", imported); err != nil {
+		t.Fatalf("insert imported logs: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := repo.rollupDailyStats("site", cutoff); err != nil {
+			t.Fatalf("rollup again: %v", err)
+		}
+	}
+
+	days, err := repo.QueryDailyStats("site", cleaned, today)
+	if err != nil {
+		t.Fatalf("query daily stats: %v", err)
+	}
+	want := DailyStats{Date: cleaned.Format("2006-01-02"), PV: 3, UV: 3, Requests: 3, BytesSent: 35}
+	if days[0] != want {
+		t.Fatalf("expected the imported logs to be merged, got %+v", days[0])
+	}
+	if last := days[len(days)-1]; last.PV != 1 || last.BytesSent != 40 {
+		t.Fatalf("expected days inside retention to be rolled up, got %+v", last)
+	}
+}
+
+func TestRollupWithAdvancingCutoffKeepsTotals(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	today := startOfDay(time.Now())
+	var logs []NginxLogRecord
+	for days := 1; days <= 5; days++ {
+		logs = append(logs, NginxLogRecord{
+			IP: "192.0.2.1", PageviewFlag: 1, Timestamp: today.AddDate(0, 0, -days).Add(time.Hour),
+			Url: "/", Status: 200, BytesSent: days,
+		})
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	totals := func() DailyStats {
+		t.Helper()
+		days, err := repo.QueryDailyStats("site", today.AddDate(0, 0, -5), today)
+		if err != nil {
+			t.Fatalf("query daily stats: %v", err)
+		}
+		var total DailyStats
+		for _, day := range days {
+			total.PV += day.PV
+			total.UV += day.UV
+			total.Requests += day.Requests
+			total.BytesSent += day.BytesSent
+		}
+		return total
+	}
+
+	want := DailyStats{PV: 5, UV: 5, Requests: 5, BytesSent: 15}
+	// 每次清理向后推进一天，之前覆盖汇总过的天数删除时不能再累加一次
+	for days := 4; days >= 0; days-- {
+		if _, err := repo.rollupDailyStats("site", today.AddDate(0, 0, -days)); err != nil {
+			t.Fatalf("rollup with cutoff -%d: %v", days, err)
+		}
+		if total := totals(); total != want {
+			t.Fatalf("expected totals to be unchanged after cutoff -%d, got %+v", days, total)
+		}
+	}
+	if count := countTestRows(t, repo, "site"); count != 0 {
+		t.Fatalf("expected all raw logs to be deleted, got %d", count)
+	}
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	fingerprintSize  = 1024 // 参与内容指纹计算的文件头字节数
+	maxRotatedStates = 16   // 最多保留的已轮转文件状态数
+)
+
+// readFileState 读取文件当前的身份信息：设备号、inode 和文件头指纹
+func readFileState(file *os.File, info os.FileInfo) (FileState, error) {
+	device, inode := fileIdentity(info)
+	fingerprint, size, err := fingerprintFile(file, info.Size(), fingerprintSize)
+	if err != nil {
+		return FileState{}, err
+	}
+
+	return FileState{
+		LastOffset:      info.Size(),
+		LastSize:        info.Size(),
+		Device:          device,
+		Inode:           inode,
+		Fingerprint:     fingerprint,
+		FingerprintSize: size,
+	}, nil
+}
+
+// fingerprintFile 计算文件前 limit 字节（不足时为全部内容）的哈希
+func fingerprintFile(file io.ReaderAt, fileSize, limit int64) (string, int64, error) {
+	size := min(fileSize, limit)
+	if size <= 0 {
+		return "", 0, nil
+	}
+
+	head := make([]byte, size)
+	if _, err := file.ReadAt(head, 0); err != nil && err != io.EOF {
+		return "", 0, err
+	}
+	return fingerprintBytes(head), size, nil
+}
+
+func fingerprintBytes(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// isSameFile 判断记录的状态与当前打开的文件是否为同一个文件
+func isSameFile(recorded, current FileState, file io.ReaderAt) bool {
+	if recorded.Inode != 0 && current.Inode != 0 &&
+		(recorded.Inode != current.Inode || recorded.Device != current.Device) {
+		return false
+	}
+
+	// 旧版本的状态没有指纹，只能依据文件大小判断
+	if recorded.Fingerprint == "" {
+		if recorded.Inode != 0 && recorded.Inode == current.Inode {
+			return current.LastSize >= recorded.LastOffset
+		}
+		return current.LastSize >= recorded.LastSize
+	}
+
+	if current.LastSize < recorded.FingerprintSize {
+		return false
+	}
+	if recorded.FingerprintSize == current.FingerprintSize {
+		return recorded.Fingerprint == current.Fingerprint
+	}
+
+	fingerprint, _, err := fingerprintFile(file, current.LastSize, recorded.FingerprintSize)
+	return err == nil && fingerprint == recorded.Fingerprint
+}
+
+// determineStartOffset 确定扫描起始位置
+//
+// 已记录的文件 inode 或文件头指纹发生变化，或文件被截断时视为已轮转：
+// 旧状态转入 Rotated，并尝试找到被重命名的旧文件读完剩余内容，当前文件从头开始扫描。
+// 未记录的文件若与某个已轮转的状态匹配（如 access.log → access.log.1），则从原进度继续。
+// 同时返回读取到该位置时保存的文件头指令。
+func (p *LogParser) determineStartOffset(
+	websiteID string, filePath string, file *os.File,
+	current FileState, parserResult *ParserResult) (int64, []string) {
+
+	state := p.siteState(websiteID)
+
+	recorded, ok := state.Files[filePath]
+	if ok {
+		if isSameFile(recorded, current, file) && current.LastSize >= recorded.LastOffset {
+			return recorded.LastOffset, recorded.Header
+		}
+
+		logrus.Infof("检测到网站 %s 的日志文件 %s 已被轮转，从头开始扫描", websiteID, filePath)
+		delete(state.Files, filePath)
+		recorded.Path = filePath
+		p.retireFileState(websiteID, recorded)
+		p.drainRotatedFiles(websiteID, filePath, parserResult)
+	}
+
+	if index := p.findRotatedState(websiteID, current, file); index >= 0 {
+		rotated := p.takeRotatedState(websiteID, index)
+		logrus.Infof("网站 %s 的日志文件 %s 由 %s 轮转而来，从偏移 %d 继续扫描",
+			websiteID, filePath, rotated.Path, rotated.LastOffset)
+		return rotated.LastOffset, rotated.Header
+	}
+
+	return 0, nil
+}
+
+// retireFileState 将不再对应原路径的文件状态转入 Rotated
+func (p *LogParser) retireFileState(websiteID string, fileState FileState) {
+	state := p.siteState(websiteID)
+	state.Rotated = append(state.Rotated, fileState)
+	if len(state.Rotated) > maxRotatedStates {
+		state.Rotated = state.Rotated[len(state.Rotated)-maxRotatedStates:]
+	}
+	p.storeSiteState(websiteID, state)
+}
+
+// findRotatedState 查找与当前文件匹配的已轮转状态，返回其下标
+func (p *LogParser) findRotatedState(
+	websiteID string, current FileState, file io.ReaderAt) int {
+	state := p.siteState(websiteID)
+
+	for i := len(state.Rotated) - 1; i >= 0; i-- {
+		rotated := state.Rotated[i]
+		if rotated.Fingerprint == "" || current.LastSize < rotated.LastOffset {
+			continue
+		}
+		// 重命名轮转时 inode 不变；copytruncate 轮转时副本 inode 不同但内容相同
+		if isSameFile(rotated, FileState{
+			LastSize:        current.LastSize,
+			Fingerprint:     current.Fingerprint,
+			FingerprintSize: current.FingerprintSize,
+		}, file) {
+			return i
+		}
+	}
+	return -1
+}
+
+// takeRotatedState 取出并移除指定下标的已轮转状态
+func (p *LogParser) takeRotatedState(websiteID string, index int) FileState {
+	state := p.siteState(websiteID)
+	rotated := state.Rotated[index]
+	state.Rotated = append(state.Rotated[:index], state.Rotated[index+1:]...)
+	p.storeSiteState(websiteID, state)
+	return rotated
+}
+
+// drainRotatedFiles 在日志所在目录查找被轮转走的旧文件，读完其中未扫描的内容
+func (p *LogParser) drainRotatedFiles(
+	websiteID string, logPath string, parserResult *ParserResult) {
+	candidates, err := filepath.Glob(logPath + "?*")
+	if err != nil {
+		return
+	}
+	sort.Strings(candidates)
+
+	for _, candidate := range candidates {
+		if isCompressedLog(candidate) || strings.HasSuffix(candidate, "~") {
+			continue
+		}
+		p.drainRotatedFile(websiteID, candidate, parserResult)
+	}
+}
+
+// drainRotatedFile 若文件与某个已轮转状态匹配，则从原进度读到文件末尾
+func (p *LogParser) drainRotatedFile(
+	websiteID string, filePath string, parserResult *ParserResult) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil || fileInfo.IsDir() {
+		return
+	}
+
+	current, err := readFileState(file, fileInfo)
+	if err != nil {
+		return
+	}
+
+	index := p.findRotatedState(websiteID, current, file)
+	if index < 0 {
+		return
+	}
+
+	rotated := p.takeRotatedState(websiteID, index)
+
+	// 该路径原先记录的是更早的文件，它也已被轮转到别处
+	state := p.siteState(websiteID)
+	if previous, ok := state.Files[filePath]; ok {
+		delete(state.Files, filePath)
+		previous.Path = filePath
+		p.retireFileState(websiteID, previous)
+	}
+
+	logrus.Infof("读取网站 %s 轮转后的日志文件 %s 中剩余的内容", websiteID, filePath)
+	current.Header = rotated.Header
+	current, ok := p.scanFileRange(websiteID, filePath, file, current, rotated.LastOffset, parserResult)
+	if !ok {
+		// 已提交部分日志时进度记录在该路径下，否则放回 Rotated 留待下次读取
+		if _, committed := p.siteState(websiteID).Files[filePath]; !committed {
+			p.retireFileState(websiteID, rotated)
+		}
+		return
+	}
+	p.updateFileState(websiteID, filePath, current)
+}
+
+// retireMissingFiles 将已不存在的文件状态转入 Rotated，并清理已删除的压缩日志记录
+func (p *LogParser) retireMissingFiles(websiteID string) {
+	state, ok := p.lookupSiteState(websiteID)
+	if !ok {
+		return
+	}
+
+	for filePath, fileState := range state.Files {
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			delete(state.Files, filePath)
+			fileState.Path = filePath
+			p.retireFileState(websiteID, fileState)
+		}
+	}
+
+	p.pruneArchiveStates(websiteID)
+}
+
+// matchArchiveHead 查找文件头与压缩日志解压后内容一致的文件状态，返回已读取的偏移
+//
+// logrotate 通常先重命名再压缩，压缩前已读取的部分不应重复导入。
+func (p *LogParser) matchArchiveHead(websiteID string, head []byte) (int64, bool) {
+	state := p.siteState(websiteID)
+
+	candidates := make([]FileState, 0, len(state.Files)+len(state.Rotated))
+	for filePath, fileState := range state.Files {
+		fileState.Path = filePath
+		candidates = append(candidates, fileState)
+	}
+	candidates = append(candidates, state.Rotated...)
+
+	for _, candidate := range candidates {
+		if candidate.Fingerprint == "" || candidate.FingerprintSize > int64(len(head)) {
+			continue
+		}
+		if fingerprintBytes(head[:candidate.FingerprintSize]) == candidate.Fingerprint {
+			return candidate.LastOffset, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// logRoute 多个网站共用的日志来源，每行日志按 $host 分发到对应网站
+type logRoute struct {
+	key        string   // 扫描状态的键，由日志路径生成
+	logPath    string   // 共用的日志路径
+	websiteIDs []string // 共用该日志的网站，按ID排序
+	exact      map[string]string
+	wildcards  []hostPattern
+	catchAll   string // 未匹配任何网站时的兜底网站，为空时丢弃
+}
+
+// hostPattern 通配符形式的主机名，如 *.example.com
+type hostPattern struct {
+	pattern   string
+	websiteID string
+}
+
+// buildLogRoutes 将日志路径相同且配置了 hosts 或 catchAll 的网站合并为一个日志来源
+func buildLogRoutes() map[string]*logRoute {
+	ids := util.GetAllWebsiteIDs()
+	sort.Strings(ids)
+
+	routes := make(map[string]*logRoute)
+	for _, id := range ids {
+		website, _ := util.GetWebsiteByID(id)
+		if len(website.Hosts) == 0 && !website.CatchAll {
+			continue
+		}
+
+		logPath := filepath.Clean(website.LogPath)
+		key := logRouteKey(logPath)
+		route, ok := routes[key]
+		if !ok {
+			route = &logRoute{key: key, logPath: website.LogPath, exact: make(map[string]string)}
+			routes[key] = route
+		}
+		route.websiteIDs = append(route.websiteIDs, id)
+
+		if website.CatchAll && route.catchAll == "" {
+			route.catchAll = id
+		}
+		for _, host := range website.Hosts {
+			host = normalizeHost(host)
+			if strings.ContainsAny(host, "*?[") {
+				route.wildcards = append(route.wildcards, hostPattern{pattern: host, websiteID: id})
+			} else if _, exists := route.exact[host]; !exists {
+				route.exact[host] = id
+			}
+		}
+	}
+
+	// 同一日志的其他网站未配置 hosts 时，只能作为兜底网站
+	for _, id := range ids {
+		website, _ := util.GetWebsiteByID(id)
+		if len(website.Hosts) > 0 || website.CatchAll {
+			continue
+		}
+		if route, ok := routes[logRouteKey(filepath.Clean(website.LogPath))]; ok {
+			route.websiteIDs = append(route.websiteIDs, id)
+			if route.catchAll == "" {
+				route.catchAll = id
+			}
+		}
+	}
+
+	// 更具体的通配符优先匹配
+	for _, route := range routes {
+		sort.SliceStable(route.wildcards, func(i, j int) bool {
+			return len(route.wildcards[i].pattern) > len(route.wildcards[j].pattern)
+		})
+	}
+	return routes
+}
+
+// logRouteKey 根据日志路径生成扫描状态的键，与网站ID区分
+func logRouteKey(logPath string) string {
+	hash := sha256.Sum256([]byte(logPath))
+	return "route-" + hex.EncodeToString(hash[:4])
+}
+
+// match 返回主机名对应的网站ID，未匹配且没有兜底网站时返回空字符串
+func (r *logRoute) match(host string) string {
+	host = normalizeHost(host)
+	if id, ok := r.exact[host]; ok {
+		return id
+	}
+	for _, wildcard := range r.wildcards {
+		if matched, _ := path.Match(wildcard.pattern, host); matched {
+			return wildcard.websiteID
+		}
+	}
+	return r.catchAll
+}
+
+// normalizeHost 统一主机名的大小写，并去掉端口和末尾的点
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// recordHost 从日志记录中取出请求的主机名
+func recordHost(record *NginxLogRecord) string {
+	for _, name := range []string{"host", "http_host", "server_name"} {
+		if host := record.Extra[name]; host != "" {
+			return host
+		}
+	}
+	return ""
+}
+
+// logRoutes 获取日志来源的分发规则，按需根据配置生成
+func (p *LogParser) logRoutes() map[string]*logRoute {
+	if p.routes == nil {
+		p.routes = buildLogRoutes()
+	}
+	return p.routes
+}
+
+// routeForWebsite 返回网站所属的共用日志来源
+func (p *LogParser) routeForWebsite(websiteID string) *logRoute {
+	for _, route := range p.logRoutes() {
+		for _, id := range route.websiteIDs {
+			if id == websiteID {
+				return route
+			}
+		}
+	}
+	return nil
+}
+
+// sourceConfig 返回日志来源的配置，共用日志的网站使用第一个网站的格式配置
+func (p *LogParser) sourceConfig(sourceID string) (util.WebsiteConfig, bool) {
+	if route, ok := p.logRoutes()[sourceID]; ok {
+		return util.GetWebsiteByID(route.websiteIDs[0])
+	}
+	return util.GetWebsiteByID(sourceID)
+}
+
+// scanRoute 扫描共用的日志文件一次，并为其中每个网站生成扫描结果
+func (p *LogParser) scanRoute(route *logRoute, slots chan struct{}) map[string]ParserResult {
+	// 改为共用日志前，网站已有的扫描进度继续沿用，避免重复导入
+	p.stateMu.Lock()
+	if _, ok := p.states[route.key]; !ok {
+		for _, id := range route.websiteIDs {
+			if state, ok := p.states[id]; ok {
+				p.states[route.key] = state
+				delete(p.states, id)
+				break
+			}
+		}
+	}
+	p.stateMu.Unlock()
+
+	sourceResult := p.scanSource(route.key, route.logPath,
+		EmptyParserResult(route.logPath, route.key), slots)
+
+	results := make(map[string]ParserResult, len(route.websiteIDs))
+	for _, id := range route.websiteIDs {
+		website, _ := util.GetWebsiteByID(id)
+		result := sourceResult
+		result.WebName = website.Name
+		result.WebID = id
+		result.TotalEntries = sourceResult.routed[id]
+		result.routed = nil
+		results[id] = result
+	}
+	return results
+}
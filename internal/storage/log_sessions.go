@@ -0,0 +1,430 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// SessionSummary 一段时间内开始的访问的汇总
+type SessionSummary struct {
+	Visits    int
+	Bounces   int   // 只浏览了一个页面的访问
+	Pageviews int   // 各次访问的页面浏览数之和
+	Duration  int64 // 各次访问时长之和，单位秒
+}
+
+// session 一次访问：同一访客相邻两次页面浏览的间隔不超过会话超时时间
+//
+// 访客以 IP 加原始 User-Agent 区分，只有计入 PV 的请求参与分组。
+type session struct {
+	id        int64
+	start     int64
+	end       int64
+	pageviews int
+	entryURL  int64
+	exitURL   int64
+	dirty     bool
+}
+
+// covers 判断 timestamp 的页面浏览是否属于该访问
+func (s *session) covers(timestamp, timeout int64) bool {
+	return timestamp >= s.start-timeout && timestamp <= s.end+timeout
+}
+
+// add 将一次页面浏览计入访问，日志乱序到达时同样更新着陆页和退出页
+func (s *session) add(timestamp, urlID int64) {
+	s.pageviews++
+	if timestamp < s.start {
+		s.start, s.entryURL = timestamp, urlID
+	}
+	if timestamp >= s.end {
+		s.end, s.exitURL = timestamp, urlID
+	}
+	s.dirty = true
+}
+
+// createSessionsTable 创建网站的访问表
+func (r *Repository) createSessionsTable(id string) error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS "%[1]s_sessions" (
+            id %[2]s,
+            visitor TEXT NOT NULL,
+            start_time BIGINT NOT NULL,
+            end_time BIGINT NOT NULL,
+            pageviews INTEGER NOT NULL,
+            entry_url_id BIGINT NOT NULL,
+            exit_url_id BIGINT NOT NULL
+        );
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_sessions_visitor ON "%[1]s_sessions" (visitor, end_time);
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_sessions_start ON "%[1]s_sessions" (start_time);`,
+		id, r.db.dialect.AutoIncrementKey()))
+	return err
+}
+
+// visitorKey 会话分组使用的访客标识，userAgentID 为原始 User-Agent 的字典 ID
+func visitorKey(ip string, userAgentID int64) string {
+	return fmt.Sprintf("%s|%d", ip, userAgentID)
+}
+
+// storedVisitorKey 返回已写入日志的访客标识，旧版本导入的日志没有保存原始 User-Agent，
+// 退回使用解析出的浏览器、系统和设备的字典 ID
+func storedVisitorKey(ip string, userAgentID sql.NullInt64, browserID, osID, deviceID int64) string {
+	if userAgentID.Valid {
+		return visitorKey(ip, userAgentID.Int64)
+	}
+	return fmt.Sprintf("%s|%d|%d|%d", ip, browserID, osID, deviceID)
+}
+
+// sessionWriter 在写入日志的事务中将页面浏览归入访问
+type sessionWriter struct {
+	timeout  int64
+	find     *sql.Stmt
+	insert   *sql.Stmt
+	update   *sql.Stmt
+	visitors map[string][]*session // 本批次涉及的访问，以访客为键
+}
+
+func newSessionWriter(tx *transaction, websiteID string) (*sessionWriter, error) {
+	writer := &sessionWriter{
+		timeout:  int64(util.SessionTimeout() / time.Second),
+		visitors: make(map[string][]*session),
+	}
+
+	var err error
+	if writer.find, err = tx.Prepare(fmt.Sprintf(`
+        SELECT id, start_time, end_time, pageviews, entry_url_id, exit_url_id
+        FROM "%s_sessions"
+        WHERE visitor = ? AND end_time >= ? AND start_time <= ?
+        ORDER BY end_time DESC LIMIT 1`, websiteID)); err != nil {
+		return nil, err
+	}
+	if writer.insert, err = tx.Prepare(fmt.Sprintf(`
+        INSERT INTO "%s_sessions" (visitor, start_time, end_time, pageviews, entry_url_id, exit_url_id)
+        VALUES (?, ?, ?, 1, ?, ?) RETURNING id`, websiteID)); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	if writer.update, err = tx.Prepare(fmt.Sprintf(`
+        UPDATE "%s_sessions"
+        SET start_time = ?, end_time = ?, pageviews = ?, entry_url_id = ?, exit_url_id = ?
+        WHERE id = ?`, websiteID)); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+// assign 将访客在 timestamp 的一次页面浏览归入访问，返回访问 ID
+//
+// 先在本批次已读取的访问中查找，再查询数据库中前后相距不超过超时时间的访问，都没有时新建。
+func (w *sessionWriter) assign(visitor string, timestamp, urlID int64) (int64, error) {
+	for _, current := range w.visitors[visitor] {
+		if current.covers(timestamp, w.timeout) {
+			current.add(timestamp, urlID)
+			return current.id, nil
+		}
+	}
+
+	current := &session{}
+	err := w.find.QueryRow(visitor, timestamp-w.timeout, timestamp+w.timeout).Scan(
+		&current.id, &current.start, &current.end, &current.pageviews, &current.entryURL, &current.exitURL)
+	switch {
+	case err == sql.ErrNoRows:
+		current = &session{start: timestamp, end: timestamp, pageviews: 1, entryURL: urlID, exitURL: urlID}
+		if err := w.insert.QueryRow(visitor, timestamp, timestamp, urlID, urlID).Scan(&current.id); err != nil {
+			return 0, err
+		}
+	case err != nil:
+		return 0, err
+	default:
+		current.add(timestamp, urlID)
+	}
+
+	w.visitors[visitor] = append(w.visitors[visitor], current)
+	return current.id, nil
+}
+
+// flush 将本批次更新过的访问写回数据库
+func (w *sessionWriter) flush() error {
+	for _, sessions := range w.visitors {
+		for _, current := range sessions {
+			if !current.dirty {
+				continue
+			}
+			if _, err := w.update.Exec(current.start, current.end, current.pageviews,
+				current.entryURL, current.exitURL, current.id); err != nil {
+				return err
+			}
+			current.dirty = false
+		}
+	}
+	return nil
+}
+
+func (w *sessionWriter) Close() {
+	for _, stmt := range []*sql.Stmt{w.find, w.insert, w.update} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// backfillSessions 将已有日志中尚未分组的页面浏览归入访问，由数据库迁移调用
+//
+// 每批在一个事务中完成，中途失败时重新执行会从未分组的日志继续。
+func (r *Repository) backfillSessions(websiteID string) error {
+	var pending bool
+	if err := r.db.QueryRow(fmt.Sprintf(`
+        SELECT EXISTS (SELECT 1 FROM "%s_nginx_logs" WHERE pageview_flag = 1 AND session_id IS NULL)`,
+		websiteID)).Scan(&pending); err != nil || !pending {
+		return err
+	}
+
+	logrus.Infof("正在为网站 %s 划分访问，日志较多时需要一些时间", websiteID)
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	const batchSize = 10000
+	lastID := int64(0)
+	for {
+		// 按批读取后再写入，避免单连接时读写相互等待
+		rows, err := r.db.Query(fmt.Sprintf(`
+            SELECT id, ip, user_agent_id, user_browser_id, user_os_id, user_device_id, timestamp, url_id
+            FROM "%s_nginx_logs"
+            WHERE pageview_flag = 1 AND session_id IS NULL AND id > ?
+            ORDER BY id LIMIT ?`, websiteID), lastID, batchSize)
+		if err != nil {
+			return err
+		}
+
+		pageviews := make([]pendingPageview, 0, batchSize)
+		for rows.Next() {
+			var item pendingPageview
+			var ip string
+			var userAgentID sql.NullInt64
+			var browserID, osID, deviceID int64
+			if err := rows.Scan(&item.id, &ip, &userAgentID, &browserID, &osID, &deviceID,
+				&item.timestamp, &item.urlID); err != nil {
+				rows.Close()
+				return err
+			}
+			item.visitor = storedVisitorKey(ip, userAgentID, browserID, osID, deviceID)
+			pageviews = append(pageviews, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(pageviews) == 0 {
+			return nil
+		}
+		lastID = pageviews[len(pageviews)-1].id
+
+		if err := r.assignSessionBatch(websiteID, pageviews); err != nil {
+			return err
+		}
+	}
+}
+
+// pendingPageview 迁移时尚未归入访问的页面浏览
+type pendingPageview struct {
+	id, timestamp, urlID int64
+	visitor              string
+}
+
+// assignSessionBatch 在一个事务中将一批页面浏览归入访问，并记录到日志的 session_id
+func (r *Repository) assignSessionBatch(websiteID string, pageviews []pendingPageview) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sessions, err := newSessionWriter(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer sessions.Close()
+	update, err := tx.Prepare(fmt.Sprintf(`UPDATE "%s_nginx_logs" SET session_id = ? WHERE id = ?`, websiteID))
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+
+	for _, item := range pageviews {
+		sessionID, err := sessions.assign(item.visitor, item.timestamp, item.urlID)
+		if err != nil {
+			return err
+		}
+		if _, err := update.Exec(sessionID, item.id); err != nil {
+			return err
+		}
+	}
+	if err := sessions.flush(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// pruneSessions 删除 cutoff 之前结束的访问，与原始日志同步清理
+func (r *Repository) pruneSessions(websiteID string, cutoff time.Time) error {
+	_, err := r.db.Exec(fmt.Sprintf(`DELETE FROM "%s_sessions" WHERE end_time < ?`, websiteID), cutoff.Unix())
+	return err
+}
+
+// QuerySessionSummary 汇总 [start, end) 范围内开始的访问
+func (r *Repository) QuerySessionSummary(websiteID string, start, end time.Time) (SessionSummary, error) {
+	var summary SessionSummary
+	err := r.db.QueryRow(fmt.Sprintf(`
+        SELECT COUNT(*),
+            COALESCE(SUM(CASE WHEN pageviews = 1 THEN 1 ELSE 0 END), 0),
+            COALESCE(SUM(pageviews), 0),
+            COALESCE(SUM(end_time - start_time), 0)
+        FROM "%s_sessions"
+        WHERE start_time >= ? AND start_time < ?`, websiteID), start.Unix(), end.Unix()).Scan(
+		&summary.Visits, &summary.Bounces, &summary.Pageviews, &summary.Duration)
+	if err != nil {
+		return summary, fmt.Errorf("查询访问统计失败: %v", err)
+	}
+	return summary, nil
+}
+
+// SessionPageStats 某个页面作为着陆页和退出页的访问次数
+type SessionPageStats struct {
+	Entries int // 以该页面开始的访问
+	Exits   int // 以该页面结束的访问
+	Bounces int // 只浏览了该页面的访问
+}
+
+// QuerySessionPages 按页面统计 [start, end) 范围内开始的访问的着陆页和退出页，以 URL 为键
+func (r *Repository) QuerySessionPages(websiteID string, start, end time.Time) (map[string]*SessionPageStats, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT d.value, SUM(p.entries), SUM(p.exits), SUM(p.bounces)
+        FROM (
+            SELECT entry_url_id AS url_id, 1 AS entries, 0 AS exits,
+                CASE WHEN pageviews = 1 THEN 1 ELSE 0 END AS bounces
+            FROM "%[1]s_sessions" WHERE start_time >= ? AND start_time < ?
+            UNION ALL
+            SELECT exit_url_id, 0, 1, 0
+            FROM "%[1]s_sessions" WHERE start_time >= ? AND start_time < ?
+        ) p
+        JOIN "%[1]s_dictionary" d ON d.id = p.url_id
+        GROUP BY d.value`, websiteID),
+		start.Unix(), end.Unix(), start.Unix(), end.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("查询着陆页和退出页失败: %v", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]*SessionPageStats)
+	for rows.Next() {
+		var url string
+		var item SessionPageStats
+		if err := rows.Scan(&url, &item.Entries, &item.Exits, &item.Bounces); err != nil {
+			return nil, err
+		}
+		result[url] = &item
+	}
+	return result, rows.Err()
+}
+
+// VisitRequest 访问中的一次请求
+type VisitRequest struct {
+	SessionID int64
+	Visitor   string
+	Timestamp time.Time
+	Method    string
+	URL       string
+	Status    int
+}
+
+// ScanVisitRequests 按时间顺序读取 [start, end) 范围内开始的访问中的请求，逐条交给 fn 处理
+//
+// 访问的范围与 QuerySessionSummary 一致。页面浏览使用写入时划分的访问；其他请求（如表单提交、接口调用）
+// 从访问表中查找同一访客开始时间不晚于该请求、结束后不超过会话超时时间的访问，不属于这些访问的请求跳过。
+func (r *Repository) ScanVisitRequests(websiteID string, start, end time.Time, fn func(VisitRequest)) error {
+	type visitSpan struct {
+		id, start, end int64
+	}
+	timeout := int64(util.SessionTimeout() / time.Second)
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT id, visitor, start_time, end_time FROM "%s_sessions"
+        WHERE start_time >= ? AND start_time < ?`, websiteID), start.Unix(), end.Unix())
+	if err != nil {
+		return fmt.Errorf("查询访问失败: %v", err)
+	}
+	visits := make(map[int64]bool)
+	visitors := make(map[string][]visitSpan)
+	lastEnd := start.Unix()
+	for rows.Next() {
+		var span visitSpan
+		var visitor string
+		if err := rows.Scan(&span.id, &visitor, &span.start, &span.end); err != nil {
+			rows.Close()
+			return fmt.Errorf("解析访问失败: %v", err)
+		}
+		visits[span.id] = true
+		visitors[visitor] = append(visitors[visitor], span)
+		lastEnd = max(lastEnd, span.end)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历访问失败: %v", err)
+	}
+	if len(visits) == 0 {
+		return nil
+	}
+
+	// 访问可能持续到 end 之后，读取到最后一次访问结束后的会话超时时间
+	rows, err = r.db.Query(fmt.Sprintf(`
+        SELECT l.session_id, l.ip, l.user_agent_id, l.user_browser_id, l.user_os_id, l.user_device_id,
+            l.timestamp, l.method, d.value, l.status_code
+        FROM "%[1]s_nginx_logs" l %[2]s
+        JOIN "%[1]s_dictionary" d ON d.id = l.url_id
+        WHERE l.timestamp >= ? AND l.timestamp <= ?
+        ORDER BY l.timestamp, l.id`,
+		websiteID, r.db.dialect.IndexedBy("idx_"+websiteID+"_timestamp")),
+		start.Unix(), lastEnd+timeout)
+	if err != nil {
+		return fmt.Errorf("查询访问请求失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var request VisitRequest
+		var sessionID, userAgentID sql.NullInt64
+		var ip string
+		var browserID, osID, deviceID, timestamp int64
+		if err := rows.Scan(&sessionID, &ip, &userAgentID, &browserID, &osID, &deviceID,
+			&timestamp, &request.Method, &request.URL, &request.Status); err != nil {
+			return fmt.Errorf("解析访问请求失败: %v", err)
+		}
+		request.Visitor = storedVisitorKey(ip, userAgentID, browserID, osID, deviceID)
+		request.Timestamp = time.Unix(timestamp, 0)
+
+		if sessionID.Valid {
+			request.SessionID = sessionID.Int64
+		} else {
+			// 同一访客相邻两次访问相隔超过超时时间，最多只有一次访问覆盖该请求
+			for _, span := range visitors[request.Visitor] {
+				if timestamp >= span.start && timestamp <= span.end+timeout {
+					request.SessionID = span.id
+					break
+				}
+			}
+		}
+		if !visits[request.SessionID] {
+			continue
+		}
+		fn(request)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("遍历访问请求失败: %v", err)
+	}
+	return nil
+}
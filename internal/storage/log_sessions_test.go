@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionsGroupPageviews(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	pageview := func(ip string, offset time.Duration, url string) NginxLogRecord {
+		return NginxLogRecord{IP: ip, PageviewFlag: 1, Timestamp: start.Add(offset), Url: url, Status: 200, UserBrowser: "Chrome"}
+	}
+	logs := []NginxLogRecord{
+		pageview("192.0.2.1", 0, "/a"),
+		pageview("192.0.2.1", 10*time.Minute, "/b"),
+		{IP: "192.0.2.1", Timestamp: start.Add(time.Minute), Url: "/a.css", Status: 200, UserBrowser: "Chrome"},
+		pageview("192.0.2.1", 50*time.Minute, "/c"), // 间隔超过 30 分钟，新的访问
+		pageview("192.0.2.2", 0, "/a"),
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+	// 乱序到达的日志并入已有的访问并成为着陆页
+	if err := repo.BatchInsertLogsForWebsite("site", []NginxLogRecord{pageview("192.0.2.1", -5*time.Minute, "/landing")}); err != nil {
+		t.Fatalf("insert late log: %v", err)
+	}
+
+	expected := SessionSummary{Visits: 3, Bounces: 2, Pageviews: 5, Duration: 900}
+	checkSessions := func() {
+		t.Helper()
+		summary, err := repo.QuerySessionSummary("site", start.Add(-time.Hour), start.Add(time.Hour))
+		if err != nil || summary != expected {
+			t.Fatalf("unexpected session summary: %+v (%v)", summary, err)
+		}
+		var entry, exit string
+		if err := repo.db.QueryRow(`
+            SELECT e.value, x.value FROM site_sessions s
+            JOIN site_dictionary e ON e.id = s.entry_url_id
+            JOIN site_dictionary x ON x.id = s.exit_url_id
+            WHERE s.pageviews = 3`).Scan(&entry, &exit); err != nil || entry != "/landing" || exit != "/b" {
+			t.Fatalf("unexpected entry and exit pages: %q, %q (%v)", entry, exit, err)
+		}
+	}
+	checkSessions()
+
+	pages, err := repo.QuerySessionPages("site", start.Add(-time.Hour), start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("query session pages: %v", err)
+	}
+	expectedPages := map[string]SessionPageStats{
+		"/landing": {Entries: 1},
+		"/b":       {Exits: 1},
+		"/a":       {Entries: 1, Exits: 1, Bounces: 1},
+		"/c":       {Entries: 1, Exits: 1, Bounces: 1},
+	}
+	if len(pages) != len(expectedPages) {
+		t.Fatalf("unexpected session pages: %v", pages)
+	}
+	for url, expected := range expectedPages {
+		if pages[url] == nil || *pages[url] != expected {
+			t.Fatalf("unexpected stats for %s: %+v", url, pages[url])
+		}
+	}
+
+	// 迁移时按已有日志重新划分访问
+	if _, err := repo.db.Exec(`DELETE FROM site_sessions; UPDATE site_nginx_logs SET session_id = NULL`); err != nil {
+		t.Fatalf("reset sessions: %v", err)
+	}
+	if err := repo.backfillSessions("site"); err != nil {
+		t.Fatalf("backfill sessions: %v", err)
+	}
+	checkSessions()
+	var unassigned int
+	repo.db.QueryRow(`SELECT COUNT(*) FROM site_nginx_logs WHERE session_id IS NULL`).Scan(&unassigned)
+	if unassigned != 1 {
+		t.Fatalf("expected only the static request to have no session, got %d", unassigned)
+	}
+}
+
+func TestSessionsDistinguishRawUserAgents(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	// 同一出口 IP 后的两个访客，解析出的浏览器、系统和设备相同，只有原始 User-Agent 不同
+	pageview := func(userAgent string, offset time.Duration) NginxLogRecord {
+		return NginxLogRecord{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: start.Add(offset), Url: "/", Status: 200,
+			UserBrowser: "Chrome", UserOs: "Windows", UserDevice: "桌面设备", UserAgent: userAgent}
+	}
+	logs := []NginxLogRecord{
+		pageview("Mozilla/5.0 (Windows NT 10.0) Chrome/120.0", 0),
+		pageview("Mozilla/5.0 (Windows NT 10.0) Chrome/121.0", time.Minute),
+		pageview("Mozilla/5.0 (Windows NT 10.0) Chrome/120.0", 2*time.Minute),
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+	visits := func() int {
+		t.Helper()
+		summary, err := repo.QuerySessionSummary("site", start.Add(-time.Hour), start.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("query session summary: %v", err)
+		}
+		return summary.Visits
+	}
+	if count := visits(); count != 2 {
+		t.Fatalf("expected each raw user agent to have its own visit, got %d", count)
+	}
+
+	// 旧版本导入的日志没有原始 User-Agent，按解析结果划分
+	if _, err := repo.db.Exec(`DELETE FROM site_sessions;
+        UPDATE site_nginx_logs SET session_id = NULL, user_agent_id = NULL`); err != nil {
+		t.Fatalf("reset sessions: %v", err)
+	}
+	if err := repo.backfillSessions("site"); err != nil {
+		t.Fatalf("backfill sessions: %v", err)
+	}
+	if count := visits(); count != 1 {
+		t.Fatalf("expected legacy logs to be grouped by the parsed user agent, got %d", count)
+	}
+}
+
+func TestScanVisitRequestsAttachesRequestsToVisits(t *testing.T) {
+	repo := newTestRepository(t, "site")
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	logs := []NginxLogRecord{
+		{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: start, Method: "GET", Url: "/pricing", Status: 200},
+		{IP: "192.0.2.1", Timestamp: start.Add(time.Minute), Method: "POST", Url: "/api/orders", Status: 201},
+		{IP: "192.0.2.2", Timestamp: start.Add(time.Minute), Method: "POST", Url: "/api/orders", Status: 201},   // 没有页面浏览
+		{IP: "192.0.2.1", Timestamp: start.Add(2 * time.Hour), Method: "POST", Url: "/api/orders", Status: 201}, // 超过会话超时时间
+		// 在范围之前开始的访问，与访问统计一致不计入
+		{IP: "192.0.2.3", PageviewFlag: 1, Timestamp: start.Add(-10 * time.Minute), Method: "GET", Url: "/pricing", Status: 200},
+		{IP: "192.0.2.3", Timestamp: start.Add(5 * time.Minute), Method: "POST", Url: "/api/orders", Status: 201},
+		// 在范围末尾开始的访问，之后的请求仍属于该访问；访问开始前的请求不属于该访问
+		{IP: "192.0.2.4", Timestamp: start.Add(2*time.Hour + 40*time.Minute), Method: "POST", Url: "/api/early", Status: 201},
+		{IP: "192.0.2.4", PageviewFlag: 1, Timestamp: start.Add(2*time.Hour + 50*time.Minute), Method: "GET", Url: "/pricing", Status: 200},
+		{IP: "192.0.2.4", Timestamp: start.Add(3*time.Hour + 10*time.Minute), Method: "POST", Url: "/api/orders", Status: 201},
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", logs); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+
+	var requests []VisitRequest
+	if err := repo.ScanVisitRequests("site", start, start.Add(3*time.Hour), func(request VisitRequest) {
+		requests = append(requests, request)
+	}); err != nil {
+		t.Fatalf("scan visit requests: %v", err)
+	}
+	if len(requests) != 4 || requests[0].URL != "/pricing" || requests[1].URL != "/api/orders" ||
+		requests[1].Method != "POST" || requests[1].Status != 201 ||
+		requests[0].SessionID == 0 || requests[1].SessionID != requests[0].SessionID {
+		t.Fatalf("unexpected visit requests: %+v", requests)
+	}
+	if requests[2].URL != "/pricing" || requests[3].URL != "/api/orders" ||
+		requests[2].SessionID == requests[0].SessionID || requests[3].SessionID != requests[2].SessionID {
+		t.Fatalf("expected the visit started at the end of the range to keep its requests: %+v", requests)
+	}
+
+	summary, err := repo.QuerySessionSummary("site", start, start.Add(3*time.Hour))
+	if err != nil || summary.Visits != 2 {
+		t.Fatalf("expected the same visits as the scan, got %+v (%v)", summary, err)
+	}
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+// Migration 数据库结构的一次升级
+//
+// 迁移对数据库中已有的每个网站的表执行，新配置的网站由 createWebsiteTables
+// 直接按最新结构建表。迁移须可重复执行：中途失败时版本号不会更新，下次启动会重新执行。
+type Migration struct {
+	Version     int
+	Description string
+	apply       func(r *Repository, websiteID string) error
+}
+
+// migrations 按版本号排列的全部迁移，新增迁移追加到末尾
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "日志表新增 extra 列，保存 log_format 中未映射的变量",
+		apply: func(r *Repository, websiteID string) error {
+			return r.ensureColumn(websiteID+"_nginx_logs", "extra", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		Version:     2,
+		Description: "日志表新增 request_time、upstream_response_time 列",
+		apply: func(r *Repository, websiteID string) error {
+			if err := r.ensureColumn(websiteID+"_nginx_logs", "request_time", "REAL"); err != nil {
+				return err
+			}
+			return r.ensureColumn(websiteID+"_nginx_logs", "upstream_response_time", "REAL")
+		},
+	},
+	{
+		Version:     3,
+		Description: "新增按天汇总表，原始日志清理后保留每日统计",
+		apply: func(r *Repository, websiteID string) error {
+			return r.createDailyStatsTable(websiteID)
+		},
+	},
+	{
+		Version:     4,
+		Description: "新增按小时汇总表，并根据已有日志生成汇总",
+		apply: func(r *Repository, websiteID string) error {
+			// 按小时维度表以字典 ID 保存取值，字典表在此之前创建
+			if err := r.createDictionaryTable(websiteID); err != nil {
+				return err
+			}
+			if err := r.createHourlyStatsTables(websiteID); err != nil {
+				return err
+			}
+			// 上次执行可能中途失败，清空后重新生成
+			if _, err := r.db.Exec(fmt.Sprintf(`DELETE FROM "%[1]s_hourly_stats"; DELETE FROM "%[1]s_hourly_dimensions"`,
+				websiteID)); err != nil {
+				return err
+			}
+			return r.backfillHourlyStats(websiteID, websiteID+"_nginx_logs")
+		},
+	},
+	{
+		Version:     5,
+		Description: "日志表中重复的字符串改为字典编码，删除统计查询不再使用的索引",
+		apply: func(r *Repository, websiteID string) error {
+			return r.convertToDictionary(websiteID)
+		},
+	},
+	{
+		Version:     6,
+		Description: "新增访问表，按访客和会话超时时间将已有的页面浏览划分为访问",
+		apply: func(r *Repository, websiteID string) error {
+			if err := r.ensureColumn(websiteID+"_nginx_logs", "session_id", "BIGINT"); err != nil {
+				return err
+			}
+			// 划分访问时读取 user_agent_id，该列由迁移 9 正式引入
+			if err := r.ensureColumn(websiteID+"_nginx_logs", "user_agent_id", "BIGINT"); err != nil {
+				return err
+			}
+			if err := r.createSessionsTable(websiteID); err != nil {
+				return err
+			}
+			return r.backfillSessions(websiteID)
+		},
+	},
+	{
+		Version:     7,
+		Description: "按小时维度表中的 URL、来源等取值改为字典编码",
+		apply: func(r *Repository, websiteID string) error {
+			return r.convertHourlyDimensions(websiteID)
+		},
+	},
+	{
+		Version:     8,
+		Description: "按天汇总表新增 finalized 列，标记原始日志已清理的天数，避免重复累加",
+		apply: func(r *Repository, websiteID string) error {
+			if err := r.ensureColumn(websiteID+"_daily_stats", "finalized", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return err
+			}
+			return r.finalizeDailyStats(websiteID)
+		},
+	},
+	{
+		Version:     9,
+		Description: "日志表新增 user_agent_id 列保存原始 User-Agent，访问改为按 IP 加原始 User-Agent 划分",
+		apply: func(r *Repository, websiteID string) error {
+			// 已有日志没有原始 User-Agent，保持为 NULL，已划分的访问不变
+			return r.ensureColumn(websiteID+"_nginx_logs", "user_agent_id", "BIGINT")
+		},
+	},
+}
+
+// latestSchemaVersion 当前程序对应的数据库结构版本
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// createSchemaVersionTable 创建记录已执行迁移的表
+func (r *Repository) createSchemaVersionTable() error {
+	_, err := r.db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_version (
+            version INTEGER PRIMARY KEY,
+            description TEXT NOT NULL,
+            applied_at BIGINT NOT NULL
+        )`)
+	return err
+}
+
+// SchemaVersion 返回数据库当前的结构版本，尚未记录版本的旧数据库为 0
+func (r *Repository) SchemaVersion() (int, error) {
+	var exists bool
+	if err := r.db.QueryRow(r.db.dialect.TableExistsSQL(), "schema_version").Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	if err := r.db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// PendingMigrations 返回尚未执行的迁移，全新的数据库无需迁移
+func (r *Repository) PendingMigrations() ([]Migration, error) {
+	websiteIDs, err := r.existingWebsiteIDs()
+	if err != nil {
+		return nil, err
+	}
+	version, err := r.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 && len(websiteIDs) == 0 {
+		return nil, nil
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// migrate 按顺序执行尚未执行的迁移，执行前将数据库备份到 backupDir
+func (r *Repository) migrate(backupDir string) error {
+	if err := r.createSchemaVersionTable(); err != nil {
+		return fmt.Errorf("创建 schema_version 表失败: %v", err)
+	}
+
+	pending, err := r.PendingMigrations()
+	if err != nil {
+		return fmt.Errorf("查询待执行的迁移失败: %v", err)
+	}
+	version, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		// 全新的数据库直接按最新结构建表
+		if version == 0 {
+			return r.recordMigration(migrations[len(migrations)-1])
+		}
+		return nil
+	}
+
+	if r.db.dialect.SnapshotSQL() == "" {
+		logrus.Warnf("数据库结构需要从版本 %d 升级到 %d，%s 数据库不会自动备份，请确认已自行备份",
+			version, latestSchemaVersion(), r.db.dialect.Name())
+	} else {
+		backupPath, err := r.backupBeforeMigrate(backupDir, version)
+		if err != nil {
+			return fmt.Errorf("迁移前备份数据库失败: %v", err)
+		}
+		logrus.Infof("数据库结构需要从版本 %d 升级到 %d，已备份到 %s", version, latestSchemaVersion(), backupPath)
+	}
+
+	websiteIDs, err := r.existingWebsiteIDs()
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		logrus.Infof("执行数据库迁移 %d: %s", migration.Version, migration.Description)
+		for _, websiteID := range websiteIDs {
+			if err := migration.apply(r, websiteID); err != nil {
+				return fmt.Errorf("迁移 %d 处理网站 %s 失败: %v", migration.Version, websiteID, err)
+			}
+		}
+		if err := r.recordMigration(migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordMigration 记录已执行的迁移
+func (r *Repository) recordMigration(migration Migration) error {
+	_, err := r.db.Exec(upsertSQL("schema_version", []string{"version"}, []string{"description", "applied_at"}),
+		migration.Version, migration.Description, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("记录迁移 %d 失败: %v", migration.Version, err)
+	}
+	return nil
+}
+
+// backupBeforeMigrate 用 VACUUM INTO 生成一致的数据库副本，只用于 SQLite
+func (r *Repository) backupBeforeMigrate(backupDir string, version int) (string, error) {
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return "", err
+	}
+	backupPath := filepath.Join(backupDir,
+		fmt.Sprintf("nixvis.db.v%d-%s.bak", version, time.Now().Format("20060102150405")))
+	if _, err := r.db.Exec(r.db.dialect.SnapshotSQL(), backupPath); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// existingWebsiteIDs 返回数据库中已有日志表的网站 ID，包括已从配置中移除的网站
+func (r *Repository) existingWebsiteIDs() ([]string, error) {
+	rows, err := r.db.Query(r.db.dialect.ListTablesSQL(), `%\_nginx\_logs`)
+	if err != nil {
+		return nil, fmt.Errorf("查询表名失败: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		ids = append(ids, strings.TrimSuffix(name, "_nginx_logs"))
+	}
+	return ids, rows.Err()
+}
+
+// migrationBackupDir 迁移前备份文件的目录
+func migrationBackupDir() string {
+	return filepath.Join(util.DataDir, "backups")
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "nixvis.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	// 最早版本的日志表，没有 extra 和耗时列
+	if _, err := db.Exec(`
+        CREATE TABLE "site_nginx_logs" (
+            id INTEGER PRIMARY KEY AUTOINCREMENT, ip TEXT NOT NULL,
+            pageview_flag INTEGER NOT NULL DEFAULT 0, timestamp INTEGER NOT NULL,
+            method TEXT NOT NULL, url TEXT NOT NULL, status_code INTEGER NOT NULL,
+            bytes_sent INTEGER NOT NULL, referer TEXT NOT NULL, user_browser TEXT NOT NULL,
+            user_os TEXT NOT NULL, user_device TEXT NOT NULL,
+            domestic_location TEXT NOT NULL, global_location TEXT NOT NULL);
+        INSERT INTO "site_nginx_logs" VALUES
+            (1, '192.0.2.1', 1, ?, 'GET', '/', 200, 10, '', 'Chrome', 'Linux', 'Desktop', '', '')`,
+		time.Now().Add(-time.Hour).Unix()); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+
+	repo := newRepository(db, sqliteDialect{})
+	backupDir := t.TempDir()
+	if pending, err := repo.PendingMigrations(); err != nil || len(pending) != len(migrations) {
+		t.Fatalf("expected all migrations to be pending, got %d (%v)", len(pending), err)
+	}
+	if err := repo.migrate(backupDir); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	if version, _ := repo.SchemaVersion(); version != latestSchemaVersion() {
+		t.Fatalf("expected schema version %d, got %d", latestSchemaVersion(), version)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(backupDir, "nixvis.db.v0-*.bak")); len(backups) != 1 {
+		t.Fatalf("expected one backup, got %v", backups)
+	}
+	if err := repo.BatchInsertLogsForWebsite("site", []NginxLogRecord{{IP: "192.0.2.2", Timestamp: time.Now(), Url: "/", Status: 200}}); err != nil {
+		t.Fatalf("insert after migration: %v", err)
+	}
+	if hours, _ := repo.QueryHourlyStats("site", time.Now().Add(-2*time.Hour), time.Now().Add(time.Hour)); len(hours) == 0 || hours[0].PV != 1 {
+		t.Fatalf("expected existing logs to be rolled up, got %+v", hours)
+	}
+
+	// 旧数据转换为字典编码后，视图仍按原列名返回字符串
+	var url, browser string
+	if err := db.QueryRow(`SELECT url, user_browser FROM "site_nginx_logs_v" WHERE id = 1`).Scan(&url, &browser); err != nil || url != "/" || browser != "Chrome" {
+		t.Fatalf("unexpected legacy row through the view: %q %q (%v)", url, browser, err)
+	}
+	var values int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "site_dictionary" WHERE value = '/'`).Scan(&values); err != nil || values != 1 {
+		t.Fatalf("expected the url to be stored once, got %d (%v)", values, err)
+	}
+
+	// 再次执行不会重复迁移
+	if pending, err := repo.PendingMigrations(); err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending migrations, got %d (%v)", len(pending), err)
+	}
+}
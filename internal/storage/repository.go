@@ -2,8 +2,10 @@ package storage
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/beyondxinxin/nixvis/internal/util"
@@ -12,33 +14,55 @@ import (
 )
 
 var (
-	dataSourceName = filepath.Join(util.DataDir, "nixvis.db")
+	databasePath  = filepath.Join(util.DataDir, "nixvis.db")
+	scanStatePath = filepath.Join(util.DataDir, "nginx_scan_state.json")
+
+	// 导入命令与服务可能同时写入，等待写锁而不是立即失败
+	dataSourceName = databasePath + "?_pragma=busy_timeout(10000)"
 )
 
 type NginxLogRecord struct {
-	ID               int64     `json:"id"`
-	IP               string    `json:"ip"`
-	PageviewFlag     int       `json:"pageview_flag"`
-	Timestamp        time.Time `json:"timestamp"`
-	Method           string    `json:"method"`
-	Url              string    `json:"url"`
-	Status           int       `json:"status"`
-	BytesSent        int       `json:"bytes_sent"`
-	Referer          string    `json:"referer"`
-	UserBrowser      string    `json:"user_browser"`
-	UserOs           string    `json:"user_os"`
-	UserDevice       string    `json:"user_device"`
-	DomesticLocation string    `json:"domestic_location"`
-	GlobalLocation   string    `json:"global_location"`
+	ID                   int64             `json:"id"`
+	IP                   string            `json:"ip"`
+	PageviewFlag         int               `json:"pageview_flag"`
+	Timestamp            time.Time         `json:"timestamp"`
+	Method               string            `json:"method"`
+	Url                  string            `json:"url"`
+	Status               int               `json:"status"`
+	BytesSent            int               `json:"bytes_sent"`
+	Referer              string            `json:"referer"`
+	UserBrowser          string            `json:"user_browser"`
+	UserOs               string            `json:"user_os"`
+	UserDevice           string            `json:"user_device"`
+	UserAgent            string            `json:"user_agent,omitempty"` // 原始 User-Agent，用于区分访客
+	DomesticLocation     string            `json:"domestic_location"`
+	GlobalLocation       string            `json:"global_location"`
+	RequestTime          *float64          `json:"request_time,omitempty"`           // $request_time，单位秒
+	UpstreamResponseTime *float64          `json:"upstream_response_time,omitempty"` // $upstream_response_time，多个上游时为总和
+	Extra                map[string]string `json:"extra,omitempty"`                  // log_format 中未映射的变量
 }
 
 type Repository struct {
-	db *sql.DB
+	db      *database
+	writeMu sync.Mutex // 并发扫描时串行写入，避免 SQLite 写锁冲突
 }
 
 func NewRepository() (*Repository, error) {
+	cfg := util.ReadConfig().Database
+	dialect, err := DialectFor(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+	dsn := dataSourceName
+	if dialect.Name() != "sqlite" {
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("使用 %s 数据库时须配置 database.dsn", dialect.Name())
+		}
+		dsn = cfg.DSN
+	}
+
 	// 打开数据库
-	db, err := sql.Open("sqlite", dataSourceName)
+	db, err := sql.Open(dialect.DriverName(), dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -49,23 +73,29 @@ func NewRepository() (*Repository, error) {
 	}
 
 	// 性能优化设置
-	if _, err := db.Exec(`
-        PRAGMA journal_mode=WAL;
-        PRAGMA synchronous=NORMAL;
-        PRAGMA cache_size=32768;
-        PRAGMA temp_store=MEMORY;`); err != nil {
-		db.Close()
-		return nil, err
+	if initSQL := dialect.InitSQL(); initSQL != "" {
+		if _, err := db.Exec(initSQL); err != nil {
+			db.Close()
+			return nil, err
+		}
 	}
 
-	return &Repository{
-		db: db,
-	}, nil
+	return newRepository(db, dialect), nil
 }
 
-// 初始化数据库
+func newRepository(db *sql.DB, dialect Dialect) *Repository {
+	return &Repository{db: &database{DB: db, dialect: dialect}}
+}
+
+// 初始化数据库，先升级已有的表结构，再为新配置的网站建表
 func (r *Repository) Init() error {
-	return r.createTables()
+	if err := r.migrate(migrationBackupDir()); err != nil {
+		return err
+	}
+	if err := r.createTables(); err != nil {
+		return err
+	}
+	return r.importScanStateFile(scanStatePath)
 }
 
 // 关闭数据库连接
@@ -77,94 +107,167 @@ func (r *Repository) Close() error {
 	return nil
 }
 
-// 获取数据库连接
-func (r *Repository) GetDB() *sql.DB {
-	return r.db
-}
-
 // 为特定网站批量插入日志记录
 func (r *Repository) BatchInsertLogsForWebsite(websiteID string, logs []NginxLogRecord) error {
+	return r.writeLogBatches(map[string][]NginxLogRecord{websiteID: logs}, nil, nil)
+}
+
+// writeLogBatches 在一个事务中写入多个网站的日志
+//
+// save 不为空时在同一事务中保存其他数据，如日志来源的扫描进度。提交成功后在释放写锁前调用 committed，
+// 使内存中的状态与数据库按相同的顺序更新。
+func (r *Repository) writeLogBatches(batches map[string][]NginxLogRecord,
+	save func(tx *transaction) error, committed func()) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
+	defer tx.Rollback()
+
+	for websiteID, logs := range batches {
+		if len(logs) == 0 {
+			continue
+		}
+		if err := insertLogs(tx, websiteID, logs); err != nil {
+			return err
+		}
+	}
+	if save != nil {
+		if err := save(tx); err != nil {
+			return err
 		}
-	}()
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if committed != nil {
+		committed()
+	}
+	return nil
+}
 
+// insertLogs 在事务中写入一个网站的日志，并更新按小时统计
+func insertLogs(tx *transaction, websiteID string, logs []NginxLogRecord) error {
 	// 准备批量插入语句
 	nginxTable := fmt.Sprintf("%s_nginx_logs", websiteID)
 
 	stmtNginx, err := tx.Prepare(fmt.Sprintf(`
         INSERT INTO "%s" (
-        ip, pageview_flag, timestamp, method, url, 
-        status_code, bytes_sent, referer, 
-        user_browser, user_os, user_device, domestic_location, global_location)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ip, pageview_flag, timestamp, method, status_code, bytes_sent,
+        url_id, referer_id, user_browser_id, user_os_id, user_device_id,
+        domestic_location_id, global_location_id, extra,
+        request_time, upstream_response_time, session_id, user_agent_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `, nginxTable))
 	if err != nil {
 		return err
 	}
 	defer stmtNginx.Close()
 
+	dictionary, err := newDictionaryWriter(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer dictionary.Close()
+
+	sessions, err := newSessionWriter(tx, websiteID)
+	if err != nil {
+		return err
+	}
+	defer sessions.Close()
+
 	// 执行批量插入
 	for _, log := range logs {
-		// 原始日志表
-		_, err = stmtNginx.Exec(
-			log.IP, log.PageviewFlag, log.Timestamp.Unix(), log.Method, log.Url,
-			log.Status, log.BytesSent, log.Referer, log.UserBrowser, log.UserOs, log.UserDevice,
-			log.DomesticLocation, log.GlobalLocation,
-		)
+		extra := ""
+		if len(log.Extra) > 0 {
+			data, err := json.Marshal(log.Extra)
+			if err != nil {
+				return err
+			}
+			extra = string(data)
+		}
+
+		ids, err := dictionary.recordIDs(&log)
 		if err != nil {
 			return err
 		}
+		userAgentID, err := dictionary.id(log.UserAgent)
+		if err != nil {
+			return err
+		}
+
+		// 计入 PV 的请求归入访客的访问
+		var sessionID any
+		if log.PageviewFlag == 1 {
+			if sessionID, err = sessions.assign(visitorKey(log.IP, userAgentID),
+				log.Timestamp.Unix(), ids[0].(int64)); err != nil {
+				return err
+			}
+		}
+
+		// 原始日志表
+		args := []any{log.IP, log.PageviewFlag, log.Timestamp.Unix(), log.Method, log.Status, log.BytesSent}
+		args = append(args, ids...)
+		args = append(args, extra, log.RequestTime, log.UpstreamResponseTime, sessionID, userAgentID)
+		if _, err := stmtNginx.Exec(args...); err != nil {
+			return err
+		}
+	}
+
+	if err := sessions.flush(); err != nil {
+		return err
 	}
 
-	return tx.Commit()
+	// 按小时统计与日志在同一事务中更新，两者始终一致
+	return writeHourlyStats(tx, websiteID, logs, dictionary)
 }
 
-// CleanOldLogs 清理45天前的日志数据
+// CleanOldLogs 按各网站的保留天数清理原始日志，清理前先将完整的天汇总到按天统计表
 func (r *Repository) CleanOldLogs() error {
-	cutoffTime := time.Now().AddDate(0, 0, -45).Unix()
-
 	deletedCount := 0
 
-	rows, err := r.db.Query(`
-        SELECT name FROM sqlite_master 
-        WHERE type='table' AND name LIKE '%_nginx_logs'
-    `)
+	websiteIDs, err := r.existingWebsiteIDs()
 	if err != nil {
-		return fmt.Errorf("查询表名失败: %v", err)
+		return err
 	}
-	defer rows.Close()
 
-	var tableNames []string
-	for rows.Next() {
-		var tableName string
-		if err := rows.Scan(&tableName); err != nil {
-			logrus.WithError(err).Error("扫描表名失败")
-			continue
-		}
-		tableNames = append(tableNames, tableName)
-	}
+	for _, websiteID := range websiteIDs {
+		tableName := websiteID + "_nginx_logs"
 
-	for _, tableName := range tableNames {
-		result, err := r.db.Exec(
-			fmt.Sprintf(`DELETE FROM "%s" WHERE timestamp < ?`, tableName), cutoffTime,
-		)
+		// 按天对齐，保证保留下来的天数据完整，重新汇总时不会覆盖为部分数据
+		retentionDays := util.RetentionDays(websiteID)
+		cutoff := startOfDay(time.Now().AddDate(0, 0, -retentionDays))
+		count, err := r.rollupDailyStats(websiteID, cutoff)
 		if err != nil {
-			logrus.WithError(err).Errorf("清理表 %s 的旧日志失败", tableName)
+			logrus.WithError(err).Errorf("汇总网站 %s 的每日统计并清理表 %s 的旧日志失败", websiteID, tableName)
 			continue
 		}
-
-		count, _ := result.RowsAffected()
+		if count > 0 {
+			logrus.Infof("删除了网站 %s 的 %d 条 %d 天前的日志记录", websiteID, count, retentionDays)
+		}
 		deletedCount += int(count)
+
+		if err := r.pruneHourlyStats(websiteID, cutoff); err != nil {
+			logrus.WithError(err).Errorf("清理网站 %s 的过期按小时统计失败", websiteID)
+		}
+		if err := r.pruneSessions(websiteID, cutoff); err != nil {
+			logrus.WithError(err).Errorf("清理网站 %s 的过期访问失败", websiteID)
+		}
+		if count > 0 {
+			if err := r.pruneDictionary(websiteID); err != nil {
+				logrus.WithError(err).Errorf("清理网站 %s 的字典失败", websiteID)
+			}
+		}
+
+		if err := r.pruneDailyStats(websiteID, util.RollupRetentionDays(websiteID)); err != nil {
+			logrus.WithError(err).Errorf("清理网站 %s 的过期每日统计失败", websiteID)
+		}
 	}
 
 	if deletedCount > 0 {
-		logrus.Infof("删除了 %d 条45天前的日志记录", deletedCount)
 		if _, err := r.db.Exec("VACUUM"); err != nil {
 			logrus.WithError(err).Error("数据库压缩失败")
 		}
@@ -174,42 +277,94 @@ func (r *Repository) CleanOldLogs() error {
 }
 
 func (r *Repository) createTables() error {
-	common := `id INTEGER PRIMARY KEY AUTOINCREMENT,
+	for _, id := range util.GetAllWebsiteIDs() {
+		if err := r.createWebsiteTables(id); err != nil {
+			return err
+		}
+	}
+	if err := r.createImportTables(); err != nil {
+		return err
+	}
+	return r.createScanStateTable()
+}
+
+// logsTableColumns 日志表的列，字典编码的列保存字典 ID
+func (r *Repository) logsTableColumns() string {
+	return fmt.Sprintf(`id %s,
 	ip TEXT NOT NULL,
 	pageview_flag INTEGER NOT NULL DEFAULT 0,
-	timestamp INTEGER NOT NULL,
+	timestamp BIGINT NOT NULL,
 	method TEXT NOT NULL,
-	url TEXT NOT NULL,
 	status_code INTEGER NOT NULL,
-	bytes_sent INTEGER NOT NULL,
-	referer TEXT NOT NULL,
-	user_browser TEXT NOT NULL,
-	user_os TEXT NOT NULL,
-	user_device TEXT NOT NULL,
-	domestic_location TEXT NOT NULL,
-	global_location TEXT NOT NULL`
-	for _, id := range util.GetAllWebsiteIDs() {
-		q := fmt.Sprintf(
-			`CREATE TABLE IF NOT EXISTS "%[1]s_nginx_logs" (%[2]s);
-             
-             -- 单列索引
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_timestamp ON "%[1]s_nginx_logs"(timestamp);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_url ON "%[1]s_nginx_logs"(url);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_ip ON "%[1]s_nginx_logs"(ip);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_referer ON "%[1]s_nginx_logs"(referer);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_user_browser ON "%[1]s_nginx_logs"(user_browser);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_user_os ON "%[1]s_nginx_logs"(user_os);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_user_device ON "%[1]s_nginx_logs"(user_device);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_domestic_location ON "%[1]s_nginx_logs"(domestic_location);
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_global_location ON "%[1]s_nginx_logs"(global_location);
-             
-             -- 复合索引
-             CREATE INDEX IF NOT EXISTS idx_%[1]s_pv_ts_ip ON "%[1]s_nginx_logs" (pageview_flag, timestamp, ip);`,
-			id, common,
-		)
-		if _, err := r.db.Exec(q); err != nil {
-			return err
-		}
+	bytes_sent BIGINT NOT NULL,
+	url_id BIGINT NOT NULL,
+	referer_id BIGINT NOT NULL,
+	user_browser_id BIGINT NOT NULL,
+	user_os_id BIGINT NOT NULL,
+	user_device_id BIGINT NOT NULL,
+	domestic_location_id BIGINT NOT NULL,
+	global_location_id BIGINT NOT NULL,
+	extra TEXT NOT NULL DEFAULT '',
+	request_time DOUBLE PRECISION,
+	upstream_response_time DOUBLE PRECISION,
+	session_id BIGINT,
+	user_agent_id BIGINT`, r.db.dialect.AutoIncrementKey())
+}
+
+// createWebsiteTables 创建单个网站的日志表、字典表、视图和汇总表
+func (r *Repository) createWebsiteTables(id string) error {
+	if _, err := r.db.Exec(fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS "%s_nginx_logs" (%s)`, id, r.logsTableColumns())); err != nil {
+		return err
+	}
+	if err := r.createLogsIndexes(id); err != nil {
+		return err
+	}
+	if err := r.createDictionaryTable(id); err != nil {
+		return err
+	}
+	if err := r.createLogsView(id); err != nil {
+		return err
+	}
+
+	if err := r.createDailyStatsTable(id); err != nil {
+		return err
+	}
+	if err := r.createHourlyStatsTables(id); err != nil {
+		return err
+	}
+	return r.createSessionsTable(id)
+}
+
+// createLogsIndexes 创建日志表的索引，统计查询使用汇总表，只保留按时间查询所需的索引
+func (r *Repository) createLogsIndexes(id string) error {
+	_, err := r.db.Exec(fmt.Sprintf(`
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_timestamp ON "%[1]s_nginx_logs"(timestamp);
+        CREATE INDEX IF NOT EXISTS idx_%[1]s_pv_ts_ip ON "%[1]s_nginx_logs" (pageview_flag, timestamp, ip);`,
+		id))
+	return err
+}
+
+// hasColumn 判断表中是否存在指定列
+func (r *Repository) hasColumn(tableName, column string) (bool, error) {
+	var exists bool
+	if err := r.db.QueryRow(r.db.dialect.ColumnExistsSQL(), tableName, column).Scan(&exists); err != nil {
+		return false, fmt.Errorf("查询表 %s 结构失败: %v", tableName, err)
+	}
+	return exists, nil
+}
+
+// ensureColumn 表中不存在指定列时追加该列
+func (r *Repository) ensureColumn(tableName, column, definition string) error {
+	exists, err := r.hasColumn(tableName, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = r.db.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN %s %s`, tableName, column, definition))
+	if err != nil {
+		return fmt.Errorf("为表 %s 添加列 %s 失败: %v", tableName, column, err)
 	}
+	logrus.Infof("已为表 %s 添加列 %s", tableName, column)
 	return nil
 }
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scanCheckpoint 将日志来源读取到 consumed 字节处的进度写入扫描状态
+//
+// consumed 为本次解析从起始位置开始已读取的字节数，之前的日志已全部写入数据库。
+type scanCheckpoint func(state *LogScanState, consumed int64)
+
+// createScanStateTable 创建保存各日志来源扫描状态的表
+//
+// 扫描状态与日志在同一事务中写入，进程在任意时刻退出后都不会重复导入或遗漏日志。
+func (r *Repository) createScanStateTable() error {
+	_, err := r.db.Exec(`
+        CREATE TABLE IF NOT EXISTS scan_state (
+            source_id TEXT PRIMARY KEY,
+            state TEXT NOT NULL,
+            updated_at BIGINT NOT NULL
+        )`)
+	return err
+}
+
+// loadScanStates 读取全部日志来源的扫描状态，以网站 ID 或共用日志来源的键为键
+func (r *Repository) loadScanStates() (map[string]LogScanState, error) {
+	rows, err := r.db.Query(`SELECT source_id, state FROM scan_state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]LogScanState)
+	for rows.Next() {
+		var sourceID, data string
+		if err := rows.Scan(&sourceID, &data); err != nil {
+			return nil, err
+		}
+		var state LogScanState
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return nil, fmt.Errorf("解析 %s 的扫描状态失败: %v", sourceID, err)
+		}
+		states[sourceID] = state
+	}
+	return states, rows.Err()
+}
+
+// saveScanStates 在一个事务中保存多个日志来源的扫描状态
+func (r *Repository) saveScanStates(states map[string]LogScanState) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for sourceID, state := range states {
+		if err := saveScanState(tx, sourceID, state); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// saveScanState 在事务中保存一个日志来源的扫描状态
+func saveScanState(tx *transaction, sourceID string, state LogScanState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(upsertSQL("scan_state", []string{"source_id"}, []string{"state", "updated_at"}),
+		sourceID, string(data), time.Now().Unix())
+	return err
+}
+
+// importScanStateFile 将旧版本保存在 JSON 文件中的扫描状态导入数据库，导入后文件重命名为 .imported
+//
+// 从旧版本的备份恢复时也会得到该文件，数据库中同一来源的状态以文件为准。
+func (r *Repository) importScanStateFile(path string) error {
+	data, err := readOptionalFile(path)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var states map[string]LogScanState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("解析扫描状态文件 %s 失败: %v", path, err)
+	}
+	if err := r.saveScanStates(states); err != nil {
+		return fmt.Errorf("导入扫描状态失败: %v", err)
+	}
+	if err := os.Rename(path, path+".imported"); err != nil {
+		return err
+	}
+	logrus.Infof("已将 %d 个日志来源的扫描状态从 %s 导入数据库", len(states), path)
+	return nil
+}
+
+// cloneScanState 复制扫描状态，副本可以在不持有锁时修改和序列化
+func cloneScanState(state LogScanState) LogScanState {
+	clone := LogScanState{
+		Files:    make(map[string]FileState, len(state.Files)),
+		Archives: make(map[string]ArchiveState, len(state.Archives)),
+		Rotated:  append([]FileState(nil), state.Rotated...),
+	}
+	for path, fileState := range state.Files {
+		clone.Files[path] = fileState
+	}
+	for contentHash, archive := range state.Archives {
+		clone.Archives[contentHash] = archive
+	}
+	return clone
+}
+
+// commitBatches 写入各网站的日志批次，并在同一事务中保存日志来源读取到的位置
+//
+// 日志和进度分开保存时，进程在两者之间退出会导致下次扫描重复导入同一批日志。
+// checkpoint 为空时只写入日志。
+func (p *LogParser) commitBatches(sourceID string,
+	batches map[string][]NginxLogRecord, checkpoint func(*LogScanState)) error {
+	if checkpoint == nil {
+		return p.repo.writeLogBatches(batches, nil, nil)
+	}
+
+	save := func(tx *transaction) error {
+		p.stateMu.Lock()
+		state := cloneScanState(p.states[sourceID])
+		p.stateMu.Unlock()
+
+		checkpoint(&state)
+		return saveScanState(tx, sourceID, state)
+	}
+	// 提交后再更新内存中的状态，写入失败时下一轮从已提交的位置重试
+	committed := func() {
+		state := p.siteState(sourceID)
+		p.stateMu.Lock()
+		checkpoint(&state)
+		p.stateMu.Unlock()
+	}
+	return p.repo.writeLogBatches(batches, save, committed)
+}
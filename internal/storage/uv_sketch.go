@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	sketchPrecision   = 12
+	sketchRegisters   = 1 << sketchPrecision
+	sketchSparseLimit = 512 // 稀疏表示超过该数量后转为 HyperLogLog 寄存器
+
+	sketchSparse byte = 1
+	sketchDense  byte = 2
+)
+
+// UVSketch 可合并的独立访客计数
+//
+// 访客较少时保存每个访客的哈希值，结果精确；超过 sketchSparseLimit 后转为
+// HyperLogLog，误差约 1.6%。按小时保存的计数合并后即可得到任意时间范围的 UV。
+type UVSketch struct {
+	sparse    map[uint64]struct{}
+	registers []uint8
+}
+
+// NewUVSketch 创建空的访客计数
+func NewUVSketch() *UVSketch {
+	return &UVSketch{sparse: make(map[uint64]struct{})}
+}
+
+// Add 记录一个访客
+func (s *UVSketch) Add(visitor string) {
+	hash := fnv.New64a()
+	hash.Write([]byte(visitor))
+	s.addHash(mixHash(hash.Sum64()))
+}
+
+func (s *UVSketch) addHash(h uint64) {
+	if s.registers == nil {
+		s.sparse[h] = struct{}{}
+		if len(s.sparse) > sketchSparseLimit {
+			s.toDense()
+		}
+		return
+	}
+
+	index := h >> (64 - sketchPrecision)
+	rank := uint8(bits.LeadingZeros64(h<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+func (s *UVSketch) toDense() {
+	s.registers = make([]uint8, sketchRegisters)
+	for h := range s.sparse {
+		s.addHash(h)
+	}
+	s.sparse = nil
+}
+
+// Merge 合并另一个计数，结果为两者访客的并集
+func (s *UVSketch) Merge(other *UVSketch) {
+	if other == nil {
+		return
+	}
+	if other.registers == nil {
+		for h := range other.sparse {
+			s.addHash(h)
+		}
+		return
+	}
+
+	if s.registers == nil {
+		s.toDense()
+	}
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+}
+
+// Count 返回独立访客数
+func (s *UVSketch) Count() int {
+	if s.registers == nil {
+		return len(s.sparse)
+	}
+
+	sum, zeros := 0.0, 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	m := float64(sketchRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// 基数较小时使用线性计数修正
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(estimate))
+}
+
+// encode 序列化为数据库中保存的格式
+func (s *UVSketch) encode() []byte {
+	if s.registers != nil {
+		return append([]byte{sketchDense}, s.registers...)
+	}
+
+	hashes := make([]uint64, 0, len(s.sparse))
+	for h := range s.sparse {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	data := make([]byte, 1, 1+8*len(hashes))
+	data[0] = sketchSparse
+	for _, h := range hashes {
+		data = binary.BigEndian.AppendUint64(data, h)
+	}
+	return data
+}
+
+// decodeUVSketch 解析数据库中保存的访客计数
+func decodeUVSketch(data []byte) (*UVSketch, error) {
+	if len(data) == 0 {
+		return nil, errors.New("访客计数数据为空")
+	}
+
+	switch data[0] {
+	case sketchSparse:
+		if (len(data)-1)%8 != 0 {
+			return nil, errors.New("访客计数数据长度无效")
+		}
+		sketch := NewUVSketch()
+		for i := 1; i < len(data); i += 8 {
+			sketch.addHash(binary.BigEndian.Uint64(data[i:]))
+		}
+		return sketch, nil
+	case sketchDense:
+		if len(data) != 1+sketchRegisters {
+			return nil, errors.New("访客计数数据长度无效")
+		}
+		return &UVSketch{registers: append([]uint8(nil), data[1:]...)}, nil
+	default:
+		return nil, errors.New("未知的访客计数格式")
+	}
+}
+
+// mixHash 打散 FNV 哈希的低位，使 HyperLogLog 寄存器分布均匀
+func mixHash(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
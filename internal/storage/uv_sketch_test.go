@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUVSketchMergesAndEstimates(t *testing.T) {
+	small, other := NewUVSketch(), NewUVSketch()
+	for i := range 100 {
+		small.Add(fmt.Sprintf("10.0.0.%d", i))
+		other.Add(fmt.Sprintf("10.0.0.%d", i+50))
+	}
+	small.Merge(other)
+	if count := small.Count(); count != 150 {
+		t.Fatalf("expected an exact count of 150, got %d", count)
+	}
+
+	large := NewUVSketch()
+	for i := range 100000 {
+		large.Add(fmt.Sprintf("10.%d.%d.%d", i>>16, i>>8&0xff, i&0xff))
+	}
+	decoded, err := decodeUVSketch(large.encode())
+	if err != nil {
+		t.Fatalf("decode sketch: %v", err)
+	}
+	if count := decoded.Count(); count < 95000 || count > 105000 {
+		t.Fatalf("expected about 100000 visitors, got %d", count)
+	}
+}
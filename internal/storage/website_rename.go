@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/beyondxinxin/nixvis/internal/util"
+)
+
+// websiteTableSuffixes 每个网站独有的表，表名为 <网站ID>_<后缀>
+var websiteTableSuffixes = []string{
+	"nginx_logs", "dictionary", "daily_stats", "hourly_stats", "hourly_dimensions", "sessions",
+}
+
+// HasWebsiteData 判断数据库中是否有该网站的日志表
+func (r *Repository) HasWebsiteData(websiteID string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(r.db.dialect.TableExistsSQL(), websiteID+"_nginx_logs").Scan(&exists)
+	return exists, err
+}
+
+// RenameWebsite 将网站的表、导入记录和扫描状态从 oldID 迁移到 newID，须在服务停止时执行
+//
+// 修改配置后启动过服务时，新 ID 的空表已经创建，这些空表会被删除；新 ID 已有日志时拒绝迁移。
+func (r *Repository) RenameWebsite(oldID, newID string) error {
+	if !util.ValidWebsiteID(newID) {
+		return fmt.Errorf("网站 ID %q 只能包含字母、数字和下划线", newID)
+	}
+	if exists, err := r.HasWebsiteData(oldID); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("数据库中没有网站 %s 的数据", oldID)
+	}
+	if exists, err := r.HasWebsiteData(newID); err != nil {
+		return err
+	} else if exists {
+		var hasLogs bool
+		if err := r.db.QueryRow(fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM "%s_nginx_logs")`, newID)).Scan(&hasLogs); err != nil {
+			return err
+		}
+		if hasLogs {
+			return fmt.Errorf("网站 %s 已有日志数据，无法覆盖", newID)
+		}
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 索引名包含网站 ID，删除后由 createWebsiteTables 按新 ID 重建
+	var statements []string
+	for _, table := range []string{"nginx_logs", "sessions"} {
+		indexes, err := tx.Query(r.db.dialect.ListIndexesSQL(), oldID+"_"+table)
+		if err != nil {
+			return err
+		}
+		for indexes.Next() {
+			var name string
+			if err := indexes.Scan(&name); err != nil {
+				indexes.Close()
+				return err
+			}
+			statements = append(statements, fmt.Sprintf(`DROP INDEX "%s"`, name))
+		}
+		indexes.Close()
+	}
+
+	statements = append(statements,
+		fmt.Sprintf(`DROP VIEW IF EXISTS "%s"`, LogsView(oldID)),
+		fmt.Sprintf(`DROP VIEW IF EXISTS "%s"`, LogsView(newID)))
+	for _, suffix := range websiteTableSuffixes {
+		statements = append(statements,
+			fmt.Sprintf(`DROP TABLE IF EXISTS "%s_%s"`, newID, suffix),
+			fmt.Sprintf(`ALTER TABLE "%s_%s" RENAME TO "%s_%s"`, oldID, suffix, newID, suffix))
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("重命名网站 %s 的表失败: %v", oldID, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM import_progress WHERE website_id = ?`, newID); err != nil {
+		return err
+	}
+	for _, table := range []string{"import_history", "import_boundaries", "import_progress"} {
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET website_id = ? WHERE website_id = ?`, table),
+			newID, oldID); err != nil {
+			return err
+		}
+	}
+
+	// 扫描状态随之改名，避免改名后重新扫描整个日志
+	if _, err := tx.Exec(`DELETE FROM scan_state WHERE source_id = ?`, newID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE scan_state SET source_id = ? WHERE source_id = ?`, newID, oldID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return r.createWebsiteTables(newID)
+}
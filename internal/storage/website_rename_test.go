@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenameWebsiteMovesTablesAndState(t *testing.T) {
+	repo := newTestRepository(t, "old")
+	if err := repo.createImportTables(); err != nil {
+		t.Fatalf("create import tables: %v", err)
+	}
+	if err := repo.BatchInsertLogsForWebsite("old", []NginxLogRecord{{IP: "192.0.2.1", PageviewFlag: 1, Timestamp: time.Now(), Url: "/", Status: 200}}); err != nil {
+		t.Fatalf("insert logs: %v", err)
+	}
+	// 修改配置后启动过服务，新 ID 的空表已经存在
+	if err := repo.createWebsiteTables("new"); err != nil {
+		t.Fatalf("create new tables: %v", err)
+	}
+	if err := repo.saveScanStates(map[string]LogScanState{
+		"old": {Files: map[string]FileState{"/var/log/a.log": {LastOffset: 10, LastSize: 10}}},
+		"new": {},
+	}); err != nil {
+		t.Fatalf("save scan state: %v", err)
+	}
+
+	if err := repo.RenameWebsite("old", "new"); err != nil {
+		t.Fatalf("rename website: %v", err)
+	}
+	if exists, _ := repo.HasWebsiteData("old"); exists {
+		t.Fatal("expected the old tables to be gone")
+	}
+	var url string
+	if err := repo.db.QueryRow(`SELECT url FROM "new_nginx_logs_v"`).Scan(&url); err != nil || url != "/" {
+		t.Fatalf("expected the log under the new id, got %q (%v)", url, err)
+	}
+	if hours, _ := repo.QueryHourlyStats("new", time.Now().Add(-time.Hour), time.Now().Add(time.Hour)); len(hours) != 1 {
+		t.Fatalf("expected the rollups to move, got %+v", hours)
+	}
+	if err := repo.RenameWebsite("missing", "other"); err == nil {
+		t.Fatal("expected an error for a website without data")
+	}
+
+	states, err := repo.loadScanStates()
+	if _, ok := states["old"]; ok || err != nil || states["new"].Files["/var/log/a.log"].LastOffset != 10 {
+		t.Fatalf("unexpected state after rename: %+v (%v)", states, err)
+	}
+}
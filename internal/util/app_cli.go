@@ -3,10 +3,12 @@ package util
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
@@ -23,12 +25,36 @@ const (
 	ConfigFile = "./nixvis_config.json"
 )
 
+// Command 子命令，如 nixvis import，由需要依赖其他包的调用方注册
+type Command struct {
+	Name  string
+	Usage string                    // 用法说明，显示在 -h 中
+	Run   func(args []string) error // args 为子命令名之后的参数
+}
+
+var commands []Command
+
+// RegisterCommand 注册子命令，需在 ProcessCliCommands 之前调用
+func RegisterCommand(command Command) {
+	commands = append(commands, command)
+}
+
 // HandleAppConfig 处理应用程序配置初始化和命令行参数
 func ProcessCliCommands() bool {
+	// 子命令
+	if len(os.Args) > 1 {
+		for _, command := range commands {
+			if command.Name == os.Args[1] {
+				return runCommand(command, os.Args[2:])
+			}
+		}
+	}
+
 	// 命令行参数
 	genConfig := flag.Bool("gen-config", false, "生成配置文件并退出")
 	cleanApp := flag.Bool("clean", false, "清理nixvis服务、释放端口和删除数据")
 	showVer := flag.Bool("v", false, "显示版本信息")
+	flag.Usage = printUsage
 	flag.Parse()
 
 	// 显示版本信息
@@ -62,6 +88,35 @@ func ProcessCliCommands() bool {
 	return false
 }
 
+// runCommand 校验配置后执行子命令，执行失败时以非零状态退出
+func runCommand(command Command, args []string) bool {
+	if exit := validateConfig(); exit {
+		return true
+	}
+	if exit := initDirs(); exit {
+		return true
+	}
+
+	if err := command.Run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s 执行失败: %v\n", command.Name, err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// printUsage 显示命令行参数和子命令的用法
+func printUsage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "用法: %s [参数]\n", os.Args[0])
+	flag.PrintDefaults()
+	if len(commands) == 0 {
+		return
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\n子命令:\n")
+	for _, command := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s %s\n", os.Args[0], command.Usage)
+	}
+}
+
 // showVersion 显示版本信息
 func showVersion() {
 	fmt.Printf("版本: %s\n", Version)
@@ -196,6 +251,93 @@ func validateConfig() bool {
 		}
 	}
 
+	// 检查网站 ID：须可用作表名，且不能重复
+	websiteIDs := make(map[string]string)
+	for _, site := range cfg.Websites {
+		id := WebsiteIDFor(site)
+		if !ValidWebsiteID(id) {
+			fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 的 id %q 只能包含字母、数字和下划线，且不超过 32 个字符\n", site.Name, id)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+		if other, ok := websiteIDs[id]; ok {
+			fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 与 '%s' 的 ID 都是 %s，请为其中一个网站设置不同的 id\n", site.Name, other, id)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+		websiteIDs[id] = site.Name
+	}
+
+	// 检查自定义日志格式
+	for _, site := range cfg.Websites {
+		if site.LogFormat == "" {
+			continue
+		}
+		if site.Format == "apache" && !strings.Contains(site.LogFormat, "%") {
+			fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 的 logFormat 不包含任何 Apache 指令\n", site.Name)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+		if (site.Format == "" || site.Format == "nginx") && !strings.Contains(site.LogFormat, "$") {
+			fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 的 logFormat 不包含任何 Nginx 变量\n", site.Name)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+	}
+
+	// 检查受信任代理地址，请求头可由客户端伪造，只能信任来自已知代理的请求
+	for _, site := range cfg.Websites {
+		if strings.TrimSpace(site.RealIPHeader) != "" && len(site.TrustedProxies) == 0 {
+			fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 配置了 realIPHeader，但未配置 trustedProxies\n", site.Name)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+		for _, proxy := range site.TrustedProxies {
+			if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+				fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 的 trustedProxies 中 %q 不是有效的 IP 或 CIDR\n", site.Name, proxy)
+				fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+				return true
+			}
+		}
+	}
+
+	// 检查转化目标和漏斗
+	for _, site := range cfg.Websites {
+		if err := validateGoals(site); err != nil {
+			fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 的%v\n", site.Name, err)
+			fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+			return true
+		}
+	}
+
+	// 检查共用日志的网站：格式须一致，且最多一个兜底网站
+	sharedSites := make(map[string]WebsiteConfig)
+	catchAllSites := make(map[string]string)
+	for _, site := range cfg.Websites {
+		logPath := filepath.Clean(site.LogPath)
+		if first, ok := sharedSites[logPath]; ok {
+			if first.Format != site.Format || first.LogFormat != site.LogFormat ||
+				first.RealIPHeader != site.RealIPHeader || !slices.Equal(first.TrustedProxies, site.TrustedProxies) {
+				fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 与 '%s' 共用日志 %s，但日志格式或真实 IP 配置不一致\n",
+					site.Name, first.Name, site.LogPath)
+				fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+				return true
+			}
+		} else {
+			sharedSites[logPath] = site
+		}
+
+		if site.CatchAll {
+			if other, ok := catchAllSites[logPath]; ok {
+				fmt.Fprintf(os.Stderr, "配置文件错误: 网站 '%s' 与 '%s' 不能同时作为日志 %s 的 catchAll 网站\n",
+					site.Name, other, site.LogPath)
+				fmt.Fprintf(os.Stderr, "请修正配置问题后重新启动服务\n")
+				return true
+			}
+			catchAllSites[logPath] = site.Name
+		}
+	}
+
 	// 如果有缺失的日志文件，返回错误
 	if len(missingLogs) > 0 {
 		errMsg := "以下网站的日志文件不存在:\n"
@@ -232,6 +374,46 @@ func validateConfig() bool {
 	return false
 }
 
+// validateGoals 检查网站的转化目标和漏斗：目标名称不能重复，漏斗至少两步且只能引用已定义的目标
+func validateGoals(site WebsiteConfig) error {
+	goals := make(map[string]bool)
+	for _, goal := range site.Goals {
+		if goal.Name == "" {
+			return fmt.Errorf("goals 中存在未命名的目标")
+		}
+		if goals[goal.Name] {
+			return fmt.Errorf("目标 %q 重复定义", goal.Name)
+		}
+		goals[goal.Name] = true
+		if goal.URL == "" {
+			return fmt.Errorf("目标 %q 缺少 url", goal.Name)
+		}
+		if _, err := regexp.Compile(goal.URL); err != nil {
+			return fmt.Errorf("目标 %q 的 url 不是有效的正则: %v", goal.Name, err)
+		}
+	}
+
+	funnels := make(map[string]bool)
+	for _, funnel := range site.Funnels {
+		if funnel.Name == "" {
+			return fmt.Errorf("funnels 中存在未命名的漏斗")
+		}
+		if funnels[funnel.Name] {
+			return fmt.Errorf("漏斗 %q 重复定义", funnel.Name)
+		}
+		funnels[funnel.Name] = true
+		if len(funnel.Steps) < 2 {
+			return fmt.Errorf("漏斗 %q 至少需要两个步骤", funnel.Name)
+		}
+		for _, step := range funnel.Steps {
+			if !goals[step] {
+				return fmt.Errorf("漏斗 %q 的步骤 %q 不是已定义的目标", funnel.Name, step)
+			}
+		}
+	}
+	return nil
+}
+
 // cleanService 清理 nixvis 服务、释放端口和删除数据
 func cleanService() {
 	fmt.Println("开始清理nixvis服务...")
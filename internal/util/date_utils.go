@@ -33,6 +33,25 @@ func TimePeriod(timeRange string) (time.Time, time.Time, error) {
 	return startTime, endTime, nil
 }
 
+// ParseDate 解析命令行或请求中的日期参数，为空时返回零值，endOfDay 为 true 时只含日期的参数取次日零点
+func ParseDate(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的日期 %q，格式应为 2006-01-02 或 RFC3339", value)
+	}
+	if endOfDay {
+		date = date.AddDate(0, 0, 1)
+	}
+	return date, nil
+}
+
 // TimePointsAndLabels 根据时间范围类型和视图类型直接返回时间点数组和标签数组
 func TimePointsAndLabels(
 	timeRangeType string, viewType string) ([]time.Time, []string) {
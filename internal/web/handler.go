@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io/fs"
 	"net/http"
+	"slices"
+	"time"
 
+	"github.com/beyondxinxin/nixvis/internal/export"
 	"github.com/beyondxinxin/nixvis/internal/stats"
 	"github.com/beyondxinxin/nixvis/internal/util"
 	"github.com/gin-gonic/gin"
@@ -103,4 +106,88 @@ func SetupRoutes(
 		c.JSON(http.StatusOK, result)
 	})
 
+	// 导出接口，type 为 logs 时流式导出原始日志，否则导出统计结果
+	router.GET("/api/export", func(c *gin.Context) {
+		params := make(map[string]string)
+		for key, values := range c.Request.URL.Query() {
+			if len(values) > 0 {
+				params[key] = values[0]
+			}
+		}
+		exportType := c.DefaultQuery("type", export.LogsType)
+		format := c.DefaultQuery("format", export.FormatCSV)
+		if !slices.Contains(export.Formats, format) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("format 参数无效，必须为以下值之一: %v", export.Formats),
+			})
+			return
+		}
+		if _, ok := util.GetWebsiteByID(params["id"]); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "id 参数无效",
+			})
+			return
+		}
+
+		filename := fmt.Sprintf("nixvis-%s-%s-%s.%s",
+			params["id"], exportType, time.Now().Format("20060102150405"), format)
+		if exportType != export.LogsType {
+			query, err := statsFactory.BuildQueryFromRequest(exportType, params)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			result, err := statsFactory.QueryStats(exportType, query)
+			if err != nil {
+				logrus.WithError(err).Errorf("查询统计数据[%s]失败", exportType)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("查询失败: %v", err),
+				})
+				return
+			}
+			if _, ok := result.(stats.Tabular); !ok {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("统计类型 %s 不支持导出", exportType),
+				})
+				return
+			}
+
+			setExportHeaders(c, format, filename)
+			if err := export.Stats(result, c.Writer, format); err != nil {
+				logrus.WithError(err).Errorf("导出统计数据[%s]失败", exportType)
+			}
+			return
+		}
+
+		start, err := util.ParseDate(params["from"], false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		end, err := util.ParseDate(params["to"], true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// 响应头发出后无法再返回错误状态码，导出中途失败只能记录日志
+		setExportHeaders(c, format, filename)
+		if _, err := export.Logs(statsFactory.Repository(), c.Writer, format, params["id"], start, end); err != nil {
+			logrus.WithError(err).Errorf("导出网站 %s 的日志失败", params["id"])
+		}
+	})
+
+}
+
+// setExportHeaders 设置导出文件的响应头，浏览器按附件下载
+func setExportHeaders(c *gin.Context, format, filename string) {
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
 }
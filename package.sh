@@ -17,7 +17,7 @@ echo " - 构建时间: ${BUILD_TIME}"
 echo " - Git提交: ${GIT_COMMIT}"
 
 echo "编译主程序..."
-go build -ldflags="-s -w -X 'github.com/beyondxinxin/nixvis/internal/util.BuildTime=${BUILD_TIME}' -X 'github.com/beyondxinxin/nixvis/internal/util.GitCommit=${GIT_COMMIT}'" -o nixvis ./cmd/nixvis/main.go
+go build -ldflags="-s -w -X 'github.com/beyondxinxin/nixvis/internal/util.BuildTime=${BUILD_TIME}' -X 'github.com/beyondxinxin/nixvis/internal/util.GitCommit=${GIT_COMMIT}'" -o nixvis ./cmd/nixvis
 
 if [ $? -eq 0 ]; then
     echo "构建成功! 可执行文件: nixvis"